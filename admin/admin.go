@@ -0,0 +1,427 @@
+// Package admin exposes the live state of an endpoint over HTTP.
+//
+//	http.Handle("/debug/telehash/", http.StripPrefix("/debug/telehash", admin.Handler(e)))
+//
+// All views are JSON documents:
+//
+//	GET  /                                      summary of the endpoint (all views below)
+//	GET  /identity                              the local identity
+//	GET  /exchanges                             all exchanges with their paths and channels
+//	GET  /exchanges/{hashname}                  a single exchange
+//	GET  /exchanges/{hashname}/channels         the open channels of an exchange
+//	GET  /exchanges/{hashname}/channels/{id}    a single channel
+//	GET  /listeners                             the channel listeners
+//	GET  /bridge/routes                         the packet routes of the bridge module
+//	GET  /nat/mappings                          the NAT port mappings
+//
+// The following actions are available:
+//
+//	POST /exchanges/{hashname}/close              close the exchange (and all its channels)
+//	POST /exchanges/{hashname}/channels/{id}/close  gracefully close the channel (in the background)
+//	POST /exchanges/{hashname}/channels/{id}/kill   close the channel immediately
+//
+// Actions must carry an X-Requested-With header (with any value); other POST
+// requests are rejected with 403 Forbidden. Browsers don't let a page from another
+// origin set this header without a CORS preflight (which the handler never
+// allows), so a malicious page can't trigger the actions through the browser
+// of an operator (CSRF).
+//
+// The handler gives full control over the endpoint; it must not be exposed to
+// untrusted clients.
+package admin
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/modules/bridge"
+	"github.com/telehash/gogotelehash/transports/nat"
+)
+
+// Handler returns an http.Handler which serves the state of e.
+func Handler(e *e3x.Endpoint) http.Handler {
+	return &handler{e: e}
+}
+
+type handler struct {
+	e *e3x.Endpoint
+}
+
+type endpointView struct {
+	Hashname     string          `json:"hashname"`
+	Identity     *e3x.Identity   `json:"identity,omitempty"`
+	Exchanges    []*exchangeView `json:"exchanges"`
+	Listeners    []listenerView  `json:"listeners"`
+	BridgeRoutes []routeView     `json:"bridge_routes"`
+	NATMappings  []mappingView   `json:"nat_mappings"`
+}
+
+type exchangeView struct {
+	Hashname    string         `json:"hashname"`
+	State       string         `json:"state"`
+	Reachable   bool           `json:"reachable"`
+	LocalToken  string         `json:"local_token"`
+	RemoteToken string         `json:"remote_token"`
+	Paths       []pathView     `json:"paths"`
+	Channels    []*channelView `json:"channels"`
+}
+
+type pathView struct {
+	Network   string    `json:"network"`
+	Addr      string    `json:"addr"`
+	Active    bool      `json:"active"`
+	Backup    bool      `json:"backup"`
+	Reachable bool      `json:"reachable"`
+	Latency   string    `json:"latency"`
+	EWMA      string    `json:"ewma"`
+	MTU       int       `json:"mtu"`
+	MaxSize   int       `json:"max_message_size"`
+	Added     time.Time `json:"added"`
+	ExpireAt  time.Time `json:"expire_at"`
+}
+
+type channelView struct {
+	ID           uint32 `json:"id"`
+	Type         string `json:"type"`
+	Reliable     bool   `json:"reliable"`
+	ServerSide   bool   `json:"server_side"`
+	Broken       bool   `json:"broken"`
+	DeliveredEnd bool   `json:"delivered_end"`
+	ReceivedEnd  bool   `json:"received_end"`
+
+	OSeq         uint32 `json:"oseq"`
+	OAckedSeq    uint32 `json:"oacked_seq"`
+	ISeq         uint32 `json:"iseq"`
+	ISeenSeq     uint32 `json:"iseen_seq"`
+	IBufferedSeq uint32 `json:"ibuffered_seq"`
+	IAckedSeq    uint32 `json:"iacked_seq"`
+
+	ReadBuffered  int `json:"read_buffered"`
+	ReadWindow    int `json:"read_window"`
+	WriteBuffered int `json:"write_buffered"`
+	WriteWindow   int `json:"write_window"`
+
+	PacketsSent   uint64 `json:"packets_sent"`
+	PacketsResent uint64 `json:"packets_resent"`
+	PacketsMissed uint64 `json:"packets_missed"`
+	Timeouts      uint64 `json:"timeouts"`
+	Retries       int    `json:"retries"`
+	MaxRetries    int    `json:"max_retries"`
+	RTT           string `json:"rtt"`
+	RTO           string `json:"rto"`
+	Window        int    `json:"window"`
+	RemoteWindow  int    `json:"remote_window"`
+}
+
+type listenerView struct {
+	Type       string `json:"type"`
+	Reliable   bool   `json:"reliable"`
+	Backlog    int    `json:"backlog"`
+	MaxBacklog int    `json:"max_backlog"`
+}
+
+type routeView struct {
+	Token  string `json:"token"`
+	Source string `json:"source"`
+}
+
+type mappingView struct {
+	Network  string `json:"network"`
+	Internal string `json:"internal"`
+	External string `json:"external"`
+}
+
+func (h *handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(req.URL.Path, "/")
+
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+
+	if len(parts) == 0 {
+		h.get(rw, req, h.endpoint)
+		return
+	}
+
+	switch parts[0] {
+	case "identity":
+		if len(parts) == 1 {
+			h.get(rw, req, func() (interface{}, bool) {
+				ident, err := h.e.LocalIdentity()
+				return ident, err == nil
+			})
+			return
+		}
+
+	case "exchanges":
+		h.serveExchanges(rw, req, parts[1:])
+		return
+
+	case "listeners":
+		if len(parts) == 1 {
+			h.get(rw, req, func() (interface{}, bool) { return h.listeners(), true })
+			return
+		}
+
+	case "bridge":
+		if len(parts) == 2 && parts[1] == "routes" {
+			h.get(rw, req, func() (interface{}, bool) { return h.routes(), true })
+			return
+		}
+
+	case "nat":
+		if len(parts) == 2 && parts[1] == "mappings" {
+			h.get(rw, req, func() (interface{}, bool) { return h.mappings(), true })
+			return
+		}
+	}
+
+	http.NotFound(rw, req)
+}
+
+func (h *handler) serveExchanges(rw http.ResponseWriter, req *http.Request, parts []string) {
+	if len(parts) == 0 {
+		h.get(rw, req, func() (interface{}, bool) { return h.exchanges(), true })
+		return
+	}
+
+	x := h.e.GetExchange(hashname.H(parts[0]))
+	if x == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		h.get(rw, req, func() (interface{}, bool) { return exchangeInfo(x), true })
+
+	case len(parts) == 2 && parts[1] == "close":
+		h.post(rw, req, http.StatusOK, func() { x.Close() })
+
+	case len(parts) == 2 && parts[1] == "channels":
+		h.get(rw, req, func() (interface{}, bool) { return channelInfos(x), true })
+
+	case len(parts) >= 3 && parts[1] == "channels":
+		id, err := strconv.ParseUint(parts[2], 10, 32)
+		if err != nil {
+			http.NotFound(rw, req)
+			return
+		}
+
+		c := x.GetChannel(uint32(id))
+		if c == nil {
+			http.NotFound(rw, req)
+			return
+		}
+
+		switch {
+		case len(parts) == 3:
+			h.get(rw, req, func() (interface{}, bool) { return channelInfo(c), true })
+		case len(parts) == 4 && parts[3] == "close":
+			h.post(rw, req, http.StatusAccepted, func() { go c.Close() })
+		case len(parts) == 4 && parts[3] == "kill":
+			h.post(rw, req, http.StatusOK, c.Kill)
+		default:
+			http.NotFound(rw, req)
+		}
+
+	default:
+		http.NotFound(rw, req)
+	}
+}
+
+func (h *handler) get(rw http.ResponseWriter, req *http.Request, view func() (interface{}, bool)) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		rw.Header().Set("Allow", "GET, HEAD")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	v, ok := view()
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	if req.Method == "GET" {
+		enc := json.NewEncoder(rw)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+	}
+}
+
+func (h *handler) post(rw http.ResponseWriter, req *http.Request, status int, action func()) {
+	if req.Method != "POST" {
+		rw.Header().Set("Allow", "POST")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if req.Header.Get("X-Requested-With") == "" {
+		// protect against cross-site requests (see the package documentation)
+		http.Error(rw, "missing X-Requested-With header", http.StatusForbidden)
+		return
+	}
+
+	action()
+
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.WriteHeader(status)
+	rw.Write([]byte("{}\n"))
+}
+
+func (h *handler) endpoint() (interface{}, bool) {
+	v := &endpointView{
+		Hashname:     string(h.e.LocalHashname()),
+		Exchanges:    h.exchanges(),
+		Listeners:    h.listeners(),
+		BridgeRoutes: h.routes(),
+		NATMappings:  h.mappings(),
+	}
+
+	if ident, err := h.e.LocalIdentity(); err == nil {
+		v.Identity = ident
+	}
+
+	return v, true
+}
+
+func (h *handler) exchanges() []*exchangeView {
+	exchanges := h.e.GetExchanges()
+	views := make([]*exchangeView, 0, len(exchanges))
+	for _, x := range exchanges {
+		views = append(views, exchangeInfo(x))
+	}
+	return views
+}
+
+func (h *handler) listeners() []listenerView {
+	listeners := h.e.Listeners()
+	views := make([]listenerView, 0, len(listeners))
+	for _, l := range listeners {
+		views = append(views, listenerView{
+			Type:       l.Type,
+			Reliable:   l.Reliable,
+			Backlog:    l.Backlog,
+			MaxBacklog: l.MaxBacklog,
+		})
+	}
+	return views
+}
+
+func (h *handler) routes() []routeView {
+	views := []routeView{}
+
+	b := bridge.FromEndpoint(h.e)
+	if b == nil {
+		return views
+	}
+
+	for _, r := range b.Routes() {
+		views = append(views, routeView{
+			Token:  hex.EncodeToString(r.Token[:]),
+			Source: string(r.Source),
+		})
+	}
+	return views
+}
+
+func (h *handler) mappings() []mappingView {
+	views := []mappingView{}
+
+	for _, m := range nat.Mappings(h.e.Transport()) {
+		views = append(views, mappingView{
+			Network:  m.Internal.Network(),
+			Internal: m.Internal.String(),
+			External: m.External.String(),
+		})
+	}
+	return views
+}
+
+func exchangeInfo(x *e3x.Exchange) *exchangeView {
+	localToken, remoteToken := x.LocalToken(), x.RemoteToken()
+
+	v := &exchangeView{
+		Hashname:    string(x.RemoteHashname()),
+		State:       x.State().String(),
+		Reachable:   x.Reachable(),
+		LocalToken:  hex.EncodeToString(localToken[:]),
+		RemoteToken: hex.EncodeToString(remoteToken[:]),
+		Paths:       []pathView{},
+		Channels:    channelInfos(x),
+	}
+
+	for _, p := range x.Paths() {
+		v.Paths = append(v.Paths, pathView{
+			Network:   p.Addr.Network(),
+			Addr:      p.Addr.String(),
+			Active:    p.Active,
+			Backup:    p.Backup,
+			Reachable: p.Reachable,
+			Latency:   p.Latency.String(),
+			EWMA:      p.EWMA.String(),
+			MTU:       p.MTU,
+			MaxSize:   p.MaxSize,
+			Added:     p.Added,
+			ExpireAt:  p.ExpireAt,
+		})
+	}
+
+	return v
+}
+
+func channelInfos(x *e3x.Exchange) []*channelView {
+	channels := x.Channels()
+	views := make([]*channelView, 0, len(channels))
+	for _, c := range channels {
+		views = append(views, channelInfo(c))
+	}
+	return views
+}
+
+func channelInfo(c *e3x.Channel) *channelView {
+	info := c.Info()
+
+	return &channelView{
+		ID:           info.ID,
+		Type:         info.Type,
+		Reliable:     info.Reliable,
+		ServerSide:   info.ServerSide,
+		Broken:       info.Broken,
+		DeliveredEnd: info.DeliveredEnd,
+		ReceivedEnd:  info.ReceivedEnd,
+
+		OSeq:         info.OSeq,
+		OAckedSeq:    info.OAckedSeq,
+		ISeq:         info.ISeq,
+		ISeenSeq:     info.ISeenSeq,
+		IBufferedSeq: info.IBufferedSeq,
+		IAckedSeq:    info.IAckedSeq,
+
+		ReadBuffered:  info.ReadBuffered,
+		ReadWindow:    info.ReadWindow,
+		WriteBuffered: info.WriteBuffered,
+		WriteWindow:   info.WriteWindow,
+
+		PacketsSent:   info.Stats.PacketsSent,
+		PacketsResent: info.Stats.PacketsResent,
+		PacketsMissed: info.Stats.PacketsMissed,
+		Timeouts:      info.Stats.Timeouts,
+		Retries:       info.Stats.Retries,
+		MaxRetries:    info.Stats.MaxRetries,
+		RTT:           info.Stats.RTT.String(),
+		RTO:           info.Stats.RTO.String(),
+		Window:        info.Stats.Window,
+		RemoteWindow:  info.Stats.RemoteWindow,
+	}
+}
@@ -0,0 +1,159 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := e3x.Open(e3x.Transport(inproc.Config{}), e3x.Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := e3x.Open(e3x.Transport(inproc.Config{}), e3x.Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	l := A.Listen("admin", true)
+	go func() {
+		for {
+			c, err := l.AcceptChannel()
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					if _, err := c.ReadPacket(); err != nil {
+						c.Close()
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	identA, err := A.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := B.Open(identA, "admin", true)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+
+	srv := httptest.NewServer(Handler(B))
+	defer srv.Close()
+
+	var summary endpointView
+	if !assert.Equal(200, get(srv.URL+"/", &summary)) {
+		return
+	}
+	assert.Equal(string(B.LocalHashname()), summary.Hashname)
+	assert.NotNil(summary.Identity)
+	assert.Equal(1, len(summary.Exchanges))
+
+	base := srv.URL + "/exchanges/" + string(identA.Hashname())
+
+	var x exchangeView
+	if !assert.Equal(200, get(base, &x)) {
+		return
+	}
+	assert.Equal(string(identA.Hashname()), x.Hashname)
+	assert.Equal("active", x.State)
+	assert.Equal(32, len(x.LocalToken))
+	if assert.Equal(1, len(x.Paths)) {
+		assert.True(x.Paths[0].Active)
+		assert.Equal("inproc", x.Paths[0].Network)
+	}
+	if !assert.Equal(1, len(x.Channels)) {
+		return
+	}
+	assert.Equal("admin", x.Channels[0].Type)
+	assert.True(x.Channels[0].Reliable)
+
+	id := x.Channels[0].ID
+	channel := fmt.Sprintf("%s/channels/%d", base, id)
+
+	var cv channelView
+	if assert.Equal(200, get(channel, &cv)) {
+		assert.Equal(id, cv.ID)
+		assert.True(cv.OSeq >= 1)
+	}
+
+	assert.Equal(404, get(srv.URL+"/exchanges/unknown", nil))
+	assert.Equal(404, get(fmt.Sprintf("%s/channels/%d", base, id+100), nil))
+	assert.Equal(405, get(channel+"/kill", nil))
+
+	var routes []routeView
+	assert.Equal(200, get(srv.URL+"/bridge/routes", &routes))
+	assert.Equal(0, len(routes))
+
+	var mappings []mappingView
+	assert.Equal(200, get(srv.URL+"/nat/mappings", &mappings))
+	assert.Equal(0, len(mappings))
+
+	// a cross-site form can't trigger an action
+	resp, err := http.Post(channel+"/kill", "application/x-www-form-urlencoded", nil)
+	if assert.NoError(err) {
+		resp.Body.Close()
+		assert.Equal(403, resp.StatusCode)
+	}
+	assert.Equal(200, get(channel, nil))
+
+	// kill the channel
+	assert.Equal(200, post(channel+"/kill"))
+	assert.Equal(404, get(channel, nil))
+
+	// close the exchange
+	assert.Equal(200, post(base+"/close"))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(404, get(base, nil))
+}
+
+func get(url string, v interface{}) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 200 && v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return 0
+		}
+	}
+	return resp.StatusCode
+}
+
+func post(url string) int {
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return 0
+	}
+	req.Header.Set("X-Requested-With", "admin_test")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
@@ -1,11 +1,15 @@
 package telehash
 
 import (
+	"context"
 	"encoding/json"
+	"io"
 	"net"
 	"time"
 
 	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/e3x/metrics"
+	"github.com/telehash/gogotelehash/e3x/tracer"
 	"github.com/telehash/gogotelehash/internal/hashname"
 	"github.com/telehash/gogotelehash/internal/lob"
 	"github.com/telehash/gogotelehash/transports"
@@ -16,10 +20,13 @@ import (
 
 type (
 	EndpointOption e3x.EndpointOption
+	ChannelOption  e3x.ChannelOption
+	ExchangeOption e3x.ExchangeOption
 	Endpoint       struct{ inner *e3x.Endpoint }
 	Exchange       struct{ inner *e3x.Exchange }
 	Listener       struct{ inner *e3x.Listener }
 	Channel        struct{ inner *e3x.Channel }
+	Stream         struct{ inner *e3x.Stream }
 	Hashname       hashname.H
 	Identity       struct{ inner *e3x.Identity }
 	Identifier     e3x.Identifier
@@ -30,6 +37,118 @@ func Transport(config transports.Config) EndpointOption {
 	return EndpointOption(e3x.Transport(config))
 }
 
+func KeyFile(path string) EndpointOption {
+	return EndpointOption(e3x.KeyFile(path))
+}
+
+func EncryptedKeyFile(path string, passphrase []byte) EndpointOption {
+	return EndpointOption(e3x.EncryptedKeyFile(path, passphrase))
+}
+
+func Metrics(m metrics.Metrics) EndpointOption {
+	return EndpointOption(e3x.Metrics(m))
+}
+
+func Tracer(sink tracer.Sink) EndpointOption {
+	return EndpointOption(e3x.Tracer(sink))
+}
+
+func Log(w io.Writer) EndpointOption {
+	return EndpointOption(e3x.Log(w))
+}
+
+func LogJSON(w io.Writer) EndpointOption {
+	return EndpointOption(e3x.LogJSON(w))
+}
+
+func ChannelDefaults(options ...ChannelOption) EndpointOption {
+	return EndpointOption(e3x.ChannelDefaults(innerChannelOptions(options)...))
+}
+
+func ReadWindow(n int) ChannelOption {
+	return ChannelOption(e3x.ReadWindow(n))
+}
+
+func WriteWindow(n int) ChannelOption {
+	return ChannelOption(e3x.WriteWindow(n))
+}
+
+func ResendInterval(d time.Duration) ChannelOption {
+	return ChannelOption(e3x.ResendInterval(d))
+}
+
+func AckInterval(d time.Duration) ChannelOption {
+	return ChannelOption(e3x.AckInterval(d))
+}
+
+func OpenTimeout(d time.Duration) ChannelOption {
+	return ChannelOption(e3x.OpenTimeout(d))
+}
+
+func CloseTimeout(d time.Duration) ChannelOption {
+	return ChannelOption(e3x.CloseTimeout(d))
+}
+
+func MaxRetries(n int) ChannelOption {
+	return ChannelOption(e3x.MaxRetries(n))
+}
+
+func ExchangeDefaults(options ...ExchangeOption) EndpointOption {
+	innerOptions := make([]e3x.ExchangeOption, len(options))
+	for i, option := range options {
+		innerOptions[i] = e3x.ExchangeOption(option)
+	}
+	return EndpointOption(e3x.ExchangeDefaults(innerOptions...))
+}
+
+func KeepAlive(d time.Duration) ExchangeOption {
+	return ExchangeOption(e3x.KeepAlive(d))
+}
+
+func UnreachableTimeout(d time.Duration) ExchangeOption {
+	return ExchangeOption(e3x.UnreachableTimeout(d))
+}
+
+func BreakTimeout(d time.Duration) ExchangeOption {
+	return ExchangeOption(e3x.BreakTimeout(d))
+}
+
+func IdleTimeout(d time.Duration) ExchangeOption {
+	return ExchangeOption(e3x.IdleTimeout(d))
+}
+
+func Priority(p int) ChannelOption {
+	return ChannelOption(e3x.Priority(p))
+}
+
+func SendQueueSize(n int) ExchangeOption {
+	return ExchangeOption(e3x.SendQueueSize(n))
+}
+
+func MTUProbeInterval(d time.Duration) ExchangeOption {
+	return ExchangeOption(e3x.MTUProbeInterval(d))
+}
+
+func MTUProbeTimeout(d time.Duration) ExchangeOption {
+	return ExchangeOption(e3x.MTUProbeTimeout(d))
+}
+
+func Fragmentation(maxMessageSize int) ChannelOption {
+	return ChannelOption(e3x.Fragmentation(maxMessageSize))
+}
+
+func ReassemblyTimeout(d time.Duration) ChannelOption {
+	return ChannelOption(e3x.ReassemblyTimeout(d))
+}
+
+func innerChannelOptions(options []ChannelOption) []e3x.ChannelOption {
+	innerOptions := make([]e3x.ChannelOption, len(options))
+	for i, option := range options {
+		innerOptions[i] = e3x.ChannelOption(option)
+	}
+	return innerOptions
+}
+
 func Open(options ...EndpointOption) (*Endpoint, error) {
 	innerOptions := make([]e3x.EndpointOption, len(options)+10)
 
@@ -52,8 +171,12 @@ func (e *Endpoint) Close() error {
 	return e.inner.Close()
 }
 
-func (e *Endpoint) Listen(typ string, reliable bool) *Listener {
-	return &Listener{e.inner.Listen(typ, reliable)}
+func (e *Endpoint) Shutdown(ctx context.Context) error {
+	return e.inner.Shutdown(ctx)
+}
+
+func (e *Endpoint) Listen(typ string, reliable bool, options ...ChannelOption) *Listener {
+	return &Listener{e.inner.Listen(typ, reliable, innerChannelOptions(options)...)}
 }
 
 func (e *Endpoint) LocalIdentity() (*Identity, error) {
@@ -74,8 +197,17 @@ func (e *Endpoint) Dial(identifier Identifier) (*Exchange, error) {
 	return &Exchange{inner}, nil
 }
 
-func (e *Endpoint) Open(identifier Identifier, typ string, reliable bool) (*Channel, error) {
-	inner, err := e.inner.Open(identifier, typ, reliable)
+func (e *Endpoint) DialContext(ctx context.Context, identifier Identifier) (*Exchange, error) {
+	inner, err := e.inner.DialContext(ctx, e3x.Identifier(identifier))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Exchange{inner}, nil
+}
+
+func (e *Endpoint) Open(identifier Identifier, typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	inner, err := e.inner.Open(identifier, typ, reliable, innerChannelOptions(options)...)
 	if err != nil {
 		return nil, err
 	}
@@ -83,12 +215,34 @@ func (e *Endpoint) Open(identifier Identifier, typ string, reliable bool) (*Chan
 	return &Channel{inner}, nil
 }
 
+func (e *Endpoint) OpenContext(ctx context.Context, identifier Identifier, typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	inner, err := e.inner.OpenContext(ctx, identifier, typ, reliable, innerChannelOptions(options)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Channel{inner}, nil
+}
+
+func (e *Endpoint) OpenStream(identifier Identifier, typ string, options ...ChannelOption) (*Stream, error) {
+	inner, err := e.inner.OpenStream(identifier, typ, innerChannelOptions(options)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stream{inner}, nil
+}
+
 func (x *Exchange) RemoteIdentity() *Identity {
 	return &Identity{x.inner.RemoteIdentity()}
 }
 
-func (x *Exchange) Open(typ string, reliable bool) (*Channel, error) {
-	inner, err := x.inner.Open(typ, reliable)
+func (x *Exchange) Reachable() bool {
+	return x.inner.Reachable()
+}
+
+func (x *Exchange) Open(typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	inner, err := x.inner.Open(typ, reliable, innerChannelOptions(options)...)
 	if err != nil {
 		return nil, err
 	}
@@ -96,12 +250,42 @@ func (x *Exchange) Open(typ string, reliable bool) (*Channel, error) {
 	return &Channel{inner}, nil
 }
 
+func (x *Exchange) OpenContext(ctx context.Context, typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	inner, err := x.inner.OpenContext(ctx, typ, reliable, innerChannelOptions(options)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Channel{inner}, nil
+}
+
+func (x *Exchange) OpenStream(typ string, options ...ChannelOption) (*Stream, error) {
+	inner, err := x.inner.OpenStream(typ, innerChannelOptions(options)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stream{inner}, nil
+}
+
 func (l *Listener) Addr() net.Addr {
 	return l.inner.Addr()
 }
 
+// Accept implements the net.Listener Accept method (see e3x.Listener.Accept).
 func (l *Listener) Accept() (net.Conn, error) {
-	return l.inner.Accept()
+	conn, err := l.inner.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	switch c := conn.(type) {
+	case *e3x.Stream:
+		return &Stream{c}, nil
+	case *e3x.Channel:
+		return &Channel{c}, nil
+	}
+	return conn, nil
 }
 
 func (l *Listener) AcceptChannel() (*Channel, error) {
@@ -113,6 +297,24 @@ func (l *Listener) AcceptChannel() (*Channel, error) {
 	return &Channel{inner}, nil
 }
 
+func (l *Listener) AcceptChannelContext(ctx context.Context) (*Channel, error) {
+	inner, err := l.inner.AcceptChannelContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Channel{inner}, nil
+}
+
+func (l *Listener) AcceptStream() (*Stream, error) {
+	inner, err := l.inner.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stream{inner}, nil
+}
+
 func (l *Listener) Close() error {
 	return l.inner.Close()
 }
@@ -129,6 +331,10 @@ func (c *Channel) WritePacket(pkt *Packet) error {
 	return c.inner.WritePacket((*lob.Packet)(pkt))
 }
 
+func (c *Channel) WritePacketContext(ctx context.Context, pkt *Packet) error {
+	return c.inner.WritePacketContext(ctx, (*lob.Packet)(pkt))
+}
+
 func (c *Channel) Write(b []byte) (int, error) {
 	return c.inner.Write(b)
 }
@@ -141,6 +347,14 @@ func (c *Channel) ReadPacket() (*Packet, error) {
 	return (*Packet)(inner), nil
 }
 
+func (c *Channel) ReadPacketContext(ctx context.Context) (*Packet, error) {
+	inner, err := c.inner.ReadPacketContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return (*Packet)(inner), nil
+}
+
 func (c *Channel) Read(b []byte) (int, error) {
 	return c.inner.Read(b)
 }
@@ -169,6 +383,50 @@ func (c *Channel) Close() error {
 	return c.inner.Close()
 }
 
+func (c *Channel) CloseWrite() error {
+	return c.inner.CloseWrite()
+}
+
+func (c *Channel) Stats() e3x.ChannelStats {
+	return c.inner.Stats()
+}
+
+func (s *Stream) LocalAddr() net.Addr {
+	return s.inner.LocalAddr()
+}
+
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.inner.RemoteAddr()
+}
+
+func (s *Stream) Read(b []byte) (int, error) {
+	return s.inner.Read(b)
+}
+
+func (s *Stream) Write(b []byte) (int, error) {
+	return s.inner.Write(b)
+}
+
+func (s *Stream) SetDeadline(d time.Time) error {
+	return s.inner.SetDeadline(d)
+}
+
+func (s *Stream) SetReadDeadline(d time.Time) error {
+	return s.inner.SetReadDeadline(d)
+}
+
+func (s *Stream) SetWriteDeadline(d time.Time) error {
+	return s.inner.SetWriteDeadline(d)
+}
+
+func (s *Stream) CloseWrite() error {
+	return s.inner.CloseWrite()
+}
+
+func (s *Stream) Close() error {
+	return s.inner.Close()
+}
+
 func (i *Identity) Hashname() Hashname {
 	return Hashname(i.inner.Hashname())
 }
@@ -0,0 +1,55 @@
+package e3x
+
+import (
+	"net"
+
+	"github.com/telehash/gogotelehash/e3x/capture"
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// Capture records all the messages sent and received by the endpoint (on all
+// its pipes) to w. When decrypted is true the decrypted channel packets are
+// recorded as well. Write errors are ignored; they are reported by w.Close.
+func Capture(w *capture.Writer, decrypted bool) EndpointOption {
+	return func(e *Endpoint) error {
+		e.capture = &endpointCapture{w: w, decrypted: decrypted}
+		return nil
+	}
+}
+
+// endpointCapture records the messages of an endpoint. A nil endpointCapture
+// records nothing.
+type endpointCapture struct {
+	w         *capture.Writer
+	decrypted bool
+}
+
+func (c *endpointCapture) raw(dir capture.Direction, local, remote net.Addr, msg []byte) {
+	if c == nil {
+		return
+	}
+
+	c.w.Write(capture.NewRecord(capture.Raw, dir, local, remote, msg))
+}
+
+func (c *endpointCapture) packet(dir capture.Direction, p *Pipe, pkt *lob.Packet) {
+	if c == nil || !c.decrypted {
+		return
+	}
+
+	msg, err := lob.Encode(pkt)
+	if err != nil {
+		return
+	}
+	defer msg.Free()
+
+	var local, remote net.Addr
+	if p != nil {
+		remote = p.raddr
+		if conn := p.currentConn(); conn != nil {
+			local = conn.LocalAddr()
+		}
+	}
+
+	c.w.Write(capture.NewRecord(capture.Packet, dir, local, remote, msg.Get(nil)))
+}
@@ -0,0 +1,325 @@
+// Package capture records the messages an endpoint sends and receives.
+//
+// A capture is a sequence of records. Raw records hold the messages as they
+// went over the wire (per pipe, with the addresses on both ends). As the
+// endpoint owns the keys, it can also record the decrypted channel packets
+// (lob encoded) as Packet records.
+//
+//	w, err := capture.Create("endpoint.thcap")
+//	e3x.Open(e3x.Capture(w, true))
+//
+// Captures can be read with a Reader and fed back into an endpoint with the
+// Replay transport.
+//
+// The file format is a magic header followed by the records. Every record is
+// encoded as (all integers are big endian):
+//
+//	uint32  length of the record (excluding this field)
+//	int64   time (unix nanoseconds)
+//	uint8   kind (1: raw, 2: packet)
+//	uint8   direction (1: in, 2: out)
+//	string  network of the remote address
+//	string  local address
+//	string  remote address
+//	bytes   data (remaining bytes)
+//
+// Strings are prefixed by their length as a uint16.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+// Magic is the header of every capture.
+const Magic = "THCAP\x00\x00\x01"
+
+const maxRecordLen = 1 << 20
+
+// ErrInvalidCapture is returned by a Reader when the input is not a capture or
+// a record is corrupted.
+var ErrInvalidCapture = errors.New("capture: invalid capture")
+
+// Kind is the kind of a record.
+type Kind uint8
+
+const (
+	// Raw records hold the messages as sent or received over a pipe.
+	Raw Kind = 1 + iota
+	// Packet records hold the decrypted (lob encoded) channel packets.
+	Packet
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Raw:
+		return "raw"
+	case Packet:
+		return "packet"
+	default:
+		return fmt.Sprintf("kind(%d)", uint8(k))
+	}
+}
+
+// Direction of a record.
+type Direction uint8
+
+const (
+	In Direction = 1 + iota
+	Out
+)
+
+func (d Direction) String() string {
+	switch d {
+	case In:
+		return "in"
+	case Out:
+		return "out"
+	default:
+		return fmt.Sprintf("direction(%d)", uint8(d))
+	}
+}
+
+// Record is a single captured message.
+type Record struct {
+	Time       time.Time
+	Kind       Kind
+	Direction  Direction
+	Network    string // network of the remote address
+	LocalAddr  string // empty when unknown
+	RemoteAddr string // empty when unknown
+	Data       []byte
+}
+
+// NewRecord returns a record for data. The addresses may be nil.
+func NewRecord(kind Kind, dir Direction, local, remote net.Addr, data []byte) *Record {
+	r := &Record{Time: time.Now(), Kind: kind, Direction: dir, Data: data}
+	if local != nil {
+		r.LocalAddr = local.String()
+	}
+	if remote != nil {
+		r.Network = remote.Network()
+		r.RemoteAddr = remote.String()
+	}
+	return r
+}
+
+// Packet decodes the data of a Packet record.
+func (r *Record) Packet() (*lob.Packet, error) {
+	if r.Kind != Packet {
+		return nil, fmt.Errorf("capture: %s record is not a packet", r.Kind)
+	}
+
+	buf := bufpool.New().Set(r.Data)
+	defer buf.Free()
+	return lob.Decode(buf)
+}
+
+func (r *Record) String() string {
+	return fmt.Sprintf("%s %s %s %s->%s (%d bytes)",
+		r.Time.Format(time.RFC3339Nano), r.Kind, r.Direction,
+		r.LocalAddr, r.RemoteAddr, len(r.Data))
+}
+
+// Writer writes records to a capture. It is safe for concurrent use.
+type Writer struct {
+	mtx sync.Mutex
+	w   *bufio.Writer
+	c   io.Closer
+	err error
+}
+
+// NewWriter writes the capture header to w and returns a Writer.
+func NewWriter(w io.Writer) (*Writer, error) {
+	cw := &Writer{w: bufio.NewWriter(w)}
+	if c, ok := w.(io.Closer); ok {
+		cw.c = c
+	}
+
+	if _, err := cw.w.WriteString(Magic); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// Create creates (or truncates) the file at path and returns a Writer for it.
+// Captures contain decrypted packets; the file is only accessible by its owner.
+func Create(path string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends r to the capture. Once a write failed all subsequent writes
+// fail with the same error.
+func (w *Writer) Write(r *Record) error {
+	if len(r.Network) > 0xffff || len(r.LocalAddr) > 0xffff || len(r.RemoteAddr) > 0xffff {
+		return fmt.Errorf("capture: address too long")
+	}
+
+	n := 8 + 1 + 1 + 2 + len(r.Network) + 2 + len(r.LocalAddr) + 2 + len(r.RemoteAddr) + len(r.Data)
+	if n > maxRecordLen {
+		return fmt.Errorf("capture: record too large")
+	}
+
+	buf := make([]byte, 4+n)
+	binary.BigEndian.PutUint32(buf, uint32(n))
+	binary.BigEndian.PutUint64(buf[4:], uint64(r.Time.UnixNano()))
+	buf[12] = byte(r.Kind)
+	buf[13] = byte(r.Direction)
+	off := 14
+	for _, s := range []string{r.Network, r.LocalAddr, r.RemoteAddr} {
+		binary.BigEndian.PutUint16(buf[off:], uint16(len(s)))
+		off += 2
+		off += copy(buf[off:], s)
+	}
+	copy(buf[off:], r.Data)
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	_, w.err = w.w.Write(buf)
+	return w.err
+}
+
+// Flush writes the buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.w.Flush()
+	return w.err
+}
+
+// Close flushes the buffered records and closes the underlying writer (when it
+// is an io.Closer).
+func (w *Writer) Close() error {
+	err := w.Flush()
+
+	if w.c != nil {
+		if cerr := w.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Reader reads records from a capture.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader reads the capture header from r and returns a Reader.
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := &Reader{r: bufio.NewReader(r)}
+
+	var magic [len(Magic)]byte
+	if _, err := io.ReadFull(cr.r, magic[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidCapture
+		}
+		return nil, err
+	}
+	if string(magic[:]) != Magic {
+		return nil, ErrInvalidCapture
+	}
+
+	return cr, nil
+}
+
+// Next returns the next record. io.EOF is returned at the end of the capture.
+func (r *Reader) Next() (*Record, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidCapture
+		}
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n < 16 || n > maxRecordLen {
+		return nil, ErrInvalidCapture
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidCapture
+		}
+		return nil, err
+	}
+
+	rec := &Record{
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(buf))),
+		Kind:      Kind(buf[8]),
+		Direction: Direction(buf[9]),
+	}
+
+	off := 10
+	for _, s := range []*string{&rec.Network, &rec.LocalAddr, &rec.RemoteAddr} {
+		if off+2 > len(buf) {
+			return nil, ErrInvalidCapture
+		}
+		l := int(binary.BigEndian.Uint16(buf[off:]))
+		off += 2
+		if off+l > len(buf) {
+			return nil, ErrInvalidCapture
+		}
+		*s = string(buf[off : off+l])
+		off += l
+	}
+	rec.Data = buf[off:]
+
+	return rec, nil
+}
+
+// ReadFile reads all the records of the capture at path.
+func ReadFile(path string) ([]*Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*Record
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
@@ -0,0 +1,123 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+func TestWriteRead(t *testing.T) {
+	assert := assert.New(t)
+
+	pkt := lob.New([]byte("hello"))
+	pkt.Header().SetString("name", "ping")
+	msg, err := lob.Encode(pkt)
+	if !assert.NoError(err) {
+		return
+	}
+	pkt.Free()
+
+	records := []*Record{
+		{
+			Time:       time.Unix(0, 1234),
+			Kind:       Raw,
+			Direction:  In,
+			Network:    "udp4",
+			LocalAddr:  "127.0.0.1:42424",
+			RemoteAddr: "127.0.0.1:5000",
+			Data:       []byte{0, 1, 0x3a, 1, 2, 3},
+		},
+		{
+			Time:      time.Unix(0, 5678),
+			Kind:      Packet,
+			Direction: Out,
+			Data:      msg.Get(nil),
+		},
+	}
+	msg.Free()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if !assert.NoError(err) {
+		return
+	}
+	for _, r := range records {
+		assert.NoError(w.Write(r))
+	}
+	assert.NoError(w.Close())
+
+	r, err := NewReader(&buf)
+	if !assert.NoError(err) {
+		return
+	}
+	for _, expected := range records {
+		rec, err := r.Next()
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(expected.Time.UnixNano(), rec.Time.UnixNano())
+		rec.Time = expected.Time
+		assert.Equal(expected, rec)
+	}
+	_, err = r.Next()
+	assert.Equal(io.EOF, err)
+
+	pkt, err = records[1].Packet()
+	if assert.NoError(err) {
+		name, _ := pkt.Header().GetString("name")
+		assert.Equal("ping", name)
+		assert.Equal("hello", string(pkt.Body(nil)))
+		pkt.Free()
+	}
+	_, err = records[0].Packet()
+	assert.Error(err)
+}
+
+func TestInvalidCapture(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewReader(bytes.NewReader([]byte("not a capture")))
+	assert.Equal(ErrInvalidCapture, err)
+
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf)
+	w.Write(&Record{Kind: Raw, Direction: In, Data: []byte("data")})
+	w.Flush()
+
+	// truncated record
+	r, err := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if assert.NoError(err) {
+		_, err = r.Next()
+		assert.Equal(ErrInvalidCapture, err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "e3x-capture")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "trace.thcap")
+	w, err := Create(path)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(w.Close())
+
+	info, err := os.Stat(path)
+	if assert.NoError(err) {
+		assert.Equal(os.FileMode(0600), info.Mode().Perm())
+	}
+}
@@ -0,0 +1,180 @@
+package capture
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/dgram"
+)
+
+// Replay is a transport which feeds the inbound raw records of a capture to an
+// endpoint, as if they were received from their original remote addresses.
+// All other records are ignored and the messages sent by the endpoint are
+// discarded. Replay is meant for tests:
+//
+//	records, _ := capture.ReadFile("interop.thcap")
+//	replay := capture.NewReplay(records)
+//	e, _ := e3x.Open(e3x.Keys(keys), e3x.Transport(replay))
+//	replay.Wait()
+//
+// A Replay can only be opened once.
+type Replay struct {
+	// Realtime preserves the time between the records. By default the
+	// records are delivered as fast as the endpoint reads them.
+	Realtime bool
+
+	records []*Record
+	done    chan struct{}
+	once    sync.Once
+
+	mtx     sync.Mutex
+	written []*Record
+}
+
+type replayTransport struct {
+	replay *Replay
+	c      chan *Record
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Addr is the address of a peer in a replayed capture.
+type Addr struct {
+	Net  string
+	Addr string
+}
+
+var (
+	_ transports.Config = (*Replay)(nil)
+	_ dgram.Transport   = (*replayTransport)(nil)
+	_ dgram.Addr        = (*Addr)(nil)
+)
+
+// NewReplay returns a replay of records.
+func NewReplay(records []*Record) *Replay {
+	return &Replay{records: records, done: make(chan struct{})}
+}
+
+// Open opens the transport.
+func (r *Replay) Open() (transports.Transport, error) {
+	t := &replayTransport{
+		replay: r,
+		c:      make(chan *Record),
+		closed: make(chan struct{}),
+	}
+
+	started := false
+	r.once.Do(func() {
+		started = true
+		go t.run()
+	})
+	if !started {
+		panic("capture: replay was already opened")
+	}
+
+	return dgram.Wrap(t)
+}
+
+// Wait blocks until all the records were handed to the endpoint (or the
+// transport was closed). The endpoint may still be processing the last
+// messages.
+func (r *Replay) Wait() {
+	<-r.done
+}
+
+// Written returns the messages written by the endpoint as Raw Out records.
+func (r *Replay) Written() []*Record {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]*Record(nil), r.written...)
+}
+
+func (t *replayTransport) run() {
+	defer close(t.replay.done)
+
+	var last time.Time
+	for _, rec := range t.replay.records {
+		if rec.Kind != Raw || rec.Direction != In {
+			continue
+		}
+
+		if t.replay.Realtime && !last.IsZero() {
+			if d := rec.Time.Sub(last); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-t.closed:
+					return
+				}
+			}
+		}
+		last = rec.Time
+
+		select {
+		case t.c <- rec:
+		case <-t.closed:
+			return
+		}
+	}
+}
+
+func (t *replayTransport) NormalizeAddr(addr net.Addr) (dgram.Addr, error) {
+	if a, ok := addr.(*Addr); ok {
+		return a, nil
+	}
+	return nil, transports.ErrInvalidAddr
+}
+
+func (t *replayTransport) Read(p []byte) (int, dgram.Addr, error) {
+	select {
+	case rec := <-t.c:
+		return copy(p, rec.Data), &Addr{Net: rec.Network, Addr: rec.RemoteAddr}, nil
+	case <-t.closed:
+		return 0, nil, io.EOF
+	}
+}
+
+func (t *replayTransport) Write(p []byte, dst dgram.Addr) (int, error) {
+	a, ok := dst.(*Addr)
+	if !ok || a == nil {
+		return 0, transports.ErrInvalidAddr
+	}
+
+	rec := &Record{
+		Time:       time.Now(),
+		Kind:       Raw,
+		Direction:  Out,
+		Network:    a.Net,
+		RemoteAddr: a.Addr,
+		Data:       append([]byte(nil), p...),
+	}
+
+	t.replay.mtx.Lock()
+	t.replay.written = append(t.replay.written, rec)
+	t.replay.mtx.Unlock()
+
+	return len(p), nil
+}
+
+func (t *replayTransport) Addrs() []net.Addr {
+	return nil
+}
+
+func (t *replayTransport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+func (a *Addr) Network() string {
+	return a.Net
+}
+
+func (a *Addr) String() string {
+	return a.Addr
+}
+
+func (a *Addr) Key() interface{} {
+	return *a
+}
@@ -0,0 +1,144 @@
+package e3x
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x/capture"
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/e3x/metrics"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestCaptureAndReplay(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	key, err := cipherset.GenerateKey(0x3a)
+	if !assert.NoError(err) {
+		return
+	}
+	keys := cipherset.Keys{0x3a: key}
+
+	var buf bytes.Buffer
+	w, err := capture.NewWriter(&buf)
+	if !assert.NoError(err) {
+		return
+	}
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Keys(keys), Transport(inproc.Config{}), Capture(w, true), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	identB, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		c, err := B.Listen("ping", false).AcceptChannel()
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Kill()
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		pkt, err := c.ReadPacket()
+		if assert.NoError(err) {
+			assert.NoError(c.WritePacket(pkt))
+		}
+	}()
+
+	c, err := A.Open(identB, "ping", false)
+	if !assert.NoError(err) {
+		return
+	}
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	assert.NoError(c.WritePacket(lob.New([]byte("ping"))))
+	pkt, err := c.ReadPacket()
+	if assert.NoError(err) {
+		pkt.Free()
+	}
+	<-served
+	c.Kill()
+	A.Close()
+	B.Close()
+	assert.NoError(w.Flush())
+
+	r, err := capture.NewReader(&buf)
+	if !assert.NoError(err) {
+		return
+	}
+
+	var (
+		records []*capture.Record
+		counts  = map[string]int{}
+	)
+	for {
+		rec, err := r.Next()
+		if err != nil {
+			break
+		}
+		records = append(records, rec)
+		counts[rec.Kind.String()+" "+rec.Direction.String()]++
+
+		if rec.Kind == capture.Raw {
+			assert.Equal("inproc", rec.Network)
+			assert.NotEqual("", rec.LocalAddr)
+			assert.NotEqual("", rec.RemoteAddr)
+		}
+
+		if rec.Kind == capture.Packet && rec.Direction == capture.In {
+			pkt, err := rec.Packet()
+			if assert.NoError(err) {
+				assert.Equal("ping", pkt.Header().Type)
+				assert.Equal("ping", string(pkt.Body(nil)))
+				pkt.Free()
+			}
+		}
+	}
+	assert.True(counts["raw in"] >= 2, "raw in: %d", counts["raw in"])
+	assert.True(counts["raw out"] >= 2, "raw out: %d", counts["raw out"])
+	assert.Equal(1, counts["packet in"])
+	assert.Equal(1, counts["packet out"])
+
+	// replay the capture into a fresh endpoint with the keys of B
+	m := metrics.NewPrometheus()
+	replay := capture.NewReplay(records)
+	C, err := Open(Keys(keys), Transport(replay), Metrics(m), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer C.Close()
+
+	replay.Wait()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(replay.Written()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var out bytes.Buffer
+	m.WriteTo(&out)
+	assert.True(strings.Contains(out.String(), `e3x_handshakes_total{direction="in"`), out.String())
+
+	// C responded to the handshake of A
+	if written := replay.Written(); assert.NotEqual(0, len(written)) {
+		assert.Equal(records[0].RemoteAddr, written[0].RemoteAddr)
+	}
+}
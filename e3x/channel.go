@@ -1,6 +1,7 @@
 package e3x
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -11,9 +12,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/telehash/gogotelehash/e3x/tracer"
 	"github.com/telehash/gogotelehash/internal/hashname"
 	"github.com/telehash/gogotelehash/internal/lob"
-	"github.com/telehash/gogotelehash/internal/util/tracer"
 )
 
 var (
@@ -39,11 +40,8 @@ func (err *BrokenChannelError) Error() string {
 }
 
 const (
-	cReadBufferSize  = 100
-	cWriteBufferSize = 100
-	earlyAdHocAck    = 50
-	cBlankSeq        = uint32(0)
-	cInitialSeq      = uint32(1)
+	cBlankSeq   = uint32(0)
+	cInitialSeq = uint32(1)
 )
 
 type Channel struct {
@@ -62,6 +60,9 @@ type Channel struct {
 	hashname     hashname.H
 	reliable     bool
 	broken       bool
+	cfg          channelConfig
+	metrics      *endpointMetrics
+	tracer       *tracer.Tracer
 
 	oSeq         uint32 // highest seq in write stream
 	iBufferedSeq uint32 // highest buffered seq in read stream
@@ -69,19 +70,30 @@ type Channel struct {
 	iSeq         uint32 // highest seq in read stream
 	oAckedSeq    uint32 // highest acked seq in write stream
 	iAckedSeq    uint32 // highest acked seq in read stream
+	oWindow      uint32 // window advertised by the remote endpoint (0 when unknown)
+	nextFragID   uint32 // id of the last fragmented packet
 
 	deliveredEnd bool
 	receivedEnd  bool
 	readEnd      bool
 	needsResend  bool
+	ackPending   bool
+
+	cc         congestion
+	lastResend time.Time // time of the last retransmission
+	oAckedAt   time.Time // time the last ack was received
+	oMissing   []uint32  // seqs reported missing by the last ack
+	stats      ChannelStats
 
 	openDeadlineReached  bool
 	writeDeadlineReached bool
 	readDeadlineReached  bool
 	closeDeadlineReached bool
+	retriesExceeded      bool
 
-	readBuffer  readBufferSlice
-	writeBuffer map[uint32]*writeBufferEntry
+	readBuffer   readBufferSlice
+	writeBuffer  map[uint32]*writeBufferEntry
+	reassemblies map[uint32]*reassembly
 
 	tOpenDeadline  *time.Timer
 	tCloseDeadline *time.Timer
@@ -94,7 +106,8 @@ type Channel struct {
 type ChannelOption func(*Channel) error
 
 type exchangeI interface {
-	deliverPacket(pkt *lob.Packet, dst *Pipe) error
+	deliverPacket(pkt *lob.Packet, dst *Pipe, priority int) error
+	tryDeliverPacket(pkt *lob.Packet, dst *Pipe, priority int) error
 	RemoteIdentity() *Identity
 	getTID() tracer.ID
 }
@@ -108,7 +121,10 @@ type readBufferEntry struct {
 type writeBufferEntry struct {
 	pkt        *lob.Packet
 	end        bool
+	sent       time.Time
 	lastResend time.Time
+	retries    int
+	probes     int // retransmissions while the remote reader was behind
 	dst        *Pipe
 }
 
@@ -117,7 +133,7 @@ func newChannel(
 	reliable bool, serverside bool,
 	x exchangeI,
 	options ...ChannelOption,
-) *Channel {
+) (*Channel, error) {
 	c := &Channel{
 		TID:          tracer.NewID(),
 		x:            x,
@@ -125,8 +141,9 @@ func newChannel(
 		typ:          typ,
 		reliable:     reliable,
 		serverside:   serverside,
-		readBuffer:   make([]*readBufferEntry, 0, cReadBufferSize),
-		writeBuffer:  make(map[uint32]*writeBufferEntry, cWriteBufferSize),
+		cfg:          defaultChannelConfig,
+		metrics:      discardMetrics,
+		tracer:       tracer.Default,
 		oSeq:         cBlankSeq,
 		iBufferedSeq: cBlankSeq,
 		iSeenSeq:     cBlankSeq,
@@ -139,6 +156,15 @@ func newChannel(
 	c.cndWrite = sync.NewCond(&c.mtx)
 	c.cndClose = sync.NewCond(&c.mtx)
 
+	err := c.setOptions(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.readBuffer = make([]*readBufferEntry, 0, c.cfg.readWindow)
+	c.writeBuffer = make(map[uint32]*writeBufferEntry, c.cfg.writeWindow)
+	c.cc.init(c.cfg.resendInterval, c.cfg.writeWindow)
+
 	c.setOpenDeadline()
 
 	c.tReadDeadline = time.AfterFunc(10*time.Second, c.onReadDeadlineReached)
@@ -147,14 +173,13 @@ func newChannel(
 	c.tWriteDeadline.Stop()
 
 	if reliable {
-		c.tResend = time.AfterFunc(1*time.Second, c.resendLastPacket)
-		c.tAcker = time.AfterFunc(10*time.Second, c.autoDeliverAck)
+		c.tResend = time.AfterFunc(c.cc.rto, c.resendOldestPacket)
+		c.tAcker = time.AfterFunc(c.cfg.ackInterval, c.autoDeliverAck)
 	}
 
-	c.setOptions(options...)
 	c.traceNew()
 
-	return c
+	return c, nil
 }
 
 func (c *Channel) setOptions(options ...ChannelOption) error {
@@ -170,26 +195,41 @@ func registerExchange(x *Exchange) ChannelOption {
 	return func(c *Channel) error {
 		c.channelHooks = x.channelHooks
 		c.channelHooks.channel = c
+		c.metrics = x.metrics
+		c.tracer = x.tracer
 		return nil
 	}
 }
 
 func (c *Channel) traceNew() {
-	if tracer.Enabled {
-		tracer.Emit("channel.new", tracer.Info{
-			"exchange_id": c.x.getTID(),
-			"channel_id":  c.TID,
+	if c.tracer.Enabled() {
+		info := tracer.Info{
+			"channel_id": c.TID,
 			"channel": tracer.Info{
 				"type":     c.typ,
 				"reliable": c.reliable,
 				"cid":      c.id,
 			},
+		}
+
+		if c.x != nil {
+			info["exchange_id"] = c.x.getTID()
+		}
+
+		c.tracer.Emit("channel.new", info)
+	}
+}
+
+func (c *Channel) traceClosed() {
+	if c.tracer.Enabled() {
+		c.tracer.Emit("channel.closed", tracer.Info{
+			"channel_id": c.TID,
 		})
 	}
 }
 
 func (c *Channel) traceWriteError(pkt *lob.Packet, p *Pipe, reason error) error {
-	if tracer.Enabled {
+	if c.tracer.Enabled() {
 		info := tracer.Info{
 			"channel_id": c.TID,
 			"reason":     reason.Error(),
@@ -202,18 +242,18 @@ func (c *Channel) traceWriteError(pkt *lob.Packet, p *Pipe, reason error) error
 		if pkt != nil {
 			info["packet_id"] = pkt.TID
 			info["packet"] = tracer.Info{
-				"header": pkt.Header(),
+				"header": *pkt.Header(),
 				"body":   base64.StdEncoding.EncodeToString(pkt.Body(nil)),
 			}
 		}
 
-		tracer.Emit("channel.write.error", info)
+		c.tracer.Emit("channel.write.error", info)
 	}
 	return reason
 }
 
 func (c *Channel) traceWrite(pkt *lob.Packet, p *Pipe) {
-	if tracer.Enabled {
+	if c.tracer.Enabled() {
 		info := tracer.Info{
 			"channel_id": c.TID,
 		}
@@ -225,17 +265,17 @@ func (c *Channel) traceWrite(pkt *lob.Packet, p *Pipe) {
 		if pkt != nil {
 			info["packet_id"] = pkt.TID
 			info["packet"] = tracer.Info{
-				"header": pkt.Header(),
+				"header": *pkt.Header(),
 				"body":   base64.StdEncoding.EncodeToString(pkt.Body(nil)),
 			}
 		}
 
-		tracer.Emit("channel.write", info)
+		c.tracer.Emit("channel.write", info)
 	}
 }
 
 func (c *Channel) traceDroppedPacket(pkt *lob.Packet, reason string) {
-	if tracer.Enabled {
+	if c.tracer.Enabled() {
 		info := tracer.Info{
 			"channel_id": c.TID,
 			"packet_id":  pkt.TID,
@@ -244,22 +284,22 @@ func (c *Channel) traceDroppedPacket(pkt *lob.Packet, reason string) {
 
 		if pkt != nil {
 			info["packet"] = tracer.Info{
-				"header": pkt.Header(),
+				"header": *pkt.Header(),
 				"body":   base64.StdEncoding.EncodeToString(pkt.Body(nil)),
 			}
 		}
 
-		tracer.Emit("channel.rcv.packet", info)
+		c.tracer.Emit("channel.rcv.packet", info)
 	}
 }
 
 func (c *Channel) traceReceivedPacket(pkt *lob.Packet) {
-	if tracer.Enabled {
-		tracer.Emit("channel.rcv.packet", tracer.Info{
+	if c.tracer.Enabled() {
+		c.tracer.Emit("channel.rcv.packet", tracer.Info{
 			"channel_id": c.TID,
 			"packet_id":  pkt.TID,
 			"packet": tracer.Info{
-				"header": pkt.Header(),
+				"header": *pkt.Header(),
 				"body":   base64.StdEncoding.EncodeToString(pkt.Body(nil)),
 			},
 		})
@@ -283,30 +323,57 @@ func (c *Channel) Exchange() *Exchange {
 	return nil
 }
 
-func (e *Endpoint) Open(i Identifier, typ string, reliable bool) (*Channel, error) {
-	x, err := e.Dial(i)
+func (e *Endpoint) Open(i Identifier, typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	return e.OpenContext(context.Background(), i, typ, reliable, options...)
+}
+
+// OpenContext is like Open but gives up waiting for the exchange when ctx is done.
+func (e *Endpoint) OpenContext(ctx context.Context, i Identifier, typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	x, err := e.DialContext(ctx, i)
 	if err != nil {
 		return nil, err
 	}
 
-	return x.Open(typ, reliable)
+	return x.OpenContext(ctx, typ, reliable, options...)
 }
 
 func (c *Channel) WritePacket(pkt *lob.Packet) error {
 	return c.WritePacketTo(pkt, nil)
 }
 
+// WritePacketContext is like WritePacket but returns ctx.Err() when ctx is done
+// before the packet could be written.
+func (c *Channel) WritePacketContext(ctx context.Context, pkt *lob.Packet) error {
+	return c.writePacketTo(ctx, pkt, nil)
+}
+
 func (c *Channel) WritePacketTo(pkt *lob.Packet, p *Pipe) error {
+	return c.writePacketTo(context.Background(), pkt, p)
+}
+
+func (c *Channel) writePacketTo(ctx context.Context, pkt *lob.Packet, p *Pipe) error {
 	if c == nil {
 		return os.ErrInvalid
 	}
 
 	c.mtx.Lock()
+	stop := wakeOnDone(ctx, &c.mtx, c.cndWrite)
+	defer stop()
+
 	for c.blockWrite() {
+		if err := ctx.Err(); err != nil {
+			c.mtx.Unlock()
+			return err
+		}
 		c.cndWrite.Wait()
 	}
 
-	err := c.write(pkt, p)
+	var err error
+	if c.fragmentationEnabled() && !pkt.Header().HasEnd {
+		err = c.writeMessage(ctx, pkt, p)
+	} else {
+		err = c.write(pkt, p)
+	}
 
 	if !c.blockWrite() {
 		c.cndWrite.Signal()
@@ -325,6 +392,11 @@ func (c *Channel) blockWrite() bool {
 		return false
 	}
 
+	if c.broken {
+		// Never block when the channel is broken; the write fails
+		return false
+	}
+
 	if c.serverside && c.iSeq == cBlankSeq {
 		// When a server channel did not (yet) read an initial packet
 		// then all writes must be deferred.
@@ -337,12 +409,24 @@ func (c *Channel) blockWrite() bool {
 		return true
 	}
 
-	if len(c.writeBuffer) >= cWriteBufferSize {
+	if c.reliable && c.oWindow > 0 && c.oSeq >= c.oAckedSeq+c.oWindow {
+		// When the next packet is beyond the window advertised by the
+		// remote endpoint then all writes must be deferred.
+		return true
+	}
+
+	if len(c.writeBuffer) >= c.cfg.writeWindow {
 		// When a channel filled its write buffer then
 		// all writes must be deferred.
 		return true
 	}
 
+	if len(c.writeBuffer) >= c.cc.window() {
+		// When a channel has a full congestion window in flight then
+		// all writes must be deferred.
+		return true
+	}
+
 	return false
 }
 
@@ -389,20 +473,21 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 	}
 
 	if c.reliable {
-		if c.oSeq%30 == 0 || hdr.End {
+		if c.oSeq%30 == 0 || c.oSeq == cInitialSeq || hdr.End {
 			c.applyAckHeaders(pkt)
 		}
-		c.writeBuffer[c.oSeq] = &writeBufferEntry{pkt, end, time.Time{}, p}
+		c.writeBuffer[c.oSeq] = &writeBufferEntry{pkt: pkt, end: end, sent: time.Now(), dst: p}
 		c.needsResend = false
 	}
 
-	err := c.x.deliverPacket(pkt, p)
+	err := c.x.deliverPacket(pkt, p, c.cfg.priority)
 	if err != nil {
 		return c.traceWriteError(pkt, p, err)
 	}
-	statChannelSndPkt.Add(1)
+	c.metrics.channelPacketsSent.Add(1)
+	c.stats.PacketsSent++
 	if pkt.Header().HasAck {
-		statChannelSndAckInline.Add(1)
+		c.metrics.channelAcksSentInline.Add(1)
 	}
 
 	if c.oSeq == cInitialSeq && c.serverside {
@@ -419,22 +504,47 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 }
 
 func (c *Channel) ReadPacket() (*lob.Packet, error) {
+	return c.ReadPacketContext(context.Background())
+}
+
+// ReadPacketContext is like ReadPacket but returns ctx.Err() when ctx is done
+// before a packet was received.
+func (c *Channel) ReadPacketContext(ctx context.Context) (*lob.Packet, error) {
 	if c == nil {
 		return nil, os.ErrInvalid
 	}
 
 	c.mtx.Lock()
-	for c.blockRead() {
-		c.cndRead.Wait()
-	}
+	stop := wakeOnDone(ctx, &c.mtx, c.cndRead)
+	defer stop()
 
-	pkt, err := c.peekPacket()
-	if pkt != nil {
-		c.readPacket()
-	}
+	for {
+		for c.blockRead() {
+			if err := ctx.Err(); err != nil {
+				c.mtx.Unlock()
+				return nil, err
+			}
+			c.cndRead.Wait()
+		}
 
-	c.mtx.Unlock()
-	return pkt, err
+		pkt, err := c.peekPacket()
+		if pkt != nil {
+			c.readPacket()
+		}
+
+		if pkt != nil && c.reliable && c.fragmentationEnabled() {
+			if _, ok := pkt.Header().Get(hdrFragmentID); ok {
+				pkt = c.reassemble(pkt)
+				if pkt == nil {
+					// wait for the remaining fragments
+					continue
+				}
+			}
+		}
+
+		c.mtx.Unlock()
+		return pkt, err
+	}
 }
 
 func (c *Channel) blockRead() bool {
@@ -456,9 +566,11 @@ func (c *Channel) blockRead() bool {
 		return false
 	}
 
-	if c.serverside && c.oSeq == cBlankSeq && c.iSeq >= cInitialSeq {
+	if c.serverside && c.oSeq == cBlankSeq && c.iSeq >= cInitialSeq &&
+		!(c.reliable && len(c.reassemblies) > 0) {
 		// When a server channel read a packet but did not yet respond
-		// to the initial packet then subsequent reads must be deferred.
+		// to the initial packet then subsequent reads must be deferred
+		// (unless the initial packet is still being reassembled).
 		return true
 	}
 
@@ -507,6 +619,7 @@ func (c *Channel) peekPacket() (*lob.Packet, error) {
 		h.HasSeq = false
 		h.HasType = false
 		h.HasEnd = false
+		h.HasWindow = false
 	}
 
 	if e.pkt.BodyLen() == 0 && e.pkt.Header().IsZero() && e.end {
@@ -563,10 +676,21 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 	if c.broken {
 		c.mtx.Unlock()
 		c.traceDroppedPacket(pkt, errBrokenChannel)
-		statChannelRcvPktDrop.Add(1)
+		c.metrics.dropped(errBrokenChannel)
 		return
 	}
 
+	if !c.reliable && c.fragmentationEnabled() {
+		if _, ok := pkt.Header().Get(hdrFragmentID); ok {
+			pkt = c.reassemble(pkt)
+			if pkt == nil {
+				// wait for the remaining fragments
+				c.mtx.Unlock()
+				return
+			}
+		}
+	}
+
 	var (
 		hdr           = pkt.Header()
 		seq, hasSeq   = hdr.Seq, hdr.HasSeq
@@ -575,6 +699,14 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 		end, hasEnd   = hdr.End, hdr.HasEnd
 	)
 
+	if c.reliable && hdr.HasWindow && hdr.Window != c.oWindow {
+		// the remote reader advertised a new window
+		if hdr.Window > c.oWindow {
+			c.cndWrite.Signal()
+		}
+		c.oWindow = hdr.Window
+	}
+
 	if !c.reliable {
 		// unreliable channels (internaly) emulate reliable channels.
 		seq = c.iBufferedSeq + 1
@@ -584,29 +716,57 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 		// determine what to drop from the write buffer
 		if hasAck {
 			if hasSeq {
-				statChannelRcvAckInline.Add(1)
+				c.metrics.channelAcksRcvdInline.Add(1)
 			} else {
-				statChannelRcvAckAdHoc.Add(1)
+				c.metrics.channelAcksRcvdAdHoc.Add(1)
 			}
 
 			var (
 				oldAck  = c.oAckedSeq
 				changed bool
+				acked   int
 			)
 
+			c.oAckedAt = time.Now()
+			c.oMissing = c.oMissing[:0]
+			if hasMiss {
+				last := ack
+				for _, delta := range miss[:len(miss)-1] {
+					// the last entry is the highest seq accepted by the remote
+					// endpoint, it is not missing.
+					last += delta
+					c.oMissing = append(c.oMissing, last)
+				}
+			}
+
 			if c.oAckedSeq < ack {
 				c.oAckedSeq = ack
 				changed = true
 			}
 
+			if e := c.writeBuffer[ack]; e != nil && e.sent.After(c.lastResend) {
+				// Only sample packets which were sent after the last retransmission
+				// (Karn's algorithm); acks for older packets may have been elicited
+				// by the retransmission.
+				rtt := time.Since(e.sent)
+				c.cc.sample(rtt)
+				c.metrics.channelRoundTripTime.Observe(rtt.Seconds())
+			}
+
 			for i := oldAck + 1; i <= ack; i++ {
 				if e := c.writeBuffer[i]; e != nil {
 					e.pkt.Free()
+					acked++
 				}
 				delete(c.writeBuffer, i)
 				changed = true
 			}
 
+			if acked > 0 {
+				c.cc.acked(acked)
+				c.needsResend = false
+			}
+
 			if len(c.writeBuffer) == 0 {
 				c.needsResend = false
 			}
@@ -614,12 +774,20 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 			if changed {
 				c.cndWrite.Signal()
 				if c.deliveredEnd || c.receivedEnd {
-					c.cndClose.Signal()
+					// both Close and drain may be waiting
+					c.cndClose.Broadcast()
 				}
 			}
 
-			if hasMiss {
-				c.processMissingPackets(ack, miss)
+			if len(c.oMissing) > 0 {
+				c.processMissingPackets()
+			}
+
+			if c.retriesExceeded {
+				c.mtx.Unlock()
+				c.traceDroppedPacket(pkt, errBrokenChannel)
+				c.channelHooks.Closed()
+				return
 			}
 		}
 	}
@@ -630,7 +798,7 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 		c.traceDroppedPacket(pkt, errMissingSeq)
 
 		if !hasAck {
-			statChannelRcvPktDrop.Add(1)
+			c.metrics.dropped(errMissingSeq)
 		}
 
 		return
@@ -643,44 +811,56 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 
 	if seq <= c.iSeq {
 		// drop: the reader already read a packet with this seq
+		// (the ack was probably lost so send it again)
+		c.deliverAdHocAckForDuplicate()
 		c.mtx.Unlock()
 		c.traceDroppedPacket(pkt, errDuplicatePacket)
-		statChannelRcvPktDrop.Add(1)
+		c.metrics.dropped(errDuplicatePacket)
 		return
 	}
 
-	if len(c.readBuffer) >= cReadBufferSize {
-		// drop: the read buffer is full
+	if c.readBuffer.IndexOf(seq) >= 0 {
+		// drop: a packet with this seq is already buffered
+		c.deliverAdHocAckForDuplicate()
 		c.mtx.Unlock()
-		c.traceDroppedPacket(pkt, errFullBuffer)
-		statChannelRcvPktDrop.Add(1)
+		c.traceDroppedPacket(pkt, errDuplicatePacket)
+		c.metrics.dropped(errDuplicatePacket)
 		return
 	}
 
-	if c.readBuffer.IndexOf(seq) >= 0 {
-		// drop: a packet with this seq is already buffered
+	if len(c.readBuffer) >= c.cfg.readWindow {
+		// drop: the read buffer is full
 		c.mtx.Unlock()
-		c.traceDroppedPacket(pkt, errDuplicatePacket)
-		statChannelRcvPktDrop.Add(1)
+		c.traceDroppedPacket(pkt, errFullBuffer)
+		c.metrics.dropped(errFullBuffer)
 		return
 	}
 
+	// trace before the packet is published; the reader owns it afterwards
+	c.traceReceivedPacket(pkt)
+
 	if c.iBufferedSeq < seq {
 		c.iBufferedSeq = seq
 	}
+	c.readBuffer = append(c.readBuffer, &readBufferEntry{pkt, seq, end})
+	sort.Sort(c.readBuffer)
+
 	if end && hasEnd {
+		// ack after buffering; the `end` packet must not be reported missing
 		c.receivedEnd = true
 		c.deliverAck()
 	}
 
-	c.readBuffer = append(c.readBuffer, &readBufferEntry{pkt, seq, end})
-	sort.Sort(c.readBuffer)
+	if c.reliable && c.iSeq >= cInitialSeq && c.iBufferedSeq > c.iSeq+uint32(len(c.readBuffer)) {
+		// There is a gap in the read buffer; report the missing packets
+		// right away so the sender can retransmit them.
+		c.deliverAck()
+	}
 
 	c.cndRead.Signal()
 	c.mtx.Unlock()
 
-	c.traceReceivedPacket(pkt)
-	statChannelRcvPkt.Add(1)
+	c.metrics.channelPacketsReceived.Add(1)
 }
 
 func (c *Channel) Errorf(format string, args ...interface{}) error {
@@ -800,6 +980,65 @@ func (c *Channel) Close() error {
 	return nil
 }
 
+// CloseWrite delivers the `end` packet without waiting for the remote endpoint.
+// Subsequent writes return io.EOF while the read side of the channel stays open
+// until the remote endpoint ends the channel. Close must still be called to
+// release the channel.
+func (c *Channel) CloseWrite() error {
+	if c == nil {
+		return os.ErrInvalid
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.broken {
+		return &BrokenChannelError{c.hashname, c.typ, c.id}
+	}
+
+	if c.deliveredEnd {
+		return nil
+	}
+
+	for c.blockWrite() {
+		c.cndWrite.Wait()
+	}
+
+	if c.deliveredEnd {
+		return nil
+	}
+
+	pkt := &lob.Packet{}
+	hdr := pkt.Header()
+	hdr.End, hdr.HasEnd = true, true
+	if err := c.write(pkt, nil); err != nil {
+		return err
+	}
+
+	// the close deadline starts when the channel is closed
+	if c.tCloseDeadline != nil {
+		c.tCloseDeadline.Stop()
+		c.tCloseDeadline = nil
+	}
+
+	return nil
+}
+
+// lingerDuration returns how long a closed channel must stay registered with
+// its exchange. A cleanly closed reliable channel keeps acknowledging the
+// retransmissions of the remote endpoint which may not have received the
+// final ack.
+func (c *Channel) lingerDuration() time.Duration {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.reliable || c.broken || !c.readEnd || !c.deliveredEnd {
+		return 0
+	}
+
+	return cLingerRTOs * c.cc.rto
+}
+
 func (c *Channel) blockClose() bool {
 	if c.broken {
 		return false
@@ -816,11 +1055,35 @@ func (c *Channel) blockClose() bool {
 	return false
 }
 
+// drain ends the channel (like CloseWrite) and waits until the remote endpoint
+// acknowledged all the packets written to the channel (including the `end`
+// packet), the channel broke or ctx is done.
+func (c *Channel) drain(ctx context.Context) error {
+	if err := c.CloseWrite(); err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	stop := wakeOnDone(ctx, &c.mtx, c.cndClose)
+	defer stop()
+
+	for !c.broken && c.reliable && len(c.writeBuffer) > 0 {
+		if err := ctx.Err(); err != nil {
+			c.mtx.Unlock()
+			return err
+		}
+		c.cndClose.Wait()
+	}
+
+	c.mtx.Unlock()
+	return nil
+}
+
 func (c *Channel) buildMissList() []uint32 {
 	// c.iSeq last read packet
 	// c.iSeq+1 is the next packet to be read
 	// c.iSeenSeq is the highest seq sean.
-	// c.iSeq + cReadBufferSize must be the last seq in the miss list
+	// c.iSeq + c.cfg.readWindow must be the last seq in the miss list
 
 	var (
 		miss []uint32
@@ -837,14 +1100,14 @@ func (c *Channel) buildMissList() []uint32 {
 
 		for seq < e.seq {
 			if miss == nil {
-				miss = make([]uint32, 0, cReadBufferSize)
+				miss = make([]uint32, 0, c.cfg.readWindow)
 			}
 			miss = append(miss, seq-last)
 			last = seq
 			seq++
 
 			n++
-			if n >= cReadBufferSize-1 {
+			if n >= c.cfg.readWindow-1 {
 				goto ADD_HIGHEST_ACCEPTABLE_SEQ
 			}
 		}
@@ -852,47 +1115,53 @@ func (c *Channel) buildMissList() []uint32 {
 
 	for seq <= c.iSeenSeq {
 		if miss == nil {
-			miss = make([]uint32, 0, cReadBufferSize)
+			miss = make([]uint32, 0, c.cfg.readWindow)
 		}
 		miss = append(miss, seq-last)
 		last = seq
 		seq++
 
 		n++
-		if n >= cReadBufferSize-1 {
+		if n >= c.cfg.readWindow-1 {
 			goto ADD_HIGHEST_ACCEPTABLE_SEQ
 		}
 	}
 
 ADD_HIGHEST_ACCEPTABLE_SEQ:
 	if n > 0 {
-		miss = append(miss, c.iSeq+cReadBufferSize-last)
+		miss = append(miss, c.iSeq+uint32(c.cfg.readWindow)-last)
 	}
 
 	return miss
 }
 
-func (c *Channel) processMissingPackets(ack uint32, miss []uint32) {
+func (c *Channel) processMissingPackets() {
 	var (
-		omiss     = c.buildMissList()
-		now       = time.Now()
-		oneSecAgo = now.Add(-1 * time.Second)
-		last      = ack
+		omiss        = c.buildMissList()
+		now          = time.Now()
+		resendCutoff = now.Add(-c.cc.rto)
 	)
 
-	for _, delta := range miss {
-		seq := last + delta
-		last = seq
-
+	for _, seq := range c.oMissing {
 		e, f := c.writeBuffer[seq]
 		if !f || e == nil {
 			continue
 		}
 
-		if e.lastResend.After(oneSecAgo) {
+		if e.lastResend.After(resendCutoff) {
+			// the retransmission can't have reached the remote endpoint yet
 			continue
 		}
 
+		if e.retries >= c.cfg.maxRetries {
+			c.markRetriesExceeded()
+			return
+		}
+
+		c.cc.lost(seq, c.oSeq)
+		c.stats.PacketsMissed++
+		c.metrics.channelMissed.Add(1)
+
 		hdr := e.pkt.Header()
 		if c.iSeq >= cInitialSeq {
 			hdr.Ack, hdr.HasAck = c.iSeq, true
@@ -901,32 +1170,86 @@ func (c *Channel) processMissingPackets(ack uint32, miss []uint32) {
 			hdr.Miss, hdr.HasMiss = omiss, true
 		}
 		e.lastResend = now
+		e.retries++
+		c.lastResend = now
+		c.stats.PacketsResent++
 
-		err := c.x.deliverPacket(e.pkt, e.dst)
+		// never block the receive path; a dropped packet is resent later
+		err := c.x.tryDeliverPacket(e.pkt, e.dst, c.cfg.priority)
 		if err == nil {
-			statChannelSndPkt.Add(1)
+			c.metrics.channelPacketsSent.Add(1)
+			c.metrics.channelRetransmits.Add(1)
 		}
 	}
 }
 
-func (c *Channel) resendLastPacket() {
+// remoteBuffered returns true when the remote endpoint most likely buffered the
+// packet with seq without reading it yet. The remote endpoint acknowledged after
+// the packet was (re)sent, it did not report the packet as missing and the packet
+// is within the window it advertised. Such a packet is not lost; its reader is
+// just behind.
+func (c *Channel) remoteBuffered(seq uint32, e *writeBufferEntry) bool {
+	if !c.oAckedAt.After(e.sent) || !c.oAckedAt.After(e.lastResend) {
+		return false
+	}
+
+	if c.oWindow > 0 && seq > c.oAckedSeq+c.oWindow {
+		return false
+	}
+
+	for _, m := range c.oMissing {
+		if m == seq {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resendOldestPacket is called when the retransmission timer expires. It resends
+// the oldest unacknowledged packet; the remote endpoint responds with an ack
+// (and a miss list) even when it already received the packet.
+//
+// When the remote endpoint buffered the packet (because its reader is behind)
+// the packet is resent as a probe with an exponential backoff. A probe doesn't
+// count as a retry and doesn't collapse the congestion window; a reader may take
+// arbitrarily long to catch up.
+func (c *Channel) resendOldestPacket() {
 	c.mtx.Lock()
 
 	var needsResend bool
 	needsResend, c.needsResend = c.needsResend, true
-	c.tResend.Reset(1 * time.Second)
 
 	if !needsResend {
+		c.tResend.Reset(c.cc.rto)
 		c.mtx.Unlock()
 		return
 	}
 
-	e := c.writeBuffer[c.oSeq]
+	e := c.writeBuffer[c.oAckedSeq+1]
 	if e == nil {
+		c.tResend.Reset(c.cc.rto)
 		c.mtx.Unlock()
 		return
 	}
 
+	if c.remoteBuffered(c.oAckedSeq+1, e) {
+		e.probes++
+		c.tResend.Reset(c.probeInterval(e.probes))
+	} else {
+		if e.retries >= c.cfg.maxRetries {
+			c.markRetriesExceeded()
+			c.mtx.Unlock()
+			c.channelHooks.Closed()
+			return
+		}
+
+		e.retries++
+		c.cc.timeout()
+		c.stats.Timeouts++
+		c.tResend.Reset(c.cc.rto)
+	}
+
 	omiss := c.buildMissList()
 	hdr := e.pkt.Header()
 	if c.iSeq >= cInitialSeq {
@@ -936,12 +1259,46 @@ func (c *Channel) resendLastPacket() {
 		hdr.Miss, hdr.HasMiss = omiss, true
 	}
 	e.lastResend = time.Now()
-	c.mtx.Unlock()
+	c.lastResend = e.lastResend
+	c.stats.PacketsResent++
 
-	err := c.x.deliverPacket(e.pkt, e.dst)
+	// the packet is encoded while holding the lock; the receive path may
+	// update its ack headers at any time.
+	err := c.x.tryDeliverPacket(e.pkt, e.dst, c.cfg.priority)
 	if err == nil {
-		statChannelSndPkt.Add(1)
+		c.metrics.channelPacketsSent.Add(1)
+		c.metrics.channelRetransmits.Add(1)
+	}
+	c.mtx.Unlock()
+}
+
+// probeInterval returns the delay before the next probe; the RTO doubles with
+// every probe (up to cMaxRTO).
+func (c *Channel) probeInterval(probes int) time.Duration {
+	d := c.cc.rto
+	for i := 0; i < probes && d < cMaxRTO; i++ {
+		d *= 2
+	}
+	if d > cMaxRTO {
+		d = cMaxRTO
 	}
+	return d
+}
+
+// markRetriesExceeded marks the channel as broken after a packet was retransmitted
+// more often than allowed by the retry budget. The caller must hold the lock and
+// must trigger the Closed hook after releasing it.
+func (c *Channel) markRetriesExceeded() {
+	c.broken = true
+	c.retriesExceeded = true
+	c.unsetTimers()
+
+	c.metrics.channelRetriesExceeded.Add(1)
+
+	// broadcast
+	c.cndWrite.Broadcast()
+	c.cndRead.Broadcast()
+	c.cndClose.Broadcast()
 }
 
 func (c *Channel) maybeDeliverAdHocAck() {
@@ -953,17 +1310,55 @@ func (c *Channel) maybeDeliverAdHocAck() {
 		return // nothing to ack
 	}
 
-	if c.iSeq-c.iAckedSeq >= earlyAdHocAck {
+	if c.iSeq == c.iAckedSeq {
+		return // nothing new to ack
+	}
+
+	if c.iSeq-c.iAckedSeq >= c.earlyAdHocAck() {
 		c.deliverAck()
+		return
+	}
+
+	if len(c.readBuffer) == 0 {
+		// The reader caught up with the sender which is probably waiting
+		// for its congestion window to open up.
+		c.deliverAck()
+		return
+	}
+
+	if !c.ackPending && !c.broken {
+		// don't let the sender wait for the acker
+		c.ackPending = true
+		c.tAcker.Reset(cDelayedAck)
 	}
 }
 
+// deliverAdHocAckForDuplicate acknowledges a duplicate packet. Duplicates are
+// sent by the remote endpoint when its retransmission timer expires.
+func (c *Channel) deliverAdHocAckForDuplicate() {
+	if c.reliable && c.iSeq >= cInitialSeq {
+		c.deliverAck()
+	}
+}
+
+// earlyAdHocAck returns the number of unacknowledged packets after which an
+// ack is delivered without waiting for the acker.
+func (c *Channel) earlyAdHocAck() uint32 {
+	if n := uint32(c.cfg.readWindow / 2); n > 0 {
+		return n
+	}
+	return 1
+}
+
 func (c *Channel) autoDeliverAck() {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
+	c.ackPending = false
 	c.deliverAck()
-	c.tAcker.Reset(10 * time.Second)
+	if !c.ackPending {
+		c.tAcker.Reset(c.cfg.ackInterval)
+	}
 }
 
 func (c *Channel) deliverAck() {
@@ -975,9 +1370,15 @@ func (c *Channel) deliverAck() {
 	hdr := pkt.Header()
 	hdr.C, hdr.HasC = c.id, true
 	c.applyAckHeaders(pkt)
-	err := c.x.deliverPacket(pkt, nil)
+
+	// acks are sent from the receive path which must never block; a dropped
+	// ack is retried by the acker.
+	err := c.x.tryDeliverPacket(pkt, nil, c.cfg.priority)
 	if err == nil {
-		statChannelSndAckAdHoc.Add(1)
+		c.metrics.channelAcksSentAdHoc.Add(1)
+	} else if err == errWouldBlock && !c.ackPending && !c.broken {
+		c.ackPending = true
+		c.tAcker.Reset(cDelayedAck)
 	}
 }
 
@@ -986,12 +1387,16 @@ func (c *Channel) applyAckHeaders(pkt *lob.Packet) {
 		return
 	}
 
+	hdr := pkt.Header()
+
+	// the remote writer may send up to ack+window
+	hdr.Window, hdr.HasWindow = uint32(c.cfg.readWindow), true
+
 	if c.iSeq == cBlankSeq {
 		// nothin to ack
 		return
 	}
 
-	hdr := pkt.Header()
 	if c.iSeq >= cInitialSeq {
 		hdr.Ack, hdr.HasAck = c.iSeq, true
 	}
@@ -1009,7 +1414,7 @@ func (c *Channel) setCloseDeadline() {
 		}
 
 		c.tCloseDeadline = time.AfterFunc(
-			60*time.Second,
+			c.cfg.closeTimeout,
 			c.onCloseDeadlineReached,
 		)
 	}
@@ -1046,7 +1451,7 @@ func (c *Channel) setOpenDeadline() {
 		}
 
 		c.tOpenDeadline = time.AfterFunc(
-			60*time.Second,
+			c.cfg.openTimeout,
 			c.onOpenDeadlineReached,
 		)
 	}
@@ -1274,7 +1679,7 @@ func (c *Channel) onWriteDeadlineReached() {
 
 // LocalAddr returns the local network address.
 func (c *Channel) LocalAddr() net.Addr {
-	return c.Exchange().localIdent.Hashname()
+	return c.Exchange().localHashname()
 }
 
 // RemoteAddr returns the remote network address.
@@ -1291,7 +1696,7 @@ func (s readBufferSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s readBufferSlice) IndexOf(seq uint32) int {
 	l := len(s)
 	idx := sort.Search(l, func(i int) bool { return s[i].seq >= seq })
-	if idx == l {
+	if idx == l || s[idx].seq != seq {
 		return -1
 	}
 	return idx
@@ -0,0 +1,124 @@
+package e3x
+
+import (
+	"time"
+)
+
+// Congestion control for reliable channels.
+//
+// The retransmission timeout (RTO) is derived from the measured round-trip
+// time as described in RFC 6298. The congestion window follows the AIMD scheme
+// of RFC 5681; it grows by one packet per acknowledged packet during slow start,
+// by one packet per window during congestion avoidance and it is halved when the
+// remote endpoint reports missing packets.
+
+const (
+	cInitialCwnd = 10
+	cMinRTO      = 200 * time.Millisecond
+	cMaxRTO      = 60 * time.Second
+	cDelayedAck  = 10 * time.Millisecond
+	cLingerRTOs  = 8
+)
+
+type congestion struct {
+	srtt   time.Duration // smoothed round-trip time
+	rttvar time.Duration // round-trip time variation
+	rto    time.Duration // retransmission timeout
+
+	cwnd     int    // congestion window (in packets)
+	maxCwnd  int    // upper bound of the congestion window
+	ssthresh int    // slow start threshold
+	acc      int    // packets acked since the last increment (congestion avoidance)
+	recover  uint32 // highest seq sent when the window was last reduced
+}
+
+func (cc *congestion) init(rto time.Duration, maxCwnd int) {
+	cc.rto = rto
+	cc.maxCwnd = maxCwnd
+	cc.cwnd = cInitialCwnd
+	cc.ssthresh = maxCwnd
+	if cc.cwnd > maxCwnd {
+		cc.cwnd = maxCwnd
+	}
+}
+
+// window returns the number of unacknowledged packets which may be in flight.
+func (cc *congestion) window() int {
+	return cc.cwnd
+}
+
+// sample updates the RTO with a round-trip time measurement. Only packets which
+// were never retransmitted must be sampled (Karn's algorithm).
+func (cc *congestion) sample(rtt time.Duration) {
+	if rtt <= 0 {
+		rtt = time.Microsecond
+	}
+
+	if cc.srtt == 0 {
+		cc.srtt = rtt
+		cc.rttvar = rtt / 2
+	} else {
+		delta := cc.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		cc.rttvar = (3*cc.rttvar + delta) / 4
+		cc.srtt = (7*cc.srtt + rtt) / 8
+	}
+
+	cc.setRTO(cc.srtt + 4*cc.rttvar)
+}
+
+// acked grows the congestion window for n newly acknowledged packets.
+func (cc *congestion) acked(n int) {
+	for ; n > 0 && cc.cwnd < cc.maxCwnd; n-- {
+		if cc.cwnd < cc.ssthresh {
+			cc.cwnd++
+			continue
+		}
+
+		cc.acc++
+		if cc.acc >= cc.cwnd {
+			cc.acc = 0
+			cc.cwnd++
+		}
+	}
+}
+
+// lost halves the congestion window when seq was reported missing. The window
+// is reduced at most once per window of packets; sent is the highest seq sent.
+func (cc *congestion) lost(seq, sent uint32) {
+	if seq <= cc.recover {
+		return
+	}
+
+	cc.recover = sent
+	cc.ssthresh = cc.cwnd / 2
+	if cc.ssthresh < 2 {
+		cc.ssthresh = 2
+	}
+	cc.cwnd = cc.ssthresh
+	cc.acc = 0
+}
+
+// timeout collapses the congestion window and backs off the RTO after the
+// retransmission timer expired.
+func (cc *congestion) timeout() {
+	cc.ssthresh = cc.cwnd / 2
+	if cc.ssthresh < 2 {
+		cc.ssthresh = 2
+	}
+	cc.cwnd = 1
+	cc.acc = 0
+	cc.setRTO(2 * cc.rto)
+}
+
+func (cc *congestion) setRTO(rto time.Duration) {
+	if rto < cMinRTO {
+		rto = cMinRTO
+	}
+	if rto > cMaxRTO {
+		rto = cMaxRTO
+	}
+	cc.rto = rto
+}
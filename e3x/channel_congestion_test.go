@@ -0,0 +1,402 @@
+package e3x
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x/tracer"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+// lossyConfig wraps a transport and drops a fraction of the channel packets
+// written to it (handshakes are never dropped).
+type lossyConfig struct {
+	Config transports.Config
+	Loss   float64
+}
+
+type lossyTransport struct {
+	transports.Transport
+	loss float64
+}
+
+type lossyConn struct {
+	net.Conn
+	loss float64
+}
+
+func (c lossyConfig) Open() (transports.Transport, error) {
+	t, err := c.Config.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &lossyTransport{t, c.Loss}, nil
+}
+
+func (t *lossyTransport) Dial(addr net.Addr) (net.Conn, error) {
+	conn, err := t.Transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &lossyConn{conn, t.loss}, nil
+}
+
+func (t *lossyTransport) Accept() (net.Conn, error) {
+	conn, err := t.Transport.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &lossyConn{conn, t.loss}, nil
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	// channel packets have an empty outer header
+	if len(b) > 2 && b[0] == 0 && b[1] == 0 && rand.Float64() < c.loss {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func withLossyEndpoints(tb testing.TB, loss float64, f func(a, b *Endpoint)) {
+	var endpoints [2]*Endpoint
+
+	for i := range endpoints {
+		e, err := Open(
+			Transport(lossyConfig{inproc.Config{}, loss}),
+			Metrics(testMetrics),
+			Log(nil))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		defer e.Close()
+
+		endpoints[i] = e
+	}
+
+	f(endpoints[0], endpoints[1])
+}
+
+func TestCongestionRTT(t *testing.T) {
+	assert := assert.New(t)
+
+	var cc congestion
+	cc.init(time.Second, 100)
+	assert.Equal(time.Second, cc.rto)
+	assert.Equal(cInitialCwnd, cc.window())
+
+	cc.sample(100 * time.Millisecond)
+	assert.Equal(100*time.Millisecond, cc.srtt)
+	assert.Equal(50*time.Millisecond, cc.rttvar)
+	assert.Equal(300*time.Millisecond, cc.rto)
+
+	for i := 0; i < 100; i++ {
+		cc.sample(100 * time.Millisecond)
+	}
+	assert.Equal(100*time.Millisecond, cc.srtt)
+	assert.Equal(cMinRTO, cc.rto)
+
+	cc.timeout()
+	assert.Equal(2*cMinRTO, cc.rto)
+	assert.Equal(1, cc.window())
+
+	cc.sample(100 * time.Millisecond)
+	assert.Equal(cMinRTO, cc.rto)
+}
+
+func TestCongestionWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	var cc congestion
+	cc.init(time.Second, 100)
+
+	// slow start
+	cc.acked(cInitialCwnd)
+	assert.Equal(2*cInitialCwnd, cc.window())
+
+	// multiplicative decrease (only once per window)
+	cc.lost(10, 20)
+	assert.Equal(cInitialCwnd, cc.window())
+	cc.lost(15, 20)
+	assert.Equal(cInitialCwnd, cc.window())
+
+	// congestion avoidance
+	cc.acked(cInitialCwnd)
+	assert.Equal(cInitialCwnd+1, cc.window())
+
+	cc.lost(21, 30)
+	assert.Equal((cInitialCwnd+1)/2, cc.window())
+}
+
+// captureExchange records the packets delivered by a channel.
+type captureExchange struct {
+	mtx sync.Mutex
+	seq []uint32
+}
+
+func (x *captureExchange) deliverPacket(pkt *lob.Packet, dst *Pipe, priority int) error {
+	x.mtx.Lock()
+	if hdr := pkt.Header(); hdr.HasSeq {
+		x.seq = append(x.seq, hdr.Seq)
+	}
+	x.mtx.Unlock()
+	return nil
+}
+
+func (x *captureExchange) tryDeliverPacket(pkt *lob.Packet, dst *Pipe, priority int) error {
+	return x.deliverPacket(pkt, dst, priority)
+}
+
+func (x *captureExchange) RemoteIdentity() *Identity { return nil }
+func (x *captureExchange) getTID() tracer.ID         { return 0 }
+
+func (x *captureExchange) delivered() []uint32 {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	s := x.seq
+	x.seq = nil
+	return s
+}
+
+func TestSelectiveRetransmission(t *testing.T) {
+	assert := assert.New(t)
+
+	x := &captureExchange{}
+	c, err := newChannel("", "test", true, false, x, MaxRetries(2))
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	c.mtx.Lock()
+	for i := 0; i < 6; i++ {
+		assert.NoError(c.write(lob.New(nil), nil))
+	}
+	c.mtx.Unlock()
+	assert.Equal([]uint32{1, 2, 3, 4, 5, 6}, x.delivered())
+
+	ack := func(ack uint32, miss ...uint32) {
+		pkt := &lob.Packet{}
+		hdr := pkt.Header()
+		hdr.C, hdr.HasC = c.id, true
+		hdr.Ack, hdr.HasAck = ack, true
+		hdr.Miss, hdr.HasMiss = miss, true
+		c.receivedPacket(pkt)
+	}
+
+	// 3 and 5 are missing; 102 is the highest acceptable seq
+	ack(2, 1, 2, 97)
+	assert.Equal([]uint32{3, 5}, x.delivered())
+
+	// a fast network still gives a retransmission an RTO to arrive
+	c.mtx.Lock()
+	c.cc.srtt = time.Microsecond
+	c.mtx.Unlock()
+
+	stats := c.Stats()
+	assert.Equal(uint64(6), stats.PacketsSent)
+	assert.Equal(uint64(2), stats.PacketsResent)
+	assert.Equal(uint64(2), stats.PacketsMissed)
+	assert.Equal(1, stats.Retries)
+	assert.Equal(2, stats.MaxRetries)
+	assert.Equal((cInitialCwnd+2)/2, stats.Window)
+
+	// the retransmissions can't have been received yet
+	ack(2, 1, 2, 97)
+	assert.Empty(x.delivered())
+	assert.Equal(uint64(2), c.Stats().PacketsMissed)
+
+	// only 5 is still missing
+	c.mtx.Lock()
+	c.writeBuffer[5].lastResend = time.Time{}
+	c.mtx.Unlock()
+	ack(4, 1, 99)
+	assert.Equal([]uint32{5}, x.delivered())
+	assert.Equal(2, c.Stats().Retries)
+	assert.Equal(uint64(3), c.Stats().PacketsMissed)
+
+	// the retry budget of 5 is exhausted
+	c.mtx.Lock()
+	c.writeBuffer[5].lastResend = time.Time{}
+	c.mtx.Unlock()
+	ack(4, 1, 99)
+	assert.Empty(x.delivered())
+
+	err = c.WritePacket(lob.New(nil))
+	assert.IsType(&BrokenChannelError{}, err)
+}
+
+func TestRetryBudgetOnTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	x := &captureExchange{}
+	c, err := newChannel("", "test", true, false, x,
+		ResendInterval(10*time.Millisecond),
+		MaxRetries(2))
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	assert.NoError(c.WritePacket(lob.New(nil)))
+
+	// the first packet is resent until the budget is exhausted
+	c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, err = c.ReadPacket()
+	assert.IsType(&BrokenChannelError{}, err)
+
+	stats := c.Stats()
+	assert.Equal(uint64(1), stats.PacketsSent)
+	assert.Equal(uint64(2), stats.PacketsResent)
+	assert.Equal(uint64(2), stats.Timeouts)
+	assert.Equal([]uint32{1, 1, 1}, x.delivered())
+}
+
+func TestFloodReliableLossy(t *testing.T) {
+	logs.ResetLogger()
+
+	withLossyEndpoints(t, 0.05, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			done   = make(chan struct{})
+			n      = 2000
+		)
+
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("flood", true).AcceptChannel()
+			if !assert.NoError(err) {
+				return
+			}
+			defer c.Close()
+
+			_, err = c.ReadPacket()
+			assert.NoError(err)
+
+			for i := 0; i < n; i++ {
+				pkt := lob.New(nil)
+				pkt.Header().SetInt("flood_id", i)
+				if !assert.NoError(c.WritePacket(pkt)) {
+					return
+				}
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "flood", true)
+		if !assert.NoError(err) {
+			return
+		}
+
+		c.SetReadDeadline(time.Now().Add(30 * time.Second))
+		assert.NoError(c.WritePacket(lob.New(nil)))
+
+		lastID := -1
+		for {
+			pkt, err := c.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+			if !assert.NoError(err) {
+				break
+			}
+			id, _ := pkt.Header().GetInt("flood_id")
+			assert.Equal(lastID+1, id)
+			lastID = id
+		}
+
+		assert.Equal(n-1, lastID)
+		assert.NoError(c.Close())
+		<-done
+	})
+}
+
+func BenchmarkReadWriteReliableLoss1(b *testing.B) {
+	benchmarkReadWriteReliableLossy(b, 0.01)
+}
+
+func BenchmarkReadWriteReliableLoss5(b *testing.B) {
+	benchmarkReadWriteReliableLossy(b, 0.05)
+}
+
+func benchmarkReadWriteReliableLossy(b *testing.B, loss float64) {
+	defer dumpMetrics(b)
+	logs.ResetLogger()
+
+	withLossyEndpoints(b, loss, func(A, B *Endpoint) {
+		var (
+			body = bytes.Repeat([]byte{'x'}, 1300)
+			done = make(chan struct{})
+		)
+
+		b.SetBytes(int64(len(body)))
+		b.ResetTimer()
+
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("flood", true).AcceptChannel()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			defer c.Close()
+
+			_, err = c.ReadPacket()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+
+			for i := 0; i < b.N; i++ {
+				err = c.WritePacket(lob.New(body))
+				if err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		c, err := B.Open(ident, "flood", true)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		err = c.WritePacket(lob.New(nil))
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for {
+			pkt, err := c.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+			pkt.Free()
+		}
+
+		c.Close()
+		<-done
+		b.StopTimer()
+	})
+}
@@ -0,0 +1,198 @@
+package e3x
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+// ErrMessageTooLarge is returned when a packet exceeds the maximum message
+// size of a channel with fragmentation enabled.
+var ErrMessageTooLarge = errors.New("e3x: message too large")
+
+const (
+	cFragmentOverhead  = 256 // headers and encryption overhead of a fragment
+	cFragmentSize      = DefaultMTU - cFragmentOverhead
+	cMinFragmentSize   = cMinMTU - cFragmentOverhead
+	cMaxReassemblies   = 16 // concurrent reassemblies per channel
+	hdrFragmentID      = "fid"
+	hdrFragmentIndex   = "fidx"
+	hdrFragmentCount   = "fcnt"
+	errInvalidFragment = "invalid fragment"
+)
+
+type reassembly struct {
+	frags   [][]byte
+	missing int
+	size    int
+	started time.Time
+}
+
+func (c *Channel) fragmentationEnabled() bool {
+	return c.cfg.maxMessageSize > 0
+}
+
+// fragmentSize returns the body size of the fragments sent over p (or the
+// active pipe of the exchange).
+func (c *Channel) fragmentSize(p *Pipe) int {
+	if x := c.Exchange(); p == nil && x != nil {
+		p = x.ActivePipe()
+	}
+	if p == nil {
+		return cFragmentSize
+	}
+	return p.MTU() - cFragmentOverhead
+}
+
+// MaxPacketSize returns the largest packet body that fits in a single message
+// on the active path of the exchange. It grows once path MTU discovery has
+// probed a path which accepts large messages (like a TCP path).
+func (c *Channel) MaxPacketSize() int {
+	return c.fragmentSize(nil)
+}
+
+// writeMessage splits pkt into fragments when it doesn't fit in a single
+// packet. Reliable channels wait for their window to open up between the
+// fragments. The caller must hold c.mtx.
+func (c *Channel) writeMessage(ctx context.Context, pkt *lob.Packet, p *Pipe) error {
+	msg, err := lob.Encode(pkt)
+	if err != nil {
+		return c.traceWriteError(pkt, p, err)
+	}
+
+	size := c.fragmentSize(p)
+	if msg.Len() <= size {
+		msg.Free()
+		return c.write(pkt, p)
+	}
+
+	if msg.Len() > c.cfg.maxMessageSize {
+		msg.Free()
+		return c.traceWriteError(pkt, p, ErrMessageTooLarge)
+	}
+
+	var (
+		data  = msg.RawBytes()
+		count = (len(data) + size - 1) / size
+	)
+
+	c.nextFragID++
+	for idx := 0; idx < count; idx++ {
+		for idx > 0 && c.reliable && c.blockWrite() {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			c.cndWrite.Wait()
+		}
+		if err != nil {
+			break
+		}
+
+		end := (idx + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+
+		frag := lob.New(data[idx*size : end])
+		frag.TID = pkt.TID
+		hdr := frag.Header()
+		hdr.SetUint32(hdrFragmentID, c.nextFragID)
+		hdr.SetInt(hdrFragmentIndex, idx)
+		hdr.SetInt(hdrFragmentCount, count)
+
+		err = c.write(frag, p)
+		if err != nil {
+			frag.Free()
+			break
+		}
+	}
+
+	msg.Free()
+	if err == nil {
+		pkt.Free()
+	}
+	return err
+}
+
+// reassemble buffers the fragment pkt. It returns the reassembled packet once
+// all the fragments were received and nil otherwise. Unreliable channels
+// reassemble the fragments as they arrive while reliable channels reassemble
+// them as they are read (in order); their fragments never expire. The caller
+// must hold c.mtx.
+func (c *Channel) reassemble(pkt *lob.Packet) *lob.Packet {
+	var (
+		hdr         = pkt.Header()
+		id, _       = hdr.GetUint32(hdrFragmentID)
+		idx, okIdx  = hdr.GetInt(hdrFragmentIndex)
+		cnt, okCnt  = hdr.GetInt(hdrFragmentCount)
+		maxCount    = c.cfg.maxMessageSize/cMinFragmentSize + 1
+		now         = time.Now()
+		oldestID    uint32
+		oldestStart time.Time
+	)
+
+	for fid, r := range c.reassemblies {
+		if !c.reliable && now.Sub(r.started) > c.cfg.reassemblyTimeout {
+			delete(c.reassemblies, fid)
+			c.metrics.channelFragmentsExpired.Add(1)
+			continue
+		}
+		if oldestStart.IsZero() || r.started.Before(oldestStart) {
+			oldestID, oldestStart = fid, r.started
+		}
+	}
+
+	if !okIdx || !okCnt || cnt <= 0 || cnt > maxCount || idx < 0 || idx >= cnt {
+		c.traceDroppedPacket(pkt, errInvalidFragment)
+		c.metrics.dropped(errInvalidFragment)
+		pkt.Free()
+		return nil
+	}
+
+	r := c.reassemblies[id]
+	if r == nil {
+		if len(c.reassemblies) >= cMaxReassemblies {
+			delete(c.reassemblies, oldestID)
+			c.metrics.channelFragmentsExpired.Add(1)
+		}
+		if c.reassemblies == nil {
+			c.reassemblies = make(map[uint32]*reassembly)
+		}
+		r = &reassembly{frags: make([][]byte, cnt), missing: cnt, started: now}
+		c.reassemblies[id] = r
+	}
+
+	if len(r.frags) != cnt || r.frags[idx] != nil || r.size+pkt.BodyLen() > c.cfg.maxMessageSize {
+		c.traceDroppedPacket(pkt, errInvalidFragment)
+		c.metrics.dropped(errInvalidFragment)
+		pkt.Free()
+		return nil
+	}
+
+	r.frags[idx] = pkt.Body(nil)
+	r.missing--
+	r.size += len(r.frags[idx])
+	pkt.Free()
+
+	if r.missing > 0 {
+		return nil
+	}
+	delete(c.reassemblies, id)
+
+	data := make([]byte, 0, r.size)
+	for _, frag := range r.frags {
+		data = append(data, frag...)
+	}
+
+	buf := bufpool.New().Set(data)
+	msg, err := lob.Decode(buf)
+	buf.Free()
+	if err != nil {
+		c.metrics.dropped(errInvalidFragment)
+		return nil
+	}
+	return msg
+}
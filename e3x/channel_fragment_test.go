@@ -0,0 +1,195 @@
+package e3x
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x/metrics"
+	"github.com/telehash/gogotelehash/e3x/tracer"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+// recordExchange records (a copy of) the packets delivered by a channel.
+type recordExchange struct {
+	mtx  sync.Mutex
+	msgs []*bufpool.Buffer
+}
+
+func (x *recordExchange) deliverPacket(pkt *lob.Packet, dst *Pipe, priority int) error {
+	msg, err := lob.Encode(pkt)
+	if err != nil {
+		return err
+	}
+	x.mtx.Lock()
+	x.msgs = append(x.msgs, msg)
+	x.mtx.Unlock()
+	return nil
+}
+
+func (x *recordExchange) tryDeliverPacket(pkt *lob.Packet, dst *Pipe, priority int) error {
+	return x.deliverPacket(pkt, dst, priority)
+}
+
+func (x *recordExchange) RemoteIdentity() *Identity { return nil }
+func (x *recordExchange) getTID() tracer.ID         { return 0 }
+
+func (x *recordExchange) packets() []*lob.Packet {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	var pkts []*lob.Packet
+	for _, msg := range x.msgs {
+		pkt, err := lob.Decode(msg)
+		if err != nil {
+			panic(err)
+		}
+		msg.Free()
+		pkts = append(pkts, pkt)
+	}
+	x.msgs = nil
+	return pkts
+}
+
+func TestFragmentation(t *testing.T) {
+	testFragmentation(t, false)
+}
+
+func TestReliableFragmentation(t *testing.T) {
+	testFragmentation(t, true)
+}
+
+func testFragmentation(t *testing.T, reliable bool) {
+	assert := assert.New(t)
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		identB, err := B.LocalIdentity()
+		if !assert.NoError(err) {
+			return
+		}
+
+		l := B.Listen("big", reliable, Fragmentation(256*1024))
+		defer l.Close()
+
+		served := make(chan struct{})
+		go func() {
+			defer close(served)
+
+			c, err := l.AcceptChannel()
+			if !assert.NoError(err) {
+				return
+			}
+			defer c.Kill()
+			c.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+			for i := 0; i < 2; i++ {
+				pkt, err := c.ReadPacket()
+				if !assert.NoError(err) {
+					return
+				}
+				assert.NoError(c.WritePacket(pkt))
+			}
+		}()
+
+		c, err := A.Open(identB, "big", reliable, Fragmentation(256*1024))
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Kill()
+		c.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+		// the large packet doesn't fit in the window of a reliable channel
+		for _, size := range []int{200 * 1024, 10} {
+			body := make([]byte, size)
+			rand.Read(body)
+
+			pkt := lob.New(body)
+			pkt.Header().SetString("name", "blob")
+			if !assert.NoError(c.WritePacket(pkt)) {
+				return
+			}
+
+			pkt, err = c.ReadPacket()
+			if !assert.NoError(err) {
+				return
+			}
+			name, _ := pkt.Header().GetString("name")
+			assert.Equal("blob", name)
+			assert.True(bytes.Equal(body, pkt.Body(nil)), "body of %d bytes", size)
+			pkt.Free()
+		}
+
+		assert.Equal(ErrMessageTooLarge, c.WritePacket(lob.New(make([]byte, 300*1024))))
+
+		<-served
+	})
+}
+
+func TestReassemblyTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	x := &recordExchange{}
+	src, err := newChannel("", "test", false, false, x, Fragmentation(64*1024))
+	if !assert.NoError(err) {
+		return
+	}
+	defer src.Kill()
+
+	body := make([]byte, 5000)
+	rand.Read(body)
+	assert.NoError(src.WritePacket(lob.New(body)))
+	frags := x.packets()
+	assert.Equal(5, len(frags))
+
+	dst, err := newChannel("", "test", false, true, nil,
+		Fragmentation(64*1024),
+		ReassemblyTimeout(50*time.Millisecond))
+	if !assert.NoError(err) {
+		return
+	}
+	defer dst.Kill()
+
+	copyPacket := func(pkt *lob.Packet) *lob.Packet {
+		cpy := lob.New(pkt.Body(nil))
+		for k, v := range pkt.Header().Extra {
+			cpy.Header().Set(k, v)
+		}
+		return cpy
+	}
+
+	m := metrics.NewPrometheus()
+	dst.metrics = newEndpointMetrics(m)
+
+	// the last fragment arrives too late
+	for _, frag := range frags[:4] {
+		dst.receivedPacket(copyPacket(frag))
+	}
+	time.Sleep(100 * time.Millisecond)
+	dst.receivedPacket(copyPacket(frags[4]))
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	assert.Contains(buf.String(), "e3x_channel_fragments_expired_total 1\n")
+
+	dst.mtx.Lock()
+	assert.Equal(0, len(dst.readBuffer))
+	dst.mtx.Unlock()
+
+	// the fragments arrive out of order
+	for _, i := range []int{3, 1, 0, 2} {
+		dst.receivedPacket(copyPacket(frags[i]))
+	}
+	dst.SetReadDeadline(time.Now().Add(10 * time.Second))
+	pkt, err := dst.ReadPacket()
+	if assert.NoError(err) {
+		assert.True(bytes.Equal(body, pkt.Body(nil)))
+		pkt.Free()
+	}
+
+	for _, frag := range frags {
+		frag.Free()
+	}
+}
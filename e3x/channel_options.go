@@ -0,0 +1,179 @@
+package e3x
+
+import (
+	"errors"
+	"time"
+)
+
+// Default window sizes and timers of channels.
+const (
+	DefaultReadWindow     = 100
+	DefaultWriteWindow    = 100
+	DefaultResendInterval = 1 * time.Second
+	DefaultAckInterval    = 10 * time.Second
+	DefaultOpenTimeout    = 60 * time.Second
+	DefaultCloseTimeout   = 60 * time.Second
+	DefaultMaxRetries     = 10
+	DefaultPriority       = 0
+
+	DefaultReassemblyTimeout = 5 * time.Second
+)
+
+var ErrInvalidChannelOption = errors.New("e3x: invalid channel option")
+
+// channelConfig holds the tunable window sizes and timers of a channel.
+type channelConfig struct {
+	readWindow     int
+	writeWindow    int
+	resendInterval time.Duration
+	ackInterval    time.Duration
+	openTimeout    time.Duration
+	closeTimeout   time.Duration
+	maxRetries     int
+	priority       int
+
+	maxMessageSize    int // 0 when fragmentation is disabled
+	reassemblyTimeout time.Duration
+}
+
+var defaultChannelConfig = channelConfig{
+	readWindow:     DefaultReadWindow,
+	writeWindow:    DefaultWriteWindow,
+	resendInterval: DefaultResendInterval,
+	ackInterval:    DefaultAckInterval,
+	openTimeout:    DefaultOpenTimeout,
+	closeTimeout:   DefaultCloseTimeout,
+	maxRetries:     DefaultMaxRetries,
+	priority:       DefaultPriority,
+
+	reassemblyTimeout: DefaultReassemblyTimeout,
+}
+
+// ReadWindow sets the number of packets a channel buffers before they are read.
+func ReadWindow(n int) ChannelOption {
+	return func(c *Channel) error {
+		if n <= 0 {
+			return ErrInvalidChannelOption
+		}
+		c.cfg.readWindow = n
+		return nil
+	}
+}
+
+// WriteWindow sets the number of unacknowledged packets a reliable channel
+// keeps before writes are blocked.
+func WriteWindow(n int) ChannelOption {
+	return func(c *Channel) error {
+		if n <= 0 {
+			return ErrInvalidChannelOption
+		}
+		c.cfg.writeWindow = n
+		return nil
+	}
+}
+
+// ResendInterval sets the initial retransmission timeout. Once the round-trip
+// time was measured the timeout is derived from the RTT.
+func ResendInterval(d time.Duration) ChannelOption {
+	return func(c *Channel) error {
+		if d <= 0 {
+			return ErrInvalidChannelOption
+		}
+		c.cfg.resendInterval = d
+		return nil
+	}
+}
+
+// AckInterval sets the interval at which acknowledgements are sent when
+// there is no other traffic on the channel.
+func AckInterval(d time.Duration) ChannelOption {
+	return func(c *Channel) error {
+		if d <= 0 {
+			return ErrInvalidChannelOption
+		}
+		c.cfg.ackInterval = d
+		return nil
+	}
+}
+
+// OpenTimeout sets how long a channel waits for the first packet from the remote
+// endpoint before it is considered broken.
+func OpenTimeout(d time.Duration) ChannelOption {
+	return func(c *Channel) error {
+		if d <= 0 {
+			return ErrInvalidChannelOption
+		}
+		c.cfg.openTimeout = d
+		return nil
+	}
+}
+
+// CloseTimeout sets how long a closing channel waits for the remote endpoint
+// to acknowledge the end of the channel.
+func CloseTimeout(d time.Duration) ChannelOption {
+	return func(c *Channel) error {
+		if d <= 0 {
+			return ErrInvalidChannelOption
+		}
+		c.cfg.closeTimeout = d
+		return nil
+	}
+}
+
+// MaxRetries sets how often a packet is retransmitted before the channel is
+// considered broken.
+func MaxRetries(n int) ChannelOption {
+	return func(c *Channel) error {
+		if n <= 0 {
+			return ErrInvalidChannelOption
+		}
+		c.cfg.maxRetries = n
+		return nil
+	}
+}
+
+// Priority sets the send priority of a channel. The packets of channels with a
+// higher priority are sent before the packets of channels with a lower priority
+// (on the same exchange). Channels with the same priority share the link in
+// FIFO order.
+func Priority(p int) ChannelOption {
+	return func(c *Channel) error {
+		c.cfg.priority = p
+		return nil
+	}
+}
+
+// Fragmentation enables the fragmentation of large packets. Packets of up to
+// maxMessageSize bytes (encoded header and body) are split into packets which
+// fit the path of the exchange (see Channel.MaxPacketSize) and reassembled by
+// the remote endpoint, which must enable fragmentation as well.
+func Fragmentation(maxMessageSize int) ChannelOption {
+	return func(c *Channel) error {
+		if maxMessageSize <= 0 {
+			return ErrInvalidChannelOption
+		}
+		c.cfg.maxMessageSize = maxMessageSize
+		return nil
+	}
+}
+
+// ReassemblyTimeout sets how long the fragments of an incomplete packet are
+// kept before they are dropped.
+func ReassemblyTimeout(d time.Duration) ChannelOption {
+	return func(c *Channel) error {
+		if d <= 0 {
+			return ErrInvalidChannelOption
+		}
+		c.cfg.reassemblyTimeout = d
+		return nil
+	}
+}
+
+// ChannelDefaults sets the options which are applied to all the channels of
+// the endpoint. Per-listener and per-channel options take precedence.
+func ChannelDefaults(options ...ChannelOption) EndpointOption {
+	return func(e *Endpoint) error {
+		e.channelOptions = append(e.channelOptions, options...)
+		return nil
+	}
+}
@@ -25,10 +25,11 @@ func (set *channelSet) Get(id uint32) *Channel {
 
 func (set *channelSet) All() []*Channel {
 	var (
-		s = make([]*Channel, 0, len(set.channels))
+		s []*Channel
 	)
 
 	set.mtx.RLock()
+	s = make([]*Channel, 0, len(set.channels))
 	for _, c := range set.channels {
 		s = append(s, c)
 	}
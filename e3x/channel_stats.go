@@ -0,0 +1,43 @@
+package e3x
+
+import (
+	"time"
+)
+
+// ChannelStats holds the retransmission and loss statistics of a channel.
+type ChannelStats struct {
+	PacketsSent   uint64 // packets sent (excluding retransmissions)
+	PacketsResent uint64 // retransmitted packets
+	PacketsMissed uint64 // packets retransmitted after the remote endpoint reported them missing
+	Timeouts      uint64 // expired retransmission timers (excluding probes of a slow reader)
+
+	Retries    int // highest retry count of the unacknowledged packets
+	MaxRetries int // the retry budget of a packet
+
+	RTT    time.Duration // smoothed round-trip time
+	RTO    time.Duration // retransmission timeout
+	Window int           // congestion window (in packets)
+
+	RemoteWindow int // window advertised by the remote reader (0 when unknown)
+}
+
+// Stats returns the retransmission and loss statistics of the channel.
+func (c *Channel) Stats() ChannelStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	stats := c.stats
+	stats.MaxRetries = c.cfg.maxRetries
+	stats.RTT = c.cc.srtt
+	stats.RTO = c.cc.rto
+	stats.Window = c.cc.window()
+	stats.RemoteWindow = int(c.oWindow)
+
+	for _, e := range c.writeBuffer {
+		if e.retries > stats.Retries {
+			stats.Retries = e.retries
+		}
+	}
+
+	return stats
+}
@@ -44,6 +44,7 @@ func withEndpoint(t testing.TB, f func(e *Endpoint)) {
 
 	e, err = Open(
 		Transport(tr),
+		Metrics(testMetrics),
 		Log(nil))
 	if err != nil {
 		t.Fatal(err)
@@ -229,8 +230,315 @@ func TestFloodReliable(t *testing.T) {
 	})
 }
 
+func TestChannelOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := newChannel("", "test", true, false, nil,
+		ReadWindow(8),
+		WriteWindow(4),
+		ResendInterval(100*time.Millisecond),
+		AckInterval(200*time.Millisecond))
+	if assert.NoError(err) && assert.NotNil(c) {
+		defer c.unsetTimers()
+
+		assert.Equal(8, c.cfg.readWindow)
+		assert.Equal(4, c.cfg.writeWindow)
+		assert.Equal(100*time.Millisecond, c.cfg.resendInterval)
+		assert.Equal(200*time.Millisecond, c.cfg.ackInterval)
+		assert.Equal(DefaultOpenTimeout, c.cfg.openTimeout)
+		assert.Equal(DefaultCloseTimeout, c.cfg.closeTimeout)
+		assert.Equal(uint32(4), c.earlyAdHocAck())
+	}
+
+	c, err = newChannel("", "test", true, false, nil, ReadWindow(0))
+	assert.Equal(ErrInvalidChannelOption, err)
+	assert.Nil(c)
+
+	c, err = newChannel("", "test", true, false, nil, ResendInterval(-1))
+	assert.Equal(ErrInvalidChannelOption, err)
+	assert.Nil(c)
+}
+
+func TestChannelOptionsInheritance(t *testing.T) {
+	logs.ResetLogger()
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		var (
+			assert   = assert.New(t)
+			accepted = make(chan *Channel, 1)
+		)
+
+		A.setOptions(ChannelDefaults(ReadWindow(10), WriteWindow(10)))
+		B.setOptions(ChannelDefaults(ReadWindow(20), WriteWindow(20)))
+
+		go func() {
+			c, err := A.Listen("opts", true, WriteWindow(5)).AcceptChannel()
+			if assert.NoError(err) {
+				accepted <- c
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		_, err = B.Open(ident, "opts", true, WriteWindow(0))
+		assert.Equal(ErrInvalidChannelOption, err)
+
+		c, err := B.Open(ident, "opts", true, ReadWindow(30))
+		if assert.NoError(err) && assert.NotNil(c) {
+			defer c.Kill()
+
+			assert.Equal(30, c.cfg.readWindow)
+			assert.Equal(20, c.cfg.writeWindow)
+
+			assert.NoError(c.WritePacket(lob.New(nil)))
+
+			select {
+			case s := <-accepted:
+				assert.Equal(10, s.cfg.readWindow)
+				assert.Equal(5, s.cfg.writeWindow)
+				s.Kill()
+			case <-time.After(10 * time.Second):
+				t.Fatal("channel was not accepted")
+			}
+		}
+	})
+}
+
+func TestFloodReliableSmallWindows(t *testing.T) {
+	logs.ResetLogger()
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		A.setOptions(DisableLog())
+		B.setOptions(DisableLog())
+
+		var (
+			assert  = assert.New(t)
+			options = []ChannelOption{
+				ReadWindow(4),
+				WriteWindow(2),
+				ResendInterval(100 * time.Millisecond),
+				AckInterval(100 * time.Millisecond),
+			}
+			c     *Channel
+			ident *Identity
+			pkt   *lob.Packet
+			err   error
+		)
+
+		go func() {
+			c, err := A.Listen("flood", true, options...).AcceptChannel()
+			if assert.NoError(err) && assert.NotNil(c) {
+				defer c.Close()
+
+				pkt, err := c.ReadPacket()
+				assert.NoError(err)
+				assert.NotNil(pkt)
+
+				for i := 0; i < 1000; i++ {
+					pkt := lob.New(nil)
+					pkt.Header().SetInt("flood_id", i)
+					err = c.WritePacket(pkt)
+					assert.NoError(err)
+					assert.True(len(c.writeBuffer) <= 2)
+				}
+			}
+		}()
+
+		ident, err = A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err = B.Open(ident, "flood", true, options...)
+		assert.NoError(err)
+		assert.NotNil(c)
+
+		defer c.Close()
+
+		c.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+		err = c.WritePacket(lob.New(nil))
+		assert.NoError(err)
+
+		lastID := -1
+		for {
+			pkt, err = c.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+			assert.NoError(err)
+			assert.NotNil(pkt)
+			if err != nil {
+				break
+			}
+			if pkt != nil {
+				id, _ := pkt.Header().GetInt("flood_id")
+				assert.Equal(lastID+1, id)
+				lastID = id
+			}
+		}
+
+		assert.Equal(999, lastID)
+	})
+}
+
+func TestFlowControlSlowReader(t *testing.T) {
+	logs.ResetLogger()
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		A.setOptions(DisableLog())
+		B.setOptions(DisableLog())
+
+		var (
+			assert = assert.New(t)
+			done   = make(chan ChannelStats, 1)
+			c      *Channel
+			ident  *Identity
+			pkt    *lob.Packet
+			err    error
+		)
+
+		go func() {
+			defer close(done)
+
+			// the writer uses the default (large) write window
+			c, err := A.Listen("flood", true).AcceptChannel()
+			if assert.NoError(err) && assert.NotNil(c) {
+				pkt, err := c.ReadPacket()
+				assert.NoError(err)
+				assert.NotNil(pkt)
+
+				for i := 0; i < 200; i++ {
+					pkt := lob.New(nil)
+					pkt.Header().SetInt("flood_id", i)
+					err = c.WritePacket(pkt)
+					assert.NoError(err)
+				}
+
+				assert.NoError(c.Close())
+				done <- c.Stats()
+			}
+		}()
+
+		ident, err = A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err = B.Open(ident, "flood", true, ReadWindow(4))
+		assert.NoError(err)
+		assert.NotNil(c)
+
+		c.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+		err = c.WritePacket(lob.New(nil))
+		assert.NoError(err)
+
+		lastID := -1
+		for {
+			pkt, err = c.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+			if !assert.NoError(err) {
+				break
+			}
+			id, _ := pkt.Header().GetInt("flood_id")
+			assert.Equal(lastID+1, id)
+			lastID = id
+
+			// slow reader
+			time.Sleep(time.Millisecond)
+		}
+		assert.Equal(199, lastID)
+		assert.NoError(c.Close())
+
+		stats := <-done
+		assert.Equal(4, stats.RemoteWindow)
+		assert.Equal(uint64(0), stats.PacketsResent, "the writer must not overrun the reader %+v", stats)
+	})
+}
+
+func TestStalledReader(t *testing.T) {
+	logs.ResetLogger()
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		A.setOptions(DisableLog())
+		B.setOptions(DisableLog())
+
+		var (
+			assert = assert.New(t)
+			done   = make(chan ChannelStats, 1)
+			c      *Channel
+			ident  *Identity
+			pkt    *lob.Packet
+			err    error
+		)
+
+		go func() {
+			defer close(done)
+
+			// with a retry budget of 2 the writer would give up after about a
+			// second if the buffered packets were considered lost.
+			c, err := A.Listen("stall", true, MaxRetries(2)).AcceptChannel()
+			if assert.NoError(err) && assert.NotNil(c) {
+				pkt, err := c.ReadPacket()
+				assert.NoError(err)
+				assert.NotNil(pkt)
+
+				for i := 0; i < 20; i++ {
+					pkt := lob.New(nil)
+					pkt.Header().SetInt("stall_id", i)
+					err = c.WritePacket(pkt)
+					assert.NoError(err)
+				}
+
+				assert.NoError(c.Close())
+				done <- c.Stats()
+			}
+		}()
+
+		ident, err = A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err = B.Open(ident, "stall", true)
+		assert.NoError(err)
+		assert.NotNil(c)
+
+		c.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+		err = c.WritePacket(lob.New(nil))
+		assert.NoError(err)
+
+		pkt, err = c.ReadPacket()
+		if !assert.NoError(err) {
+			return
+		}
+		assert.NotNil(pkt)
+
+		// stall for longer than the retry budget of the writer
+		time.Sleep(3 * time.Second)
+
+		lastID := 0
+		for {
+			pkt, err = c.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+			if !assert.NoError(err) {
+				break
+			}
+			id, _ := pkt.Header().GetInt("stall_id")
+			assert.Equal(lastID+1, id)
+			lastID = id
+		}
+		assert.Equal(19, lastID)
+		assert.NoError(c.Close())
+
+		stats := <-done
+		assert.True(stats.Timeouts <= 1, "buffered packets must not count as lost %+v", stats)
+	})
+}
+
 func BenchmarkReadWriteReliable(b *testing.B) {
-	defer dumpExpVar(b)
+	defer dumpMetrics(b)
 	logs.ResetLogger()
 
 	withTwoEndpoints(b, func(A, B *Endpoint) {
@@ -303,7 +611,7 @@ func BenchmarkReadWriteReliable(b *testing.B) {
 }
 
 func BenchmarkReadWriteUnreliable(b *testing.B) {
-	defer dumpExpVar(b)
+	defer dumpMetrics(b)
 	logs.ResetLogger()
 
 	withTwoEndpoints(b, func(A, B *Endpoint) {
@@ -379,7 +687,7 @@ func BenchmarkReadWriteUnreliable(b *testing.B) {
 }
 
 func BenchmarkChannels(b *testing.B) {
-	defer dumpExpVar(b)
+	defer dumpMetrics(b)
 	logs.ResetLogger()
 
 	var (
@@ -473,7 +781,7 @@ func BenchmarkChannels(b *testing.B) {
 }
 
 func BenchmarkChannelsReliable(b *testing.B) {
-	defer dumpExpVar(b)
+	defer dumpMetrics(b)
 	logs.ResetLogger()
 
 	var (
@@ -24,6 +24,7 @@ var (
 	_ cipherset.Cipher    = (*cipher)(nil)
 	_ cipherset.State     = (*state)(nil)
 	_ cipherset.Key       = (*key)(nil)
+	_ cipherset.Signer    = (*key)(nil)
 	_ cipherset.Handshake = (*handshake)(nil)
 )
 
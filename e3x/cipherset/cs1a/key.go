@@ -1,8 +1,10 @@
 package cs1a
 
 import (
+	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
 	"math/big"
 
 	"github.com/telehash/gogotelehash/e3x/cipherset"
@@ -11,6 +13,9 @@ import (
 	"github.com/telehash/gogotelehash/internal/util/base32util"
 )
 
+// lenSigInt is the encoded size of the R and S components of a signature.
+const lenSigInt = 21
+
 type key struct {
 	pub struct{ x, y *big.Int }
 	prv struct{ d []byte }
@@ -80,3 +85,57 @@ func (k *key) CanSign() bool {
 func (k *key) CanEncrypt() bool {
 	return k != nil && k.pub.x != nil && k.pub.y != nil
 }
+
+// Sign produces a ECDSA (secp160r1, SHA-256) signature of data.
+// The signature is encoded as the concatenation of R and S (21 bytes each).
+func (k *key) Sign(data []byte) ([]byte, error) {
+	if !k.CanSign() || !k.CanEncrypt() {
+		return nil, cipherset.ErrCannotSign
+	}
+
+	var (
+		hash = sha256.Sum256(data)
+		prv  = &ecdsa.PrivateKey{
+			PublicKey: k.ecdsaPublicKey(),
+			D:         new(big.Int).SetBytes(k.prv.d),
+		}
+	)
+
+	r, s, err := ecdsa.Sign(rand.Reader, prv, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	sig := make([]byte, 2*lenSigInt)
+	r.FillBytes(sig[:lenSigInt])
+	s.FillBytes(sig[lenSigInt:])
+	return sig, nil
+}
+
+// Verify checks the signature (as produced by Sign) of data.
+func (k *key) Verify(data, sig []byte) error {
+	if !k.CanEncrypt() {
+		return cipherset.ErrCannotSign
+	}
+
+	if len(sig) != 2*lenSigInt {
+		return cipherset.ErrInvalidSignature
+	}
+
+	var (
+		hash = sha256.Sum256(data)
+		pub  = k.ecdsaPublicKey()
+		r    = new(big.Int).SetBytes(sig[:lenSigInt])
+		s    = new(big.Int).SetBytes(sig[lenSigInt:])
+	)
+
+	if !ecdsa.Verify(&pub, hash[:], r, s) {
+		return cipherset.ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func (k *key) ecdsaPublicKey() ecdsa.PublicKey {
+	return ecdsa.PublicKey{Curve: secp160r1.P160(), X: k.pub.x, Y: k.pub.y}
+}
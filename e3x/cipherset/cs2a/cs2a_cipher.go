@@ -0,0 +1,572 @@
+package cs2a
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	cryptocipher "crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+var (
+	_ cipherset.Cipher    = (*cipher)(nil)
+	_ cipherset.State     = (*state)(nil)
+	_ cipherset.Key       = (*key)(nil)
+	_ cipherset.Signer    = (*key)(nil)
+	_ cipherset.Handshake = (*handshake)(nil)
+)
+
+const (
+	lenRSA   = lenKeyBits / 8 // length of the encrypted line key and the signature
+	lenIV    = 12
+	lenAuth  = 16
+	lenToken = 16
+	lenAES   = 32
+)
+
+func init() {
+	cipherset.Register(0x2a, &cipher{})
+}
+
+type cipher struct{}
+
+type handshake struct {
+	key     *key
+	lineKey *lineKey
+	lineBox []byte // the RSA encrypted line key (used to derive the token)
+	parts   cipherset.Parts
+	at      uint32
+}
+
+func (h *handshake) Parts() cipherset.Parts {
+	return h.parts
+}
+
+func (h *handshake) PublicKey() cipherset.Key {
+	return h.key
+}
+
+func (h *handshake) At() uint32 { return h.at }
+func (*handshake) CSID() uint8  { return 0x2a }
+func (*cipher) CSID() uint8     { return 0x2a }
+
+func (c *cipher) DecodeKeyBytes(pub, prv []byte) (cipherset.Key, error) {
+	return decodeKeyBytes(pub, prv)
+}
+
+func (c *cipher) GenerateKey() (cipherset.Key, error) {
+	return generateKey()
+}
+
+func (c *cipher) NewState(localKey cipherset.Key) (cipherset.State, error) {
+	if k, ok := localKey.(*key); ok && k != nil && k.CanEncrypt() && k.CanSign() {
+		s := &state{localKey: k}
+		s.update()
+		return s, nil
+	}
+	return nil, cipherset.ErrInvalidKey
+}
+
+func (c *cipher) DecryptMessage(localKey, remoteKey cipherset.Key, p []byte) ([]byte, error) {
+	if len(p) < lenRSA+lenIV+lenAuth+lenRSA {
+		return nil, cipherset.ErrInvalidMessage
+	}
+
+	var (
+		ctLen            = len(p) - (lenRSA + lenIV + lenRSA)
+		cs2aLocalKey, _  = localKey.(*key)
+		cs2aRemoteKey, _ = remoteKey.(*key)
+		lineBox          = p[:lenRSA]
+		iv               = p[lenRSA : lenRSA+lenIV]
+		ciphertext       = p[lenRSA+lenIV : lenRSA+lenIV+ctLen]
+		sig              = p[lenRSA+lenIV+ctLen:]
+	)
+
+	if !cs2aLocalKey.CanSign() || !cs2aRemoteKey.CanEncrypt() {
+		return nil, cipherset.ErrInvalidState
+	}
+
+	{ // verify signature
+		digest := sha256.Sum256(p[:lenRSA+lenIV+ctLen])
+		err := rsa.VerifyPKCS1v15(cs2aRemoteKey.pub, crypto.SHA256, digest[:], sig)
+		if err != nil {
+			return nil, cipherset.ErrInvalidMessage
+		}
+	}
+
+	remoteLineKey, err := openLineKey(cs2aLocalKey, lineBox)
+	if err != nil {
+		return nil, cipherset.ErrInvalidMessage
+	}
+
+	out, err := openMessage(remoteLineKey, iv, ciphertext)
+	if err != nil {
+		return nil, cipherset.ErrInvalidMessage
+	}
+
+	return out, nil
+}
+
+func (c *cipher) DecryptHandshake(localKey cipherset.Key, p []byte) (cipherset.Handshake, error) {
+	if len(p) < lenRSA+lenIV+lenAuth+lenRSA {
+		return nil, cipherset.ErrInvalidMessage
+	}
+
+	var (
+		ctLen           = len(p) - (lenRSA + lenIV + lenRSA)
+		cs2aLocalKey, _ = localKey.(*key)
+		lineBox         = p[:lenRSA]
+		iv              = p[lenRSA : lenRSA+lenIV]
+		ciphertext      = p[lenRSA+lenIV : lenRSA+lenIV+ctLen]
+		sig             = p[lenRSA+lenIV+ctLen:]
+		hshake          *handshake
+	)
+
+	if !cs2aLocalKey.CanSign() {
+		return nil, cipherset.ErrInvalidState
+	}
+
+	remoteLineKey, err := openLineKey(cs2aLocalKey, lineBox)
+	if err != nil {
+		return nil, cipherset.ErrInvalidMessage
+	}
+
+	out, err := openMessage(remoteLineKey, iv, ciphertext)
+	if err != nil {
+		return nil, cipherset.ErrInvalidMessage
+	}
+
+	{ // decode inner
+		buf := bufpool.New()
+		if len(out) > cap(buf.RawBytes()) {
+			buf.Free()
+			return nil, cipherset.ErrInvalidMessage
+		}
+
+		inner, err := lob.Decode(buf.Set(out))
+		buf.Free()
+		if err != nil {
+			return nil, cipherset.ErrInvalidMessage
+		}
+
+		at, hasAt := inner.Header().GetUint32("at")
+		if !hasAt {
+			return nil, cipherset.ErrInvalidMessage
+		}
+
+		delete(inner.Header().Extra, "at")
+
+		parts, err := cipherset.PartsFromHeader(inner.Header())
+		if err != nil {
+			return nil, cipherset.ErrInvalidMessage
+		}
+
+		remoteKey, err := decodeKeyBytes(inner.Body(nil), nil)
+		if err != nil || !remoteKey.CanEncrypt() {
+			return nil, cipherset.ErrInvalidMessage
+		}
+
+		hshake = &handshake{}
+		hshake.at = at
+		hshake.key = remoteKey
+		hshake.lineKey = remoteLineKey
+		hshake.lineBox = append([]byte{}, lineBox...)
+		hshake.parts = parts
+	}
+
+	{ // verify signature
+		digest := sha256.Sum256(p[:lenRSA+lenIV+ctLen])
+		err := rsa.VerifyPKCS1v15(hshake.key.pub, crypto.SHA256, digest[:], sig)
+		if err != nil {
+			return nil, cipherset.ErrInvalidMessage
+		}
+	}
+
+	return hshake, nil
+}
+
+// openLineKey decrypts the RSA-OAEP encrypted line key of the sender.
+func openLineKey(localKey *key, box []byte) (*lineKey, error) {
+	pub, err := rsa.DecryptOAEP(sha1.New(), nil, localKey.prv, box, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeLineKey(pub)
+}
+
+// openMessage decrypts a message BODY which was encrypted using
+// the SHA-256 digest of the sender's line key.
+func openMessage(remoteLineKey *lineKey, iv, ciphertext []byte) ([]byte, error) {
+	aead, err := newMessageAEAD(remoteLineKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Open(nil, iv, ciphertext, nil)
+}
+
+func newMessageAEAD(k *lineKey) (cryptocipher.AEAD, error) {
+	aesKey := sha256.Sum256(k.Public())
+	return newAEAD(aesKey[:])
+}
+
+func newAEAD(aesKey []byte) (cryptocipher.AEAD, error) {
+	aesBlock, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cryptocipher.NewGCM(aesBlock)
+}
+
+type state struct {
+	mtx            sync.RWMutex
+	localKey       *key
+	remoteKey      *key
+	localLineKey   *lineKey
+	remoteLineKey  *lineKey
+	localLineBox   []byte
+	remoteLineBox  []byte
+	localToken     *cipherset.Token
+	remoteToken    *cipherset.Token
+	lineEncryption cryptocipher.AEAD
+	lineDecryption cryptocipher.AEAD
+	pktNoncePrefix *[4]byte
+	pktNonceSuffix uint64
+}
+
+func (*state) CSID() uint8 { return 0x2a }
+
+func (s *state) IsHigh() bool {
+	if s.localKey != nil && s.remoteKey != nil {
+		return bytes.Compare(s.remoteKey.Public(), s.localKey.Public()) < 0
+	}
+	return false
+}
+
+func (s *state) LocalToken() cipherset.Token {
+	if s.localToken != nil {
+		return *s.localToken
+	}
+	return cipherset.ZeroToken
+}
+
+func (s *state) RemoteToken() cipherset.Token {
+	if s.remoteToken != nil {
+		return *s.remoteToken
+	}
+	return cipherset.ZeroToken
+}
+
+func (s *state) SetRemoteKey(remoteKey cipherset.Key) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if k, ok := remoteKey.(*key); ok && k != nil && k.CanEncrypt() {
+		s.remoteKey = k
+		s.update()
+		return nil
+	}
+
+	return cipherset.ErrInvalidKey
+}
+
+func (s *state) setRemoteLineKey(k *lineKey, box []byte) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.remoteLineKey = k
+	s.remoteLineBox = box
+	s.update()
+}
+
+func (s *state) update() {
+	if s.pktNoncePrefix == nil {
+		s.pktNoncePrefix = new([4]byte)
+		io.ReadFull(rand.Reader, s.pktNoncePrefix[:])
+	}
+
+	// generate a local line Key
+	if s.localLineKey == nil {
+		s.localLineKey, _ = generateLineKey()
+	}
+
+	// encrypt the local line key for the remote key
+	if s.localLineBox == nil && s.localLineKey != nil && s.remoteKey.CanEncrypt() {
+		s.localLineBox, _ = rsa.EncryptOAEP(sha1.New(), rand.Reader, s.remoteKey.pub, s.localLineKey.Public(), nil)
+	}
+
+	// make local token
+	if s.localToken == nil && s.localLineBox != nil {
+		s.localToken = new(cipherset.Token)
+		sha := sha256.Sum256(s.localLineBox[:lenToken])
+		copy((*s.localToken)[:], sha[:lenToken])
+	}
+
+	// make remote token
+	if s.remoteToken == nil && s.remoteLineBox != nil {
+		s.remoteToken = new(cipherset.Token)
+		sha := sha256.Sum256(s.remoteLineBox[:lenToken])
+		copy((*s.remoteToken)[:], sha[:lenToken])
+	}
+
+	// generate line keys
+	if s.localToken != nil && s.remoteToken != nil &&
+		(s.lineEncryption == nil || s.lineDecryption == nil) {
+		sharedKey, err := s.localLineKey.prv.ECDH(s.remoteLineKey.pub)
+		if err != nil {
+			return
+		}
+
+		var (
+			encKey [lenAES]byte
+			decKey [lenAES]byte
+		)
+
+		sha := sha256.New()
+		sha.Write(sharedKey)
+		sha.Write(s.localLineKey.Public())
+		sha.Write(s.remoteLineKey.Public())
+		sha.Sum(encKey[:0])
+
+		sha.Reset()
+		sha.Write(sharedKey)
+		sha.Write(s.remoteLineKey.Public())
+		sha.Write(s.localLineKey.Public())
+		sha.Sum(decKey[:0])
+
+		s.lineEncryption, _ = newAEAD(encKey[:])
+		s.lineDecryption, _ = newAEAD(decKey[:])
+	}
+}
+
+func (s *state) NeedsRemoteKey() bool {
+	return s.remoteKey == nil
+}
+
+func (s *state) CanEncryptMessage() bool {
+	return s.localKey != nil && s.remoteKey != nil && s.localLineKey != nil && s.localLineBox != nil
+}
+
+func (s *state) CanEncryptHandshake() bool {
+	return s.CanEncryptMessage()
+}
+
+func (s *state) CanEncryptPacket() bool {
+	return s.lineEncryption != nil && s.remoteToken != nil
+}
+
+func (s *state) CanDecryptMessage() bool {
+	return s.localKey != nil && s.remoteKey != nil && s.localLineKey != nil
+}
+
+func (s *state) CanDecryptHandshake() bool {
+	return s.localKey != nil && s.localLineKey != nil
+}
+
+func (s *state) CanDecryptPacket() bool {
+	return s.lineDecryption != nil && s.localToken != nil
+}
+
+func (s *state) EncryptMessage(in []byte) ([]byte, error) {
+	if !s.CanEncryptMessage() {
+		panic("unable to encrypt message")
+	}
+
+	var (
+		out = make([]byte, lenRSA+lenIV, lenRSA+lenIV+len(in)+lenAuth+lenRSA)
+		iv  = out[lenRSA : lenRSA+lenIV]
+	)
+
+	// copy the encrypted line key
+	copy(out[:lenRSA], s.localLineBox)
+
+	// make the iv
+	_, err := io.ReadFull(rand.Reader, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	{ // encrypt inner
+		aead, err := newMessageAEAD(s.localLineKey)
+		if err != nil {
+			return nil, err
+		}
+
+		out = aead.Seal(out, iv, in, nil)
+	}
+
+	{ // sign message
+		digest := sha256.Sum256(out)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, s.localKey.prv, crypto.SHA256, digest[:])
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, sig...)
+	}
+
+	return out, nil
+}
+
+func (s *state) EncryptHandshake(at uint32, compact cipherset.Parts) ([]byte, error) {
+	pkt := lob.New(s.localKey.Public())
+	compact.ApplyToHeader(pkt.Header())
+	pkt.Header().SetUint32("at", at)
+	data, err := lob.Encode(pkt)
+	if err != nil {
+		return nil, err
+	}
+	return s.EncryptMessage(data.Get(nil))
+}
+
+func (s *state) ApplyHandshake(h cipherset.Handshake) bool {
+	var (
+		hs, _ = h.(*handshake)
+	)
+
+	if hs == nil {
+		return false
+	}
+
+	if s.remoteKey != nil && !s.remoteKey.pub.Equal(hs.key.pub) {
+		return false
+	}
+
+	if s.remoteLineKey != nil && !s.remoteLineKey.Equal(hs.lineKey) {
+		s.remoteLineKey = nil
+		s.remoteLineBox = nil
+		s.remoteToken = nil
+		s.lineDecryption = nil
+		s.lineEncryption = nil
+	}
+
+	s.setRemoteLineKey(hs.lineKey, hs.lineBox)
+	if s.remoteKey == nil {
+		s.SetRemoteKey(hs.key)
+	}
+	return true
+}
+
+func (s *state) EncryptPacket(pkt *lob.Packet) (*lob.Packet, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	var (
+		outer   *lob.Packet
+		inner   *bufpool.Buffer
+		body    *bufpool.Buffer
+		bodyRaw []byte
+		nonce   [lenIV]byte
+		ctLen   int
+		err     error
+	)
+
+	if !s.CanEncryptPacket() {
+		return nil, cipherset.ErrInvalidState
+	}
+	if pkt == nil {
+		return nil, nil
+	}
+
+	// encode inner packet
+	inner, err = lob.Encode(pkt)
+	if err != nil {
+		return nil, err
+	}
+
+	// make nonce
+	copy(nonce[:], s.pktNoncePrefix[:])
+	nonceSuffix := atomic.AddUint64(&s.pktNonceSuffix, 1)
+	binary.BigEndian.PutUint64(nonce[4:], nonceSuffix)
+
+	// alloc enough space
+	body = bufpool.New().SetLen(lenToken + lenIV + inner.Len() + lenAuth)
+	bodyRaw = body.RawBytes()
+
+	// copy token
+	copy(bodyRaw[:lenToken], s.remoteToken[:])
+
+	// copy nonce
+	copy(bodyRaw[lenToken:lenToken+lenIV], nonce[:])
+
+	// encrypt inner packet (the token is authenticated as well)
+	ctLen = len(s.lineEncryption.Seal(
+		bodyRaw[lenToken+lenIV:lenToken+lenIV], nonce[:], inner.RawBytes(), bodyRaw[:lenToken]))
+	body.SetLen(lenToken + lenIV + ctLen)
+
+	outer = lob.New(body.RawBytes())
+	inner.Free()
+	body.Free()
+
+	return outer, nil
+}
+
+func (s *state) DecryptPacket(pkt *lob.Packet) (*lob.Packet, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if !s.CanDecryptPacket() {
+		return nil, cipherset.ErrInvalidState
+	}
+	if pkt == nil {
+		return nil, nil
+	}
+
+	if !pkt.Header().IsZero() || pkt.BodyLen() < lenToken+lenIV+lenAuth {
+		return nil, cipherset.ErrInvalidPacket
+	}
+
+	var (
+		bodyRaw  []byte
+		innerRaw []byte
+		body     = bufpool.New()
+		inner    = bufpool.New().SetLen(pkt.BodyLen())
+		err      error
+	)
+
+	pkt.Body(body.SetLen(pkt.BodyLen()).RawBytes()[:0])
+	bodyRaw = body.RawBytes()
+	innerRaw = inner.RawBytes()
+
+	// compare token
+	if !bytes.Equal(bodyRaw[:lenToken], (*s.localToken)[:]) {
+		inner.Free()
+		body.Free()
+		return nil, cipherset.ErrInvalidPacket
+	}
+
+	// decrypt inner packet
+	innerRaw, err = s.lineDecryption.Open(
+		innerRaw[:0], bodyRaw[lenToken:lenToken+lenIV], bodyRaw[lenToken+lenIV:], bodyRaw[:lenToken])
+	if err != nil {
+		inner.Free()
+		body.Free()
+		return nil, cipherset.ErrInvalidPacket
+	}
+	inner.SetLen(len(innerRaw))
+
+	innerPkt, err := lob.Decode(inner)
+	if err != nil {
+		inner.Free()
+		body.Free()
+		return nil, err
+	}
+
+	inner.Free()
+	body.Free()
+
+	return innerPkt, nil
+}
@@ -0,0 +1,7 @@
+// Package cs2a implements Cipher Set 2a.
+//
+// Reference
+//
+// Cipher Sets: https://github.com/telehash/telehash.org/blob/v3/v3/e3x/cs/README.md
+// CS2a: https://github.com/telehash/telehash.org/blob/v3/v3/e3x/cs/2a.md
+package cs2a
@@ -0,0 +1,166 @@
+package cs2a
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/util/base32util"
+)
+
+const (
+	lenKeyBits = 2048
+)
+
+type key struct {
+	pub *rsa.PublicKey
+	prv *rsa.PrivateKey
+}
+
+func decodeKeyBytes(pub, prv []byte) (*key, error) {
+	var (
+		k = &key{}
+	)
+
+	if len(pub) != 0 {
+		i, err := x509.ParsePKIXPublicKey(pub)
+		if err != nil {
+			return nil, cipherset.ErrInvalidKey
+		}
+
+		rsaPub, ok := i.(*rsa.PublicKey)
+		if !ok || rsaPub.N.BitLen() != lenKeyBits {
+			return nil, cipherset.ErrInvalidKey
+		}
+
+		k.pub = rsaPub
+	}
+
+	if len(prv) != 0 {
+		rsaPrv, err := x509.ParsePKCS1PrivateKey(prv)
+		if err != nil {
+			return nil, cipherset.ErrInvalidKey
+		}
+
+		if rsaPrv.N.BitLen() != lenKeyBits {
+			return nil, cipherset.ErrInvalidKey
+		}
+
+		if k.pub != nil && !k.pub.Equal(&rsaPrv.PublicKey) {
+			return nil, cipherset.ErrInvalidKey
+		}
+
+		k.prv = rsaPrv
+		k.pub = &rsaPrv.PublicKey
+	}
+
+	return k, nil
+}
+
+func generateKey() (*key, error) {
+	prv, err := rsa.GenerateKey(rand.Reader, lenKeyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key{pub: &prv.PublicKey, prv: prv}, nil
+}
+
+func (k *key) CSID() uint8 { return 0x2a }
+
+func (k *key) Public() []byte {
+	if k == nil || k.pub == nil {
+		return nil
+	}
+
+	buf, err := x509.MarshalPKIXPublicKey(k.pub)
+	if err != nil {
+		return nil
+	}
+	return buf
+}
+
+func (k *key) Private() []byte {
+	if k == nil || k.prv == nil {
+		return nil
+	}
+
+	return x509.MarshalPKCS1PrivateKey(k.prv)
+}
+
+func (k *key) String() string {
+	return base32util.EncodeToString(k.Public())
+}
+
+func (k *key) CanSign() bool {
+	return k != nil && k.prv != nil
+}
+
+func (k *key) CanEncrypt() bool {
+	return k != nil && k.pub != nil
+}
+
+// Sign produces a RSA PKCS#1 v1.5 (SHA-256) signature of data.
+func (k *key) Sign(data []byte) ([]byte, error) {
+	if !k.CanSign() {
+		return nil, cipherset.ErrCannotSign
+	}
+
+	digest := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(rand.Reader, k.prv, crypto.SHA256, digest[:])
+}
+
+// Verify checks the signature (as produced by Sign) of data.
+func (k *key) Verify(data, sig []byte) error {
+	if !k.CanEncrypt() {
+		return cipherset.ErrCannotSign
+	}
+
+	digest := sha256.Sum256(data)
+	if rsa.VerifyPKCS1v15(k.pub, crypto.SHA256, digest[:], sig) != nil {
+		return cipherset.ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// lineKey is an ephemeral ECC P-256 key.
+type lineKey struct {
+	pub *ecdh.PublicKey
+	prv *ecdh.PrivateKey
+}
+
+func generateLineKey() (*lineKey, error) {
+	prv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lineKey{pub: prv.PublicKey(), prv: prv}, nil
+}
+
+func decodeLineKey(pub []byte) (*lineKey, error) {
+	k, err := ecdh.P256().NewPublicKey(pub)
+	if err != nil {
+		return nil, cipherset.ErrInvalidKey
+	}
+
+	return &lineKey{pub: k}, nil
+}
+
+func (k *lineKey) Public() []byte {
+	if k == nil || k.pub == nil {
+		return nil
+	}
+
+	return k.pub.Bytes()
+}
+
+func (k *lineKey) Equal(o *lineKey) bool {
+	return bytes.Equal(k.Public(), o.Public())
+}
@@ -587,7 +587,7 @@ func (s *state) DecryptPacket(pkt *lob.Packet) (*lob.Packet, error) {
 		innerRaw []byte
 		innerPkt *lob.Packet
 		body     = bufpool.New()
-		inner    = bufpool.New()
+		inner    = bufpool.New().SetLen(pkt.BodyLen())
 		ok       bool
 	)
 
@@ -0,0 +1,174 @@
+package cipherset
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/golang.org/x/crypto/nacl/secretbox"
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/golang.org/x/crypto/scrypt"
+
+	"github.com/telehash/gogotelehash/internal/util/base32util"
+)
+
+var (
+	ErrInvalidKeystore   = errors.New("cipherset: invalid keystore")
+	ErrInvalidPassphrase = errors.New("cipherset: invalid passphrase")
+)
+
+const (
+	keystoreKDF      = "scrypt"
+	keystoreLenSalt  = 32
+	keystoreLenNonce = 24
+	keystoreLenKey   = 32
+
+	// limits of the scrypt parameters accepted from a keystore; scrypt
+	// allocates 128*N*R bytes.
+	keystoreMaxN      = 1 << 20
+	keystoreMaxR      = 32
+	keystoreMaxP      = 16
+	keystoreMaxMemory = 1 << 30
+)
+
+// KeystoreParams are the scrypt cost parameters used to derive the
+// encryption key from a passphrase.
+type KeystoreParams struct {
+	N int
+	R int
+	P int
+}
+
+// DefaultKeystoreParams are the recommended scrypt parameters for interactive use.
+var DefaultKeystoreParams = KeystoreParams{N: 1 << 15, R: 8, P: 1}
+
+// valid returns true when the parameters are within the limits accepted by
+// DecryptPrivateKeys.
+func (p KeystoreParams) valid() bool {
+	return p.N > 1 && p.N <= keystoreMaxN && p.N&(p.N-1) == 0 &&
+		p.R > 0 && p.R <= keystoreMaxR &&
+		p.P > 0 && p.P <= keystoreMaxP &&
+		p.N <= keystoreMaxMemory/(128*p.R) // 128*N*R overflows on 32-bit platforms
+}
+
+// keystore is the JSON envelope containing the encrypted private keys.
+type keystore struct {
+	KDF   string `json:"kdf"`
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Salt  string `json:"salt"`
+	Nonce string `json:"nonce"`
+	Box   string `json:"box"`
+}
+
+// EncryptPrivateKeys encrypts keys with passphrase and returns a JSON encoded
+// keystore envelope. The encryption key is derived using scrypt and the keys are
+// sealed with NaCl secretbox.
+func EncryptPrivateKeys(keys PrivateKeys, passphrase []byte) ([]byte, error) {
+	return EncryptPrivateKeysWithParams(keys, passphrase, DefaultKeystoreParams)
+}
+
+// EncryptPrivateKeysWithParams is like EncryptPrivateKeys but allows the caller
+// to select the scrypt parameters.
+func EncryptPrivateKeysWithParams(keys PrivateKeys, passphrase []byte, params KeystoreParams) ([]byte, error) {
+	var (
+		salt  [keystoreLenSalt]byte
+		nonce [keystoreLenNonce]byte
+		key   [keystoreLenKey]byte
+	)
+
+	plaintext, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if !params.valid() {
+		return nil, errors.New("cipherset: invalid keystore parameters")
+	}
+
+	if _, err = io.ReadFull(rand.Reader, salt[:]); err != nil {
+		return nil, err
+	}
+
+	if _, err = io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+
+	derived, err := scrypt.Key(passphrase, salt[:], params.N, params.R, params.P, keystoreLenKey)
+	if err != nil {
+		return nil, err
+	}
+	copy(key[:], derived)
+
+	box := secretbox.Seal(nil, plaintext, &nonce, &key)
+
+	return json.Marshal(&keystore{
+		KDF:   keystoreKDF,
+		N:     params.N,
+		R:     params.R,
+		P:     params.P,
+		Salt:  base32util.EncodeToString(salt[:]),
+		Nonce: base32util.EncodeToString(nonce[:]),
+		Box:   base32util.EncodeToString(box),
+	})
+}
+
+// DecryptPrivateKeys decrypts a keystore envelope (as returned by EncryptPrivateKeys).
+// ErrInvalidPassphrase is returned when the passphrase is wrong or when
+// the keystore was tampered with.
+func DecryptPrivateKeys(data []byte, passphrase []byte) (PrivateKeys, error) {
+	var (
+		ks    keystore
+		nonce [keystoreLenNonce]byte
+		key   [keystoreLenKey]byte
+		keys  PrivateKeys
+	)
+
+	err := json.Unmarshal(data, &ks)
+	if err != nil {
+		return nil, ErrInvalidKeystore
+	}
+
+	if ks.KDF != keystoreKDF {
+		return nil, ErrInvalidKeystore
+	}
+
+	if !(KeystoreParams{N: ks.N, R: ks.R, P: ks.P}).valid() {
+		return nil, ErrInvalidKeystore
+	}
+
+	salt, err := base32util.DecodeString(ks.Salt)
+	if err != nil || len(salt) == 0 {
+		return nil, ErrInvalidKeystore
+	}
+
+	nonceBytes, err := base32util.DecodeString(ks.Nonce)
+	if err != nil || len(nonceBytes) != keystoreLenNonce {
+		return nil, ErrInvalidKeystore
+	}
+	copy(nonce[:], nonceBytes)
+
+	box, err := base32util.DecodeString(ks.Box)
+	if err != nil || len(box) < secretbox.Overhead {
+		return nil, ErrInvalidKeystore
+	}
+
+	derived, err := scrypt.Key(passphrase, salt, ks.N, ks.R, ks.P, keystoreLenKey)
+	if err != nil {
+		return nil, ErrInvalidKeystore
+	}
+	copy(key[:], derived)
+
+	plaintext, ok := secretbox.Open(nil, box, &nonce, &key)
+	if !ok {
+		return nil, ErrInvalidPassphrase
+	}
+
+	err = json.Unmarshal(plaintext, &keys)
+	if err != nil {
+		return nil, ErrInvalidKeystore
+	}
+
+	return keys, nil
+}
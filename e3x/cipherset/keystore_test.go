@@ -0,0 +1,80 @@
+package cipherset_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	. "github.com/telehash/gogotelehash/e3x/cipherset"
+	_ "github.com/telehash/gogotelehash/e3x/cipherset/cs3a"
+)
+
+var testKeystoreParams = KeystoreParams{N: 1 << 4, R: 8, P: 1}
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	keys, err := GenerateKeys(0x3a)
+	if !assert.NoError(err) {
+		return
+	}
+
+	data, err := EncryptPrivateKeysWithParams(PrivateKeys(keys), []byte("secret"), testKeystoreParams)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NotContains(string(data), keys[0x3a].String())
+
+	decoded, err := DecryptPrivateKeys(data, []byte("secret"))
+	if assert.NoError(err) && assert.NotNil(decoded[0x3a]) {
+		assert.Equal(keys[0x3a].Public(), decoded[0x3a].Public())
+		assert.Equal(keys[0x3a].Private(), decoded[0x3a].Private())
+	}
+}
+
+func TestKeystoreInvalidPassphrase(t *testing.T) {
+	assert := assert.New(t)
+
+	keys, err := GenerateKeys(0x3a)
+	if !assert.NoError(err) {
+		return
+	}
+
+	data, err := EncryptPrivateKeysWithParams(PrivateKeys(keys), []byte("secret"), testKeystoreParams)
+	if !assert.NoError(err) {
+		return
+	}
+
+	_, err = DecryptPrivateKeys(data, []byte("wrong"))
+	assert.Equal(ErrInvalidPassphrase, err)
+
+	_, err = DecryptPrivateKeys([]byte(`{"kdf":"pbkdf2"}`), []byte("secret"))
+	assert.Equal(ErrInvalidKeystore, err)
+}
+
+func TestKeystoreExpensiveParams(t *testing.T) {
+	assert := assert.New(t)
+
+	keys, err := GenerateKeys(0x3a)
+	if !assert.NoError(err) {
+		return
+	}
+
+	_, err = EncryptPrivateKeysWithParams(PrivateKeys(keys), []byte("secret"), KeystoreParams{N: 1 << 30, R: 8, P: 1})
+	assert.Error(err)
+
+	// 128*N*R is 4 GiB (and 0 when computed in a 32-bit int)
+	_, err = EncryptPrivateKeysWithParams(PrivateKeys(keys), []byte("secret"), KeystoreParams{N: 1 << 20, R: 32, P: 1})
+	assert.EqualError(err, "cipherset: invalid keystore parameters")
+
+	data, err := EncryptPrivateKeysWithParams(PrivateKeys(keys), []byte("secret"), testKeystoreParams)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// a keystore must not be able to make the decoder allocate huge amounts of memory
+	data = []byte(strings.Replace(string(data), `"n":16,`, `"n":1073741824,`, 1))
+	_, err = DecryptPrivateKeys(data, []byte("secret"))
+	assert.Equal(ErrInvalidKeystore, err)
+}
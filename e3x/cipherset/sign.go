@@ -0,0 +1,39 @@
+package cipherset
+
+import (
+	"errors"
+)
+
+var (
+	ErrCannotSign       = errors.New("cipherset: key cannot sign")
+	ErrInvalidSignature = errors.New("cipherset: invalid signature")
+)
+
+// Signer is implemented by keys that can produce signatures.
+type Signer interface {
+	Key
+
+	Sign(data []byte) ([]byte, error)
+	Verify(data, sig []byte) error
+}
+
+// Sign signs data with key. ErrCannotSign is returned when the cipherset of key
+// doesn't support signatures or when key has no private part.
+func Sign(key Key, data []byte) ([]byte, error) {
+	signer, ok := key.(Signer)
+	if !ok || !key.CanSign() {
+		return nil, ErrCannotSign
+	}
+
+	return signer.Sign(data)
+}
+
+// Verify checks sig is a valid signature of data made by key.
+func Verify(key Key, data, sig []byte) error {
+	signer, ok := key.(Signer)
+	if !ok || !key.CanEncrypt() {
+		return ErrCannotSign
+	}
+
+	return signer.Verify(data, sig)
+}
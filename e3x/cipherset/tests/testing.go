@@ -385,3 +385,38 @@ func BenchmarkPacketDecryption(b *testing.B, c cipherset.Cipher) {
 		}
 	}
 }
+
+func (s *cipherTestSuite) TestSign() {
+	var (
+		assert = s.Assertions
+		c      = s.cipher
+	)
+
+	ka, err := c.GenerateKey()
+	assert.NoError(err)
+	assert.NotNil(ka)
+
+	if _, ok := ka.(cipherset.Signer); !ok {
+		_, err = cipherset.Sign(ka, []byte("Hello World!"))
+		assert.Equal(cipherset.ErrCannotSign, err)
+		return
+	}
+
+	kb, err := c.GenerateKey()
+	assert.NoError(err)
+	assert.NotNil(kb)
+
+	pub, err := c.DecodeKeyBytes(ka.Public(), nil)
+	assert.NoError(err)
+
+	sig, err := cipherset.Sign(ka, []byte("Hello World!"))
+	assert.NoError(err)
+	assert.NotEmpty(sig)
+
+	_, err = cipherset.Sign(pub, []byte("Hello World!"))
+	assert.Equal(cipherset.ErrCannotSign, err)
+
+	assert.NoError(cipherset.Verify(pub, []byte("Hello World!"), sig))
+	assert.Equal(cipherset.ErrInvalidSignature, cipherset.Verify(pub, []byte("Hello World?"), sig))
+	assert.Equal(cipherset.ErrInvalidSignature, cipherset.Verify(kb, []byte("Hello World!"), sig))
+}
@@ -0,0 +1,21 @@
+package e3x
+
+import (
+	"context"
+	"sync"
+)
+
+// wakeOnDone broadcasts cnd when ctx is done. This allows goroutines waiting
+// on cnd to observe the cancellation of ctx. The returned function must be called
+// to release the associated resources.
+func wakeOnDone(ctx context.Context, l sync.Locker, cnd *sync.Cond) (stop func() bool) {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+
+	return context.AfterFunc(ctx, func() {
+		l.Lock()
+		cnd.Broadcast()
+		l.Unlock()
+	})
+}
@@ -0,0 +1,119 @@
+package e3x
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestContextCancellation(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	l := A.Listen("ctx", true)
+
+	{ // accept without channels
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		c, err := l.AcceptChannelContext(ctx)
+		cancel()
+		assert.Nil(c)
+		assert.Equal(context.DeadlineExceeded, err)
+	}
+
+	identA, err := A.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := B.OpenContext(context.Background(), identA, "ctx", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	assert.NoError(c.WritePacketContext(context.Background(), lob.New([]byte("hello"))))
+
+	{ // the client must wait for a response before writing again
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := c.WritePacketContext(ctx, lob.New([]byte("hello")))
+		cancel()
+		assert.Equal(context.DeadlineExceeded, err)
+	}
+
+	{ // read without packets
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		pkt, err := c.ReadPacketContext(ctx)
+		assert.Nil(pkt)
+		assert.Equal(context.Canceled, err)
+	}
+
+	{ // the accepted channel is still usable
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		s, err := l.AcceptChannelContext(ctx)
+		cancel()
+		if assert.NoError(err) {
+			defer s.Kill()
+
+			pkt, err := s.ReadPacketContext(context.Background())
+			if assert.NoError(err) {
+				assert.Equal("hello", string(pkt.Body(nil)))
+			}
+		}
+	}
+}
+
+func TestDialContextCancellation(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+
+	identB, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	// B can no longer respond to handshakes
+	B.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	x, err := A.DialContext(ctx, identB)
+	assert.Nil(x)
+	assert.Equal(context.DeadlineExceeded, err)
+
+	c, err := A.OpenContext(ctx, identB, "ctx", true)
+	assert.Nil(c)
+	assert.Equal(context.DeadlineExceeded, err)
+}
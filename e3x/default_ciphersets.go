@@ -2,6 +2,6 @@ package e3x
 
 import (
 	_ "github.com/telehash/gogotelehash/e3x/cipherset/cs1a"
-	// _ "github.com/telehash/gogotelehash/e3x/cipherset/cs2a"
+	_ "github.com/telehash/gogotelehash/e3x/cipherset/cs2a"
 	_ "github.com/telehash/gogotelehash/e3x/cipherset/cs3a"
 )
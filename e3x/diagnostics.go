@@ -0,0 +1,197 @@
+package e3x
+
+import (
+	"net"
+	"sort"
+	"time"
+
+	"github.com/telehash/gogotelehash/transports"
+)
+
+// PathInfo describes a known path of an exchange (an entry of its address
+// book).
+type PathInfo struct {
+	Addr      net.Addr
+	Active    bool // the path is used to send packets
+	Backup    bool // handshakes are sent over the path
+	Reachable bool
+	Latency   time.Duration // last latency sample
+	EWMA      time.Duration // moving average of the latency
+	MTU       int
+	MaxSize   int // largest message accepted by the connection of the path
+	Added     time.Time
+	ExpireAt  time.Time
+}
+
+// ChannelInfo is a snapshot of the state of a channel.
+type ChannelInfo struct {
+	ID         uint32
+	Type       string
+	Reliable   bool
+	ServerSide bool
+	Broken     bool
+
+	DeliveredEnd bool // the end packet was written
+	ReceivedEnd  bool // the end packet was received
+
+	OSeq         uint32 // highest seq in write stream
+	OAckedSeq    uint32 // highest acked seq in write stream
+	ISeq         uint32 // highest seq in read stream
+	ISeenSeq     uint32 // highest seen seq in read stream
+	IBufferedSeq uint32 // highest buffered seq in read stream
+	IAckedSeq    uint32 // highest acked seq in read stream
+
+	ReadBuffered  int // packets waiting to be read
+	ReadWindow    int
+	WriteBuffered int // packets waiting to be acknowledged
+	WriteWindow   int
+
+	Stats ChannelStats
+}
+
+// ListenerInfo describes a channel listener.
+type ListenerInfo struct {
+	Type       string
+	Reliable   bool
+	Backlog    int // accepted channels waiting for AcceptChannel
+	MaxBacklog int
+}
+
+// Info returns a snapshot of the state of the channel.
+func (c *Channel) Info() ChannelInfo {
+	stats := c.Stats()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return ChannelInfo{
+		ID:         c.id,
+		Type:       c.typ,
+		Reliable:   c.reliable,
+		ServerSide: c.serverside,
+		Broken:     c.broken,
+
+		DeliveredEnd: c.deliveredEnd,
+		ReceivedEnd:  c.receivedEnd,
+
+		OSeq:         c.oSeq,
+		OAckedSeq:    c.oAckedSeq,
+		ISeq:         c.iSeq,
+		ISeenSeq:     c.iSeenSeq,
+		IBufferedSeq: c.iBufferedSeq,
+		IAckedSeq:    c.iAckedSeq,
+
+		ReadBuffered:  len(c.readBuffer),
+		ReadWindow:    c.cfg.readWindow,
+		WriteBuffered: len(c.writeBuffer),
+		WriteWindow:   c.cfg.writeWindow,
+
+		Stats: stats,
+	}
+}
+
+// Channels returns the open channels of the exchange ordered by their id.
+func (x *Exchange) Channels() []*Channel {
+	channels := x.channels.All()
+	sort.Sort(channelsByID(channels))
+	return channels
+}
+
+// GetChannel returns the open channel with id (or nil).
+func (x *Exchange) GetChannel(id uint32) *Channel {
+	return x.channels.Get(id)
+}
+
+// Paths returns the known paths of the exchange. The active path comes first.
+func (x *Exchange) Paths() []PathInfo {
+	book := x.addressBook
+	if book == nil {
+		return nil
+	}
+
+	book.mtx.RLock()
+	paths := make([]PathInfo, 0, len(book.known))
+	for _, e := range book.known {
+		paths = append(paths, PathInfo{
+			Addr:      e.Address,
+			Active:    e == book.active,
+			Backup:    e.IsBackup,
+			Reachable: e.Reachable,
+			Latency:   e.latency,
+			EWMA:      e.ewma,
+			Added:     e.Added,
+			ExpireAt:  e.ExpireAt,
+		})
+	}
+	pipes := make([]*Pipe, len(book.known))
+	for i, e := range book.known {
+		pipes[i] = e.Pipe
+	}
+	book.mtx.RUnlock()
+
+	for i, p := range pipes {
+		if p != nil {
+			paths[i].MTU = p.MTU()
+			paths[i].MaxSize = p.MaxMessageSize()
+		}
+	}
+
+	sort.Stable(pathsByActive(paths))
+	return paths
+}
+
+// Close closes all the channels of the exchange and expires it.
+func (x *Exchange) Close() error {
+	x.expire(nil)
+	return nil
+}
+
+// Listeners returns the channel listeners of the endpoint ordered by type.
+func (e *Endpoint) Listeners() []ListenerInfo {
+	set := e.listenerSet
+
+	set.mtx.RLock()
+	listeners := make([]*Listener, 0, len(set.listeners))
+	for _, l := range set.listeners {
+		listeners = append(listeners, l)
+	}
+	set.mtx.RUnlock()
+
+	infos := make([]ListenerInfo, 0, len(listeners))
+	for _, l := range listeners {
+		l.mtx.Lock()
+		infos = append(infos, ListenerInfo{
+			Type:       l.channelType,
+			Reliable:   l.reliable,
+			Backlog:    l.backlogSize,
+			MaxBacklog: l.maxBacklogSize,
+		})
+		l.mtx.Unlock()
+	}
+
+	sort.Sort(listenersByType(infos))
+	return infos
+}
+
+// Transport returns the opened transport of the endpoint.
+func (e *Endpoint) Transport() transports.Transport {
+	return e.transport
+}
+
+type channelsByID []*Channel
+
+func (s channelsByID) Len() int           { return len(s) }
+func (s channelsByID) Less(i, j int) bool { return s[i].id < s[j].id }
+func (s channelsByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type pathsByActive []PathInfo
+
+func (s pathsByActive) Len() int           { return len(s) }
+func (s pathsByActive) Less(i, j int) bool { return s[i].Active && !s[j].Active }
+func (s pathsByActive) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type listenersByType []ListenerInfo
+
+func (s listenersByType) Len() int           { return len(s) }
+func (s listenersByType) Less(i, j int) bool { return s[i].Type < s[j].Type }
+func (s listenersByType) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -1,18 +1,21 @@
 package e3x
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"sync"
 
+	"github.com/telehash/gogotelehash/e3x/capture"
 	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/e3x/metrics"
+	"github.com/telehash/gogotelehash/e3x/tracer"
 	"github.com/telehash/gogotelehash/internal/hashname"
-	"github.com/telehash/gogotelehash/internal/util/bufpool"
 	"github.com/telehash/gogotelehash/internal/util/logs"
-	"github.com/telehash/gogotelehash/internal/util/tracer"
 	"github.com/telehash/gogotelehash/transports"
 	"github.com/telehash/gogotelehash/transports/mux"
 	"github.com/telehash/gogotelehash/transports/tcp"
@@ -47,9 +50,15 @@ type Endpoint struct {
 	exchangeHooks ExchangeHooks
 	channelHooks  ChannelHooks
 
-	tokens      map[cipherset.Token]*Exchange
-	hashnames   map[hashname.H]*Exchange
-	listenerSet *listenerSet
+	tokens          map[cipherset.Token]*Exchange
+	hashnames       map[hashname.H]*Exchange
+	listenerSet     *listenerSet
+	channelOptions  []ChannelOption
+	exchangeOptions []ExchangeOption
+	metricsSink     metrics.Metrics
+	metrics         *endpointMetrics
+	tracer          *tracer.Tracer
+	capture         *endpointCapture
 }
 
 type EndpointOption func(e *Endpoint) error
@@ -57,6 +66,7 @@ type EndpointOption func(e *Endpoint) error
 func Open(options ...EndpointOption) (*Endpoint, error) {
 	e := &Endpoint{
 		TID:       tracer.NewID(),
+		tracer:    tracer.Default,
 		modules:   make(map[interface{}]Module),
 		tokens:    make(map[cipherset.Token]*Exchange),
 		hashnames: make(map[hashname.H]*Exchange),
@@ -77,7 +87,8 @@ func Open(options ...EndpointOption) (*Endpoint, error) {
 
 	err := e.setOptions(
 		RegisterModule(modTransportsKey, &modTransports{e}),
-		RegisterModule(modNetwatchKey, &modNetwatch{endpoint: e}))
+		RegisterModule(modNetwatchKey, &modNetwatch{endpoint: e}),
+		RegisterModule(modRotationKey, &modRotation{endpoint: e}))
 	if err != nil {
 		return nil, e.traceError(err)
 	}
@@ -94,6 +105,9 @@ func Open(options ...EndpointOption) (*Endpoint, error) {
 		return nil, e.traceError(err)
 	}
 
+	e.metrics = newEndpointMetrics(metrics.WithLabels(e.metricsSink,
+		metrics.Labels{"hashname": string(e.hashname)}))
+
 	e.traceNew()
 
 	err = e.start()
@@ -127,8 +141,8 @@ func (e *Endpoint) DefaultChannelHooks() *ChannelHooks {
 }
 
 func (e *Endpoint) traceError(err error) error {
-	if tracer.Enabled && err != nil {
-		tracer.Emit("endpoint.error", tracer.Info{
+	if e.tracer.Enabled() && err != nil {
+		e.tracer.Emit("endpoint.error", tracer.Info{
 			"endpoint_id": e.TID,
 			"error":       err.Error(),
 		})
@@ -137,8 +151,8 @@ func (e *Endpoint) traceError(err error) error {
 }
 
 func (e *Endpoint) traceNew() {
-	if tracer.Enabled {
-		tracer.Emit("endpoint.new", tracer.Info{
+	if e.tracer.Enabled() {
+		e.tracer.Emit("endpoint.new", tracer.Info{
 			"endpoint_id": e.TID,
 			"hashname":    e.hashname.String(),
 		})
@@ -146,15 +160,23 @@ func (e *Endpoint) traceNew() {
 }
 
 func (e *Endpoint) traceStarted() {
-	if tracer.Enabled {
-		tracer.Emit("endpoint.started", tracer.Info{
+	if e.tracer.Enabled() {
+		e.tracer.Emit("endpoint.started", tracer.Info{
+			"endpoint_id": e.TID,
+		})
+	}
+}
+
+func (e *Endpoint) traceStopped() {
+	if e.tracer.Enabled() {
+		e.tracer.Emit("endpoint.stopped", tracer.Info{
 			"endpoint_id": e.TID,
 		})
 	}
 }
 
 func (e *Endpoint) traceReceivedPacket(msg message) {
-	if tracer.Enabled {
+	if e.tracer.Enabled() {
 		pkt := tracer.Info{
 			"msg": base64.StdEncoding.EncodeToString(msg.Data.Get(nil)),
 		}
@@ -163,7 +185,7 @@ func (e *Endpoint) traceReceivedPacket(msg message) {
 			pkt["src"] = msg.Pipe.raddr.String()
 		}
 
-		tracer.Emit("endpoint.rcv.packet", tracer.Info{
+		e.tracer.Emit("endpoint.rcv.packet", tracer.Info{
 			"endpoint_id": e.TID,
 			"packet_id":   msg.TID,
 			"packet":      pkt,
@@ -172,7 +194,9 @@ func (e *Endpoint) traceReceivedPacket(msg message) {
 }
 
 func (e *Endpoint) traceDroppedPacket(msg []byte, conn net.Conn, reason string) {
-	if tracer.Enabled {
+	e.metrics.dropped(reason)
+
+	if e.tracer.Enabled() {
 		pkt := tracer.Info{
 			"msg": base64.StdEncoding.EncodeToString(msg),
 		}
@@ -182,7 +206,7 @@ func (e *Endpoint) traceDroppedPacket(msg []byte, conn net.Conn, reason string)
 			pkt["dst"] = conn.LocalAddr()
 		}
 
-		tracer.Emit("endpoint.drop.packet", tracer.Info{
+		e.tracer.Emit("endpoint.drop.packet", tracer.Info{
 			"endpoint_id": e.TID,
 			"packet_id":   tracer.NewID(),
 			"reason":      reason,
@@ -218,17 +242,24 @@ func RegisterModule(key interface{}, mod Module) EndpointOption {
 	}
 }
 
+// ErrConflictingKeys is returned by Open when more than one option sets the
+// keys of the endpoint (like Keys and KeyFile) and they don't agree.
+var ErrConflictingKeys = errors.New("e3x: conflicting key options")
+
 func Keys(keys cipherset.Keys) EndpointOption {
 	return func(e *Endpoint) error {
-		if e.keys != nil && len(e.keys) > 0 {
-			return nil
-		}
-
 		hn, err := hashname.FromKeys(keys)
 		if err != nil {
 			return err
 		}
 
+		if e.keys != nil && len(e.keys) > 0 {
+			if hn != e.hashname {
+				return ErrConflictingKeys
+			}
+			return nil
+		}
+
 		e.keys = keys
 		e.hashname = hn
 
@@ -253,13 +284,28 @@ func defaultRandomKeys(e *Endpoint) error {
 	return Keys(keys)(e)
 }
 
+// Log writes the log of the endpoint as human readable lines to w (os.Stderr
+// when w is nil). Colours are used only when w is a terminal.
 func Log(w io.Writer) EndpointOption {
 	if w == nil {
 		w = os.Stderr
 	}
 
+	return setLogger(logs.New(w))
+}
+
+// LogJSON writes the log of the endpoint to w with one JSON object per entry.
+func LogJSON(w io.Writer) EndpointOption {
+	if w == nil {
+		w = os.Stderr
+	}
+
+	return setLogger(logs.NewJSON(w))
+}
+
+func setLogger(l *logs.Logger) EndpointOption {
 	return func(e *Endpoint) error {
-		e.log = logs.New(w).Module("e3x")
+		e.log = l.Module("e3x")
 		if e.hashname != "" {
 			e.log = e.log.From(e.hashname)
 		}
@@ -274,6 +320,16 @@ func DisableLog() EndpointOption {
 	}
 }
 
+// Tracer sets the sink which receives the trace events of the endpoint, its
+// exchanges and their channels. A nil sink disables tracing. By default the
+// events are delivered to tracer.Default.
+func Tracer(sink tracer.Sink) EndpointOption {
+	return func(e *Endpoint) error {
+		e.tracer = tracer.New(sink)
+		return nil
+	}
+}
+
 func Transport(config transports.Config) EndpointOption {
 	return func(e *Endpoint) error {
 		if e.transportConfig != nil {
@@ -298,16 +354,28 @@ func defaultTransport(e *Endpoint) error {
 	})(e)
 }
 
-// Listen makes a new channel listener.
-func (e *Endpoint) Listen(typ string, reliable bool) *Listener {
-	return e.listenerSet.Listen(typ, reliable)
+// Listen makes a new channel listener. The options are applied to all the
+// channels accepted by the listener.
+func (e *Endpoint) Listen(typ string, reliable bool, options ...ChannelOption) *Listener {
+	return e.listenerSet.Listen(typ, reliable, options...)
 }
 
 func (e *Endpoint) LocalHashname() hashname.H {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
 	return e.hashname
 }
 
 func (e *Endpoint) LocalIdentity() (*Identity, error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	return e.localIdentity()
+}
+
+// localIdentity returns the identity of the endpoint. The caller must hold e.mtx.
+func (e *Endpoint) localIdentity() (*Identity, error) {
 	return NewIdentity(e.keys, nil, e.transport.Addrs())
 }
 
@@ -354,6 +422,61 @@ func (e *Endpoint) Close() error {
 	return e.close()
 }
 
+// Shutdown gracefully shuts down the endpoint. First all listeners are closed so
+// no new channels are accepted, then Shutdown ends the open channels (like
+// CloseWrite) and waits for the remote endpoints to acknowledge the buffered
+// packets and the `end` packets. When ctx expires before all channels are
+// drained the remaining channels are killed. Finally the modules are stopped and
+// the transport is closed.
+//
+// When the channels couldn't be drained in time ctx.Err() is returned.
+func (e *Endpoint) Shutdown(ctx context.Context) error {
+	e.listenerSet.Close()
+
+	var (
+		wg        sync.WaitGroup
+		drained   = make(chan struct{})
+		exchanges = make(map[*Exchange]bool)
+	)
+
+	e.mtx.Lock()
+	for _, x := range e.hashnames {
+		exchanges[x] = true
+	}
+	for _, x := range e.tokens {
+		exchanges[x] = true
+	}
+	e.mtx.Unlock()
+
+	for x := range exchanges {
+		for _, c := range x.channels.All() {
+			wg.Add(1)
+			go func(c *Channel) {
+				defer wg.Done()
+				c.drain(ctx)
+			}(c)
+		}
+	}
+
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	var drainErr error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		drainErr = ctx.Err()
+	}
+
+	err := e.Close()
+	if drainErr != nil {
+		return drainErr
+	}
+	return err
+}
+
 func (e *Endpoint) close() error {
 	e.mtx.Unlock()
 
@@ -383,6 +506,8 @@ func (e *Endpoint) close() error {
 		e.state = endpointStateBroken
 	}
 
+	e.traceStopped()
+
 	return e.err
 }
 
@@ -401,19 +526,15 @@ func (e *Endpoint) acceptConnections() {
 }
 
 func (e *Endpoint) accept(conn net.Conn) {
-	var (
-		token cipherset.Token
-		msg   = bufpool.New()
-		err   error
-		n     int
-	)
-	n, err = conn.Read(msg.RawBytes()[:1500])
+	var token cipherset.Token
+
+	msg, err := readMessage(conn, newReadBuffer(conn))
 	if err != nil {
-		msg.Free()
 		conn.Close()
 		return
 	}
-	msg.SetLen(n)
+	e.metrics.bytesIn(conn.RemoteAddr(), msg.Len())
+	e.capture.raw(capture.In, conn.LocalAddr(), conn.RemoteAddr(), msg.RawBytes())
 
 	// msg is either a handshake or a channel packet
 	// when msg is a handshake decrypt it and pass it to the associated exchange
@@ -514,7 +635,7 @@ func (e *Endpoint) accept(conn net.Conn) {
 		return
 	}
 
-	exchange, err = newExchange(localIdent, nil, handshake, e.log, registerEndpoint(e))
+	exchange, err = newExchange(localIdent, nil, handshake, e.log, e.exchangeOptionsWith()...)
 	if err != nil {
 		if e.endpointHooks.DropPacket(msg.Get(nil), conn, err) != ErrStopPropagation {
 			conn.Close()
@@ -527,7 +648,7 @@ func (e *Endpoint) accept(conn net.Conn) {
 	e.hashnames[hn] = exchange
 	e.tokens[exchange.LocalToken()] = exchange
 	e.tokens[exchange.RemoteToken()] = exchange
-	exchange.state = ExchangeDialing
+	exchange.setState(ExchangeDialing)
 	exchange.received(newMessage(msg, newPipe(e.transport, conn, nil, exchange)))
 }
 
@@ -535,12 +656,16 @@ func (e *Endpoint) onExchangeClosed(_ *Endpoint, x *Exchange, reason error) erro
 	e.mtx.Lock()
 	defer e.mtx.Unlock()
 
-	if x.remoteIdent != nil {
-		delete(e.hashnames, x.remoteIdent.Hashname())
+	if ident := x.getRemoteIdent(); ident != nil && e.hashnames[ident.Hashname()] == x {
+		delete(e.hashnames, ident.Hashname())
 	}
 
-	delete(e.tokens, x.LocalToken())
-	delete(e.tokens, x.RemoteToken())
+	if e.tokens[x.LocalToken()] == x {
+		delete(e.tokens, x.LocalToken())
+	}
+	if e.tokens[x.RemoteToken()] == x {
+		delete(e.tokens, x.RemoteToken())
+	}
 
 	return nil
 }
@@ -682,6 +807,12 @@ func (e *Endpoint) Identify(i Identifier) (*Identity, error) {
 // Dial will lookup the identity of identifier, get the exchange for the identity
 // and dial the exchange.
 func (e *Endpoint) Dial(identifier Identifier) (*Exchange, error) {
+	return e.DialContext(context.Background(), identifier)
+}
+
+// DialContext is like Dial but returns ctx.Err() when ctx is done before
+// the exchange is open.
+func (e *Endpoint) DialContext(ctx context.Context, identifier Identifier) (*Exchange, error) {
 	if identifier == nil || e == nil {
 		return nil, os.ErrInvalid
 	}
@@ -702,7 +833,7 @@ func (e *Endpoint) Dial(identifier Identifier) (*Exchange, error) {
 		return nil, err
 	}
 
-	err = x.Dial()
+	err = x.DialContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -749,13 +880,13 @@ func (e *Endpoint) CreateExchange(identity *Identity) (*Exchange, error) {
 	)
 
 	// Get local identity
-	localIdent, err = e.LocalIdentity()
+	localIdent, err = e.localIdentity()
 	if err != nil {
 		return nil, err
 	}
 
 	// Make a new exchange struct
-	x, err = newExchange(localIdent, identity, nil, e.log, registerEndpoint(e))
+	x, err = newExchange(localIdent, identity, nil, e.log, e.exchangeOptionsWith()...)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,384 @@
+package e3x
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/e3x/metrics"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/base32util"
+)
+
+const (
+	modRotationKey = pivateModKey("key-rotation")
+
+	// keyRotationChannel is the channel type used to deliver rotation statements.
+	keyRotationChannel = "key-rotation"
+
+	keyRotationTimeout = 10 * time.Second
+)
+
+var (
+	ErrInvalidKeyRotation = errors.New("e3x: invalid key rotation")
+)
+
+var (
+	_ Module = (*modRotation)(nil)
+)
+
+// KeyRotation is a statement, signed with the old keys of an endpoint, which
+// announces the new keys (and hashname) of that endpoint.
+type KeyRotation struct {
+	From hashname.H     // the old hashname
+	To   hashname.H     // the new hashname
+	Keys cipherset.Keys // the new (public) keys
+	At   time.Time
+
+	statement []byte
+	signature []byte
+}
+
+// keyRotationStatement is the signed body of a key-rotation packet.
+type keyRotationStatement struct {
+	From  hashname.H      `json:"from"`
+	Parts cipherset.Parts `json:"parts"`
+	CSID  string          `json:"csid"`
+	Key   string          `json:"key"`
+	To    hashname.H      `json:"to"`
+	Keys  cipherset.Keys  `json:"keys"`
+	At    int64           `json:"at"`
+}
+
+type modRotation struct {
+	endpoint *Endpoint
+	listener *Listener
+}
+
+func (mod *modRotation) Init() error {
+	mod.listener = mod.endpoint.Listen(keyRotationChannel, true)
+	return nil
+}
+
+func (mod *modRotation) Start() error {
+	go mod.handleRotations()
+	return nil
+}
+
+func (mod *modRotation) Stop() error {
+	mod.listener.Close()
+	return nil
+}
+
+// RotateKeys replaces the keys of the endpoint with keys. A rotation statement
+// signed by one of the old keys (the cipherset must support signatures, like 1a)
+// is delivered to all the connected peers after which the exchanges are migrated
+// to the new keys.
+func (e *Endpoint) RotateKeys(keys cipherset.Keys) (*KeyRotation, error) {
+	var (
+		exchanges []*Exchange
+		wg        sync.WaitGroup
+	)
+
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	to, err := hashname.FromKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mtx.Lock()
+	rotation, err := signKeyRotation(e.keys, keys, to)
+	if err != nil {
+		e.mtx.Unlock()
+		return nil, e.traceError(err)
+	}
+
+	e.keys = keys
+	e.hashname = to
+	if e.log != nil {
+		e.log = e.log.From(e.hashname)
+	}
+	if e.metrics != nil {
+		e.metrics.relabel(metrics.WithLabels(e.metricsSink,
+			metrics.Labels{"hashname": string(e.hashname)}))
+	}
+
+	for _, x := range e.hashnames {
+		exchanges = append(exchanges, x)
+	}
+	e.mtx.Unlock()
+
+	localIdent, err := e.LocalIdentity()
+	if err != nil {
+		return nil, e.traceError(err)
+	}
+
+	for _, x := range exchanges {
+		wg.Add(1)
+		go func(x *Exchange) {
+			defer wg.Done()
+			e.handOver(x, rotation, localIdent)
+		}(x)
+	}
+	wg.Wait()
+
+	return rotation, nil
+}
+
+// handOver delivers the rotation statement to the peer of x and then migrates x
+// to the new local identity.
+func (e *Endpoint) handOver(x *Exchange, rotation *KeyRotation, localIdent *Identity) {
+	if x.State().IsOpen() {
+		c, err := x.Open(keyRotationChannel, true)
+		if err == nil {
+			c.SetDeadline(time.Now().Add(keyRotationTimeout))
+
+			pkt := lob.New(rotation.statement)
+			pkt.Header().SetString("sig", base32util.EncodeToString(rotation.signature))
+
+			err = c.WritePacket(pkt)
+			if err == nil {
+				// wait for the peer to acknowledge the rotation
+				c.ReadPacket()
+			}
+
+			c.Kill()
+		}
+	}
+
+	x.mtx.Lock()
+	remoteIdent := x.remoteIdent
+	x.mtx.Unlock()
+
+	if remoteIdent == nil {
+		return
+	}
+
+	if err := e.migrateExchange(x, localIdent, remoteIdent); err != nil {
+		x.getLog().Warn("failed to migrate exchange to the rotated keys", "error", err)
+	}
+}
+
+// migrateExchange moves x to a new pair of identities (keeping its address book
+// and channels) and registers it with the endpoint under its new tokens.
+func (e *Endpoint) migrateExchange(x *Exchange, localIdent, remoteIdent *Identity) error {
+	e.mtx.Lock()
+	oldHashname := x.RemoteHashname()
+	oldLocalToken := x.LocalToken()
+	oldRemoteToken := x.RemoteToken()
+
+	err := x.rekey(localIdent, remoteIdent)
+	if err != nil {
+		e.mtx.Unlock()
+		return err
+	}
+
+	if e.tokens[oldLocalToken] == x {
+		delete(e.tokens, oldLocalToken)
+	}
+	if e.tokens[oldRemoteToken] == x {
+		delete(e.tokens, oldRemoteToken)
+	}
+	if e.hashnames[oldHashname] == x {
+		delete(e.hashnames, oldHashname)
+	}
+	e.tokens[x.LocalToken()] = x
+	e.hashnames[remoteIdent.Hashname()] = x
+	e.mtx.Unlock()
+
+	go func() {
+		if x.Dial() != nil {
+			return
+		}
+
+		e.mtx.Lock()
+		if e.hashnames[remoteIdent.Hashname()] == x {
+			e.tokens[x.RemoteToken()] = x
+		}
+		e.mtx.Unlock()
+	}()
+
+	return nil
+}
+
+func (mod *modRotation) handleRotations() {
+	for {
+		c, err := mod.listener.AcceptChannel()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			continue
+		}
+
+		go mod.handleRotation(c)
+	}
+}
+
+func (mod *modRotation) handleRotation(c *Channel) {
+	var (
+		e = mod.endpoint
+		x = c.Exchange()
+	)
+
+	defer c.Kill()
+
+	c.SetDeadline(time.Now().Add(keyRotationTimeout))
+
+	pkt, err := c.ReadPacket()
+	if err != nil {
+		return
+	}
+
+	rotation, err := decodeKeyRotation(pkt)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if rotation.From != x.RemoteHashname() {
+		c.Error(ErrInvalidKeyRotation)
+		return
+	}
+
+	x.mtx.Lock()
+	localIdent := x.localIdent
+	x.mtx.Unlock()
+
+	remoteIdent, err := NewIdentity(rotation.Keys, nil, nil)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// acknowledge the rotation before the old session is dropped
+	ack := &lob.Packet{}
+	ack.Header().SetBool("ok", true)
+	c.WritePacket(ack)
+
+	err = e.migrateExchange(x, localIdent, remoteIdent)
+	if err != nil {
+		return
+	}
+
+	x.exchangeHooks.Rotated(rotation)
+}
+
+// signKeyRotation makes a rotation statement from the old keys to the new keys.
+// The statement is signed with the highest CSID old key that supports signatures.
+func signKeyRotation(from, to cipherset.Keys, toHashname hashname.H) (*KeyRotation, error) {
+	var (
+		csids []int
+	)
+
+	for csid := range from {
+		csids = append(csids, int(csid))
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(csids)))
+
+	fromHashname, err := hashname.FromKeys(from)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, csid := range csids {
+		key := from[uint8(csid)]
+		if _, ok := key.(cipherset.Signer); !ok || !key.CanSign() {
+			continue
+		}
+
+		stmt := keyRotationStatement{
+			From:  fromHashname,
+			Parts: hashname.PartsFromKeys(from),
+			CSID:  hex.EncodeToString([]byte{uint8(csid)}),
+			Key:   key.String(),
+			To:    toHashname,
+			Keys:  to,
+			At:    time.Now().Unix(),
+		}
+
+		data, err := json.Marshal(&stmt)
+		if err != nil {
+			return nil, err
+		}
+
+		sig, err := cipherset.Sign(key, data)
+		if err != nil {
+			return nil, err
+		}
+
+		return &KeyRotation{
+			From:      stmt.From,
+			To:        stmt.To,
+			Keys:      to,
+			At:        time.Unix(stmt.At, 0),
+			statement: data,
+			signature: sig,
+		}, nil
+	}
+
+	return nil, cipherset.ErrCannotSign
+}
+
+// decodeKeyRotation decodes and verifies a rotation statement.
+func decodeKeyRotation(pkt *lob.Packet) (*KeyRotation, error) {
+	var (
+		stmt keyRotationStatement
+	)
+
+	sigStr, _ := pkt.Header().GetString("sig")
+	sig, err := base32util.DecodeString(sigStr)
+	if err != nil || len(sig) == 0 {
+		return nil, ErrInvalidKeyRotation
+	}
+
+	data := pkt.Body(nil)
+	err = json.Unmarshal(data, &stmt)
+	if err != nil {
+		return nil, ErrInvalidKeyRotation
+	}
+
+	csid, err := hex.DecodeString(stmt.CSID)
+	if err != nil || len(csid) != 1 {
+		return nil, ErrInvalidKeyRotation
+	}
+
+	key, err := cipherset.DecodeKey(csid[0], stmt.Key, "")
+	if err != nil {
+		return nil, ErrInvalidKeyRotation
+	}
+
+	// the signing key must belong to the old hashname
+	from, err := hashname.FromKeyAndIntermediates(csid[0], key.Public(), stmt.Parts)
+	if err != nil || from != stmt.From {
+		return nil, ErrInvalidKeyRotation
+	}
+
+	to, err := hashname.FromKeys(stmt.Keys)
+	if err != nil || to != stmt.To || to == from {
+		return nil, ErrInvalidKeyRotation
+	}
+
+	err = cipherset.Verify(key, data, sig)
+	if err != nil {
+		return nil, ErrInvalidKeyRotation
+	}
+
+	return &KeyRotation{
+		From:      stmt.From,
+		To:        stmt.To,
+		Keys:      stmt.Keys,
+		At:        time.Unix(stmt.At, 0),
+		statement: data,
+		signature: sig,
+	}, nil
+}
@@ -0,0 +1,316 @@
+package e3x
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/base32util"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestKeyRotation(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	ka, err := cipherset.GenerateKeys(0x1a, 0x3a)
+	if !assert.NoError(err) {
+		return
+	}
+
+	kb, err := cipherset.GenerateKeys(0x1a, 0x3a)
+	if !assert.NoError(err) {
+		return
+	}
+
+	A, err := Open(Keys(ka), Transport(inproc.Config{}), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Keys(kb), Transport(inproc.Config{}), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	rotated := make(chan *KeyRotation, 1)
+	B.DefaultExchangeHooks().Register(ExchangeHook{
+		OnRotated: func(e *Endpoint, x *Exchange, r *KeyRotation) error {
+			rotated <- r
+			return nil
+		},
+	})
+
+	go func() {
+		l := A.Listen("ping", true)
+		for {
+			c, err := l.AcceptChannel()
+			if err != nil {
+				return
+			}
+
+			c.SetDeadline(time.Now().Add(10 * time.Second))
+			pkt, err := c.ReadPacket()
+			if err == nil && string(pkt.Body(nil)) == "ping" {
+				c.WritePacket(lob.New([]byte("pong")))
+			}
+			c.Close()
+		}
+	}()
+
+	ping := func(ident *Identity) {
+		c, err := B.Open(ident, "ping", true)
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Close()
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+
+		assert.NoError(c.WritePacket(lob.New([]byte("ping"))))
+		pkt, err := c.ReadPacket()
+		if assert.NoError(err) {
+			assert.Equal("pong", string(pkt.Body(nil)))
+		}
+	}
+
+	identA, err := A.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+	oldHashname := A.LocalHashname()
+
+	ping(identA)
+
+	newKeys, err := cipherset.GenerateKeys(0x1a, 0x3a)
+	if !assert.NoError(err) {
+		return
+	}
+
+	rotation, err := A.RotateKeys(newKeys)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(oldHashname, rotation.From)
+	assert.Equal(A.LocalHashname(), rotation.To)
+	assert.NotEqual(oldHashname, rotation.To)
+
+	select {
+	case r := <-rotated:
+		assert.Equal(rotation.From, r.From)
+		assert.Equal(rotation.To, r.To)
+	case <-time.After(10 * time.Second):
+		t.Fatal("rotation was not received")
+	}
+
+	x := B.GetExchange(rotation.To)
+	if assert.NotNil(x) {
+		assert.NoError(x.Dial())
+		assert.Equal(rotation.To, x.RemoteHashname())
+		assert.NotEmpty(x.KnownPaths())
+	}
+	assert.Nil(B.GetExchange(oldHashname))
+
+	identA, err = A.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	ping(identA)
+}
+
+func TestKeyRotationInvalidSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	from, err := cipherset.GenerateKeys(0x1a)
+	if !assert.NoError(err) {
+		return
+	}
+
+	to, err := cipherset.GenerateKeys(0x1a)
+	if !assert.NoError(err) {
+		return
+	}
+
+	other, err := cipherset.GenerateKeys(0x1a)
+	if !assert.NoError(err) {
+		return
+	}
+
+	toHashname, err := hashname.FromKeys(to)
+	if !assert.NoError(err) {
+		return
+	}
+
+	rotation, err := signKeyRotation(from, to, toHashname)
+	if !assert.NoError(err) {
+		return
+	}
+
+	forged, err := signKeyRotation(other, to, toHashname)
+	if !assert.NoError(err) {
+		return
+	}
+
+	pkt := lob.New(rotation.statement)
+	pkt.Header().SetString("sig", base32util.EncodeToString(rotation.signature))
+	r, err := decodeKeyRotation(pkt)
+	if assert.NoError(err) {
+		assert.Equal(rotation.From, r.From)
+		assert.Equal(rotation.To, r.To)
+	}
+
+	pkt = lob.New(rotation.statement)
+	pkt.Header().SetString("sig", base32util.EncodeToString(forged.signature))
+	_, err = decodeKeyRotation(pkt)
+	assert.Equal(ErrInvalidKeyRotation, err)
+
+	noSign, err := cipherset.GenerateKeys(0x3a)
+	if !assert.NoError(err) {
+		return
+	}
+	_, err = signKeyRotation(noSign, to, toHashname)
+	assert.Equal(cipherset.ErrCannotSign, err)
+}
+
+func TestKeyRotationUnderTraffic(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	ka, err := cipherset.GenerateKeys(0x1a, 0x3a)
+	if !assert.NoError(err) {
+		return
+	}
+
+	kb, err := cipherset.GenerateKeys(0x1a, 0x3a)
+	if !assert.NoError(err) {
+		return
+	}
+
+	A, err := Open(Keys(ka), Transport(inproc.Config{}), DisableLog())
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Keys(kb), Transport(inproc.Config{}), DisableLog())
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	const n = 3000
+
+	var (
+		accepted = make(chan *Channel, 1)
+		received = make(chan int, n)
+	)
+	go func() {
+		defer close(received)
+
+		c, err := A.Listen("stream", true).AcceptChannel()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		defer c.Close()
+		accepted <- c
+
+		c.SetDeadline(time.Now().Add(30 * time.Second))
+
+		// respond to the initial packet before reading the stream
+		if _, err := c.ReadPacket(); err != nil {
+			return
+		}
+		if err := c.WritePacket(lob.New(nil)); err != nil {
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			pkt, err := c.ReadPacket()
+			if err != nil {
+				return
+			}
+			v, _ := pkt.Header().GetInt("i")
+			received <- v
+		}
+	}()
+
+	identA, err := A.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := B.Open(identA, "stream", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(30 * time.Second))
+
+	assert.NoError(c.WritePacket(lob.New(nil)))
+	_, err = c.ReadPacket()
+	if !assert.NoError(err) {
+		return
+	}
+
+	cA := <-accepted
+	if !assert.NotNil(cA) {
+		return
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			pkt := lob.New([]byte("data"))
+			pkt.Header().SetInt("i", i)
+			if err := c.WritePacket(pkt); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	}()
+
+	newKeys, err := cipherset.GenerateKeys(0x1a, 0x3a)
+	if !assert.NoError(err) {
+		return
+	}
+	from := A.LocalHashname()
+	to, err := hashname.FromKeys(newKeys)
+	if !assert.NoError(err) {
+		return
+	}
+
+	rotated := make(chan error, 1)
+	for i := 0; i < n; i++ {
+		v, ok := <-received
+		if !assert.True(ok, "channel broke after %d packets", i) {
+			return
+		}
+		assert.Equal(i, v)
+		assert.Contains([]net.Addr{from, to}, cA.LocalAddr())
+
+		if i == n/10 {
+			// rotate while the channel keeps carrying packets
+			go func() {
+				_, err := A.RotateKeys(newKeys)
+				rotated <- err
+			}()
+		}
+	}
+
+	assert.NoError(<-sent)
+	assert.NoError(<-rotated)
+	assert.Equal(to, cA.LocalAddr())
+}
@@ -1,12 +1,16 @@
 package e3x
 
 import (
+	"context"
+	"io"
 	"testing"
 	"time"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
 
 	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/e3x/tracer"
+	"github.com/telehash/gogotelehash/internal/lob"
 	"github.com/telehash/gogotelehash/internal/util/logs"
 	"github.com/telehash/gogotelehash/transports/inproc"
 	"github.com/telehash/gogotelehash/transports/mux"
@@ -66,3 +70,194 @@ func TestSimpleEndpoint(t *testing.T) {
 	err = eb.Close()
 	assert.NoError(err)
 }
+
+func TestShutdownDrainsChannels(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+
+	received := make(chan int, 1)
+	go func() {
+		c, err := A.Listen("drain", true).AcceptChannel()
+		if err != nil {
+			received <- -1
+			return
+		}
+
+		n := 0
+		for {
+			_, err := c.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				// the channel broke before it was ended
+				n = -1
+				break
+			}
+			if n == 0 {
+				c.WritePacket(lob.New([]byte("ok")))
+			}
+			n++
+		}
+		received <- n
+		c.Close()
+	}()
+
+	identA, err := A.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := B.Open(identA, "drain", true)
+	if !assert.NoError(err) {
+		return
+	}
+
+	for i := 0; i < 50; i++ {
+		assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+	}
+
+	// Shutdown ends the channel
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	assert.NoError(B.Shutdown(ctx))
+
+	select {
+	case n := <-received:
+		assert.Equal(50, n)
+	case <-time.After(10 * time.Second):
+		t.Fatal("channel was not drained")
+	}
+
+	_, err = B.Listen("late", true).AcceptChannel()
+	assert.Equal(io.EOF, err)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(Transport(inproc.Config{}), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+
+	// A accepts the channel but never reads from it.
+	A.Listen("stuck", true)
+
+	identA, err := A.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := B.Open(identA, "stuck", true)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(context.DeadlineExceeded, B.Shutdown(ctx))
+
+	_, err = c.ReadPacket()
+	assert.Error(err)
+}
+
+func TestEndpointTracer(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+	ring := tracer.NewRing(1024)
+
+	A, err := Open(Transport(inproc.Config{}), Tracer(ring), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Transport(inproc.Config{}), Tracer(nil), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	identB, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		c, err := B.Listen("ping", false).AcceptChannel()
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Kill()
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		pkt, err := c.ReadPacket()
+		if assert.NoError(err) {
+			assert.NoError(c.WritePacket(pkt))
+		}
+	}()
+
+	c, err := A.Open(identB, "ping", false)
+	if !assert.NoError(err) {
+		return
+	}
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	assert.NoError(c.WritePacket(lob.New([]byte("ping"))))
+	pkt, err := c.ReadPacket()
+	if assert.NoError(err) {
+		pkt.Free()
+	}
+	<-served
+	c.Kill()
+	A.Close()
+
+	// all the events of A are delivered to its own sink and linked by their IDs
+	for _, e := range ring.Events() {
+		if id, ok := e.Info["endpoint_id"]; ok {
+			assert.Equal(A.TID, id, e.Type)
+		}
+		if id, ok := e.Info["exchange_id"]; ok && e.Type != "channel.new" {
+			assert.Equal(c.Exchange().TID, id, e.Type)
+		}
+	}
+
+	assert.Equal(1, len(ring.Filter("endpoint.new")))
+	assert.Equal(1, len(ring.Filter("endpoint.stopped")))
+	if news := ring.Filter("exchange.new"); assert.Equal(1, len(news)) {
+		assert.Equal(A.TID, news[0].Info["endpoint_id"])
+	}
+	if news := ring.Filter("channel.new"); assert.Equal(1, len(news)) {
+		assert.Equal(c.TID, news[0].Info["channel_id"])
+		assert.Equal(c.Exchange().TID, news[0].Info["exchange_id"])
+	}
+	if writes := ring.Filter("channel.write"); assert.Equal(1, len(writes)) {
+		assert.Equal(c.TID, writes[0].Info["channel_id"])
+	}
+}
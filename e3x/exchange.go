@@ -1,6 +1,7 @@
 package e3x
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -9,12 +10,13 @@ import (
 	"sync"
 	"time"
 
+	"github.com/telehash/gogotelehash/e3x/capture"
 	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/e3x/tracer"
 	"github.com/telehash/gogotelehash/internal/hashname"
 	"github.com/telehash/gogotelehash/internal/lob"
 	"github.com/telehash/gogotelehash/internal/util/bufpool"
 	"github.com/telehash/gogotelehash/internal/util/logs"
-	"github.com/telehash/gogotelehash/internal/util/tracer"
 	"github.com/telehash/gogotelehash/transports"
 )
 
@@ -79,20 +81,33 @@ type Exchange struct {
 	remoteIdent   *Identity
 	csid          uint8
 	cipher        cipherset.State
+	identMtx      sync.RWMutex // held (with mtx) while remoteIdent, cipher or log are replaced
 	nextChannelID uint32
 	channels      *channelSet
 	addressBook   *addressBook
 	err           error
 
-	endpoint      endpointI
-	listenerSet   *listenerSet
-	log           *logs.Logger
-	exchangeHooks ExchangeHooks
-	channelHooks  ChannelHooks
-
-	nextHandshake     int
+	endpoint       endpointI
+	listenerSet    *listenerSet
+	log            *logs.Logger
+	exchangeHooks  ExchangeHooks
+	channelHooks   ChannelHooks
+	channelOptions []ChannelOption
+	cfg            exchangeConfig
+	scheduler      *sendScheduler
+	metrics        *endpointMetrics
+	tracer         *tracer.Tracer
+	capture        *endpointCapture
+
+	probeMtx  sync.Mutex
+	probeAcks map[uint32]chan int
+
+	nextHandshake     time.Duration
+	unreachable       bool
+	awaitingResponse  bool
 	tExpire           *time.Timer
 	tBreak            *time.Timer
+	tUnreachable      *time.Timer
 	tDeliverHandshake *time.Timer
 }
 
@@ -113,18 +128,28 @@ func newExchange(
 		localIdent:  localIdent,
 		remoteIdent: remoteIdent,
 		channels:    &channelSet{},
+		cfg:         defaultExchangeConfig,
+		metrics:     discardMetrics,
+		tracer:      tracer.Default,
 	}
-	x.traceNew()
 
 	x.cndState = sync.NewCond(&x.mtx)
 
-	x.tBreak = time.AfterFunc(2*60*time.Second, x.onBreak)
+	err := x.setOptions(options...)
+	if err != nil {
+		return nil, x.traceError(err)
+	}
+
+	x.traceNew()
+
+	x.tBreak = time.AfterFunc(x.cfg.breakTimeout, x.onBreak)
 	x.tExpire = time.AfterFunc(60*time.Second, x.onExpire)
+	x.tUnreachable = time.AfterFunc(x.cfg.unreachableTimeout, x.onUnreachable)
+	x.tUnreachable.Stop()
 	x.tDeliverHandshake = time.AfterFunc(60*time.Second, x.onDeliverHandshake)
 	x.resetExpire()
 	x.rescheduleHandshake()
 
-	x.setOptions(options...)
 	x.channelHooks.Register(ChannelHook{OnClosed: x.unregisterChannel})
 
 	if localIdent == nil {
@@ -145,7 +170,7 @@ func newExchange(
 			return nil, x.traceError(err)
 		}
 
-		x.addressBook = newAddressBook(x.log)
+		x.addressBook = newAddressBook(x.log, x.metrics)
 		x.cipher = cipher
 		x.csid = csid
 
@@ -175,9 +200,12 @@ func newExchange(
 		x.log = log.To(hn)
 		x.cipher = cipher
 		x.csid = csid
-		x.addressBook = newAddressBook(x.log)
+		x.addressBook = newAddressBook(x.log, x.metrics)
 	}
 
+	x.scheduler = newSendScheduler(x.cfg.sendQueueSize)
+	go x.scheduler.run()
+
 	return x, nil
 }
 
@@ -196,12 +224,35 @@ func registerEndpoint(e *Endpoint) ExchangeOption {
 		x.listenerSet = e.listenerSet.Inherit()
 		x.exchangeHooks = e.exchangeHooks
 		x.channelHooks = e.channelHooks
+		x.channelOptions = e.channelOptions
+		x.metrics = e.metrics
+		x.tracer = e.tracer
+		x.capture = e.capture
 		x.exchangeHooks.exchange = x
 		x.channelHooks.exchange = x
 		return nil
 	}
 }
 
+// exchangeOptionsWith returns the options of a new exchange; the endpoint
+// registration followed by the endpoint defaults.
+func (e *Endpoint) exchangeOptionsWith() []ExchangeOption {
+	all := make([]ExchangeOption, 0, 1+len(e.exchangeOptions))
+	all = append(all, registerEndpoint(e))
+	all = append(all, e.exchangeOptions...)
+	return all
+}
+
+// channelOptionsWith returns the options of a new channel; the endpoint defaults
+// followed by the exchange registration and the specific options.
+func (x *Exchange) channelOptionsWith(register ChannelOption, options []ChannelOption) []ChannelOption {
+	all := make([]ChannelOption, 0, len(x.channelOptions)+1+len(options))
+	all = append(all, x.channelOptions...)
+	all = append(all, register)
+	all = append(all, options...)
+	return all
+}
+
 func (x *Exchange) State() ExchangeState {
 	x.mtx.Lock()
 	s := x.state
@@ -209,8 +260,17 @@ func (x *Exchange) State() ExchangeState {
 	return s
 }
 
+// Reachable returns false when the peer did not respond to the last keepalive
+// handshake within the unreachable timeout.
+func (x *Exchange) Reachable() bool {
+	x.mtx.Lock()
+	r := !x.unreachable
+	x.mtx.Unlock()
+	return r
+}
+
 func (x *Exchange) String() string {
-	return fmt.Sprintf("<Exchange %s state=%s>", x.remoteIdent.Hashname(), x.State())
+	return fmt.Sprintf("<Exchange %s state=%s>", x.RemoteHashname(), x.State())
 }
 
 func (x *Exchange) getTID() tracer.ID {
@@ -218,8 +278,8 @@ func (x *Exchange) getTID() tracer.ID {
 }
 
 func (x *Exchange) traceError(err error) error {
-	if tracer.Enabled && err != nil {
-		tracer.Emit("exchange.error", tracer.Info{
+	if x.tracer.Enabled() && err != nil {
+		x.tracer.Emit("exchange.error", tracer.Info{
 			"exchange_id": x.TID,
 			"error":       err.Error(),
 		})
@@ -228,33 +288,40 @@ func (x *Exchange) traceError(err error) error {
 }
 
 func (x *Exchange) traceNew() {
-	if tracer.Enabled {
-		tracer.Emit("exchange.new", tracer.Info{
+	if x.tracer.Enabled() {
+		info := tracer.Info{
 			"exchange_id": x.TID,
-			"endpoint_id": x.endpoint.getTID(),
-		})
+		}
+
+		if x.endpoint != nil {
+			info["endpoint_id"] = x.endpoint.getTID()
+		}
+
+		x.tracer.Emit("exchange.new", info)
 	}
 }
 
 func (x *Exchange) traceStarted() {
-	if tracer.Enabled {
-		tracer.Emit("exchange.started", tracer.Info{
+	if x.tracer.Enabled() {
+		x.tracer.Emit("exchange.started", tracer.Info{
 			"exchange_id": x.TID,
-			"peer":        x.remoteIdent.Hashname().String(),
+			"peer":        x.RemoteHashname().String(),
 		})
 	}
 }
 
 func (x *Exchange) traceStopped() {
-	if tracer.Enabled {
-		tracer.Emit("exchange.stopped", tracer.Info{
+	if x.tracer.Enabled() {
+		x.tracer.Emit("exchange.stopped", tracer.Info{
 			"exchange_id": x.TID,
 		})
 	}
 }
 
 func (x *Exchange) traceDroppedHandshake(msg message, handshake cipherset.Handshake, reason string) {
-	if tracer.Enabled {
+	x.metrics.dropped(reason)
+
+	if x.tracer.Enabled() {
 		info := tracer.Info{
 			"exchange_id": x.TID,
 			"packet_id":   msg.TID,
@@ -270,13 +337,13 @@ func (x *Exchange) traceDroppedHandshake(msg message, handshake cipherset.Handsh
 			}
 		}
 
-		tracer.Emit("exchange.drop.handshake", info)
+		x.tracer.Emit("exchange.drop.handshake", info)
 	}
 }
 
 func (x *Exchange) traceReceivedHandshake(msg message, handshake cipherset.Handshake) {
-	if tracer.Enabled {
-		tracer.Emit("exchange.rcv.handshake", tracer.Info{
+	if x.tracer.Enabled() {
+		x.tracer.Emit("exchange.rcv.handshake", tracer.Info{
 			"exchange_id": x.TID,
 			"packet_id":   msg.TID,
 			"handshake": tracer.Info{
@@ -290,7 +357,9 @@ func (x *Exchange) traceReceivedHandshake(msg message, handshake cipherset.Hands
 }
 
 func (x *Exchange) traceDroppedPacket(msg message, pkt *lob.Packet, reason string) {
-	if tracer.Enabled {
+	x.metrics.dropped(reason)
+
+	if x.tracer.Enabled() {
 		info := tracer.Info{
 			"exchange_id": x.TID,
 			"packet_id":   msg.TID,
@@ -299,22 +368,22 @@ func (x *Exchange) traceDroppedPacket(msg message, pkt *lob.Packet, reason strin
 
 		if pkt != nil {
 			info["packet"] = tracer.Info{
-				"header": pkt.Header(),
+				"header": *pkt.Header(),
 				"body":   base64.StdEncoding.EncodeToString(pkt.Body(nil)),
 			}
 		}
 
-		tracer.Emit("exchange.rcv.packet", info)
+		x.tracer.Emit("exchange.rcv.packet", info)
 	}
 }
 
 func (x *Exchange) traceReceivedPacket(msg message, pkt *lob.Packet) {
-	if tracer.Enabled {
-		tracer.Emit("exchange.rcv.packet", tracer.Info{
+	if x.tracer.Enabled() {
+		x.tracer.Emit("exchange.rcv.packet", tracer.Info{
 			"exchange_id": x.TID,
 			"packet_id":   msg.TID,
 			"packet": tracer.Info{
-				"header": pkt.Header(),
+				"header": *pkt.Header(),
 				"body":   base64.StdEncoding.EncodeToString(pkt.Body(nil)),
 			},
 		})
@@ -323,16 +392,28 @@ func (x *Exchange) traceReceivedPacket(msg message, pkt *lob.Packet) {
 
 // Dial exchanges the initial handshakes. It will timeout after 2 minutes.
 func (x *Exchange) Dial() error {
+	return x.DialContext(context.Background())
+}
+
+// DialContext is like Dial but returns ctx.Err() when ctx is done before
+// the handshakes completed.
+func (x *Exchange) DialContext(ctx context.Context) error {
 	x.mtx.Lock()
 	defer x.mtx.Unlock()
 
+	stop := wakeOnDone(ctx, &x.mtx, x.cndState)
+	defer stop()
+
 	if x.state == 0 {
-		x.state = ExchangeDialing
+		x.setState(ExchangeDialing)
 		x.deliverHandshake()
 		x.rescheduleHandshake()
 	}
 
 	for x.state == ExchangeDialing {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		x.cndState.Wait()
 	}
 
@@ -345,7 +426,9 @@ func (x *Exchange) Dial() error {
 
 // RemoteHashname returns the hashname of the remote peer.
 func (x *Exchange) RemoteHashname() hashname.H {
+	x.identMtx.RLock()
 	hn := x.remoteIdent.Hashname()
+	x.identMtx.RUnlock()
 	return hn
 }
 
@@ -377,6 +460,14 @@ func (x *Exchange) KnownPipes() []*Pipe {
 	return x.addressBook.KnownPipes()
 }
 
+func (x *Exchange) getMetrics() *endpointMetrics {
+	return x.metrics
+}
+
+func (x *Exchange) getCapture() *endpointCapture {
+	return x.capture
+}
+
 func (x *Exchange) dialDialerAddr(addr dialerAddr) (net.Conn, error) {
 	return addr.Dial(x.endpoint.(*Endpoint), x)
 }
@@ -397,6 +488,12 @@ func (x *Exchange) onDeliverHandshake() {
 
 	x.rescheduleHandshake()
 	x.deliverHandshake()
+
+	if x.state.IsOpen() && !x.unreachable {
+		// the peer must respond before the unreachable timeout; the timer is
+		// stopped by resetBreak.
+		x.armUnreachable()
+	}
 }
 
 func (x *Exchange) deliverHandshake() error {
@@ -416,6 +513,9 @@ func (x *Exchange) deliverHandshake() error {
 		_, err := pipe.Write(pktData)
 		if err == nil {
 			x.addressBook.SentHandshake(pipe)
+			x.metrics.handshakesSent.Add(1)
+		} else if err == transports.ErrInvalidAddr {
+			x.addressBook.UnsupportedPipe(pipe)
 		}
 	}
 
@@ -424,21 +524,20 @@ func (x *Exchange) deliverHandshake() error {
 
 func (x *Exchange) rescheduleHandshake() {
 	if x.nextHandshake <= 0 {
-		x.nextHandshake = 4
+		x.nextHandshake = 4 * time.Second
 	} else {
 		x.nextHandshake = x.nextHandshake * 2
 	}
 
-	if x.nextHandshake > 60 {
-		x.nextHandshake = 60
+	if x.nextHandshake > x.cfg.keepAlive {
+		x.nextHandshake = x.cfg.keepAlive
 	}
 
-	if n := x.nextHandshake / 3; n > 0 {
-		x.nextHandshake -= rand.Intn(n)
+	if n := int64(x.nextHandshake / 3); n > 0 {
+		x.nextHandshake -= time.Duration(rand.Int63n(n))
 	}
 
-	var d = time.Duration(x.nextHandshake) * time.Second
-	x.tDeliverHandshake.Reset(d)
+	x.tDeliverHandshake.Reset(x.nextHandshake)
 }
 
 func (x *Exchange) receivedPacket(msg message) {
@@ -469,7 +568,7 @@ func (x *Exchange) receivedPacket(msg message) {
 		return // drop
 	}
 
-	pkt2, err := x.cipher.DecryptPacket(pkt)
+	pkt2, err := x.getCipher().DecryptPacket(pkt)
 	pkt.Free()
 	if err != nil {
 		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
@@ -485,6 +584,13 @@ func (x *Exchange) receivedPacket(msg message) {
 		c            *Channel
 	)
 
+	if !hasC && x.receivedProbe(msg, pkt2) {
+		pkt2.Free()
+		return
+	}
+
+	x.capture.packet(capture.In, msg.Pipe, pkt2)
+
 	if !hasC {
 		// drop: missing "c"
 		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
@@ -511,14 +617,21 @@ func (x *Exchange) receivedPacket(msg message) {
 				return // drop (no handler)
 			}
 
-			c = newChannel(
-				x.remoteIdent.Hashname(),
+			var err error
+			c, err = newChannel(
+				x.RemoteHashname(),
 				typ,
 				hasSeq,
 				true,
 				x,
-				registerExchange(x),
+				x.channelOptionsWith(registerExchange(x), listener.channelOptions)...,
 			)
+			if err != nil {
+				addPromise.Cancel()
+				x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, err)
+				x.traceDroppedPacket(msg, pkt2, err.Error())
+				return // drop (invalid channel options)
+			}
 			c.id = cid
 			addPromise.Add(c)
 
@@ -526,7 +639,7 @@ func (x *Exchange) receivedPacket(msg message) {
 			x.resetExpire()
 			x.mtx.Unlock()
 
-			x.log.Printf("\x1B[32mOpened channel\x1B[0m %q %d", typ, cid)
+			x.getLog().Info("opened channel", "type", typ, "id", cid)
 			c.channelHooks.Opened()
 
 			listener.handle(c)
@@ -537,35 +650,73 @@ func (x *Exchange) receivedPacket(msg message) {
 	c.receivedPacket(pkt2)
 }
 
-func (x *Exchange) deliverPacket(pkt *lob.Packet, p *Pipe) error {
+// deliverPacket encrypts pkt and queues it with the send scheduler. Packets of
+// a higher priority are sent first.
+func (x *Exchange) deliverPacket(pkt *lob.Packet, p *Pipe, priority int) error {
 	x.mtx.Lock()
 	for x.state == ExchangeDialing {
 		x.cndState.Wait()
 	}
 	if !x.state.IsOpen() {
+		x.mtx.Unlock()
+		return BrokenExchangeError(x.remoteIdent.Hashname())
+	}
+	cipher := x.cipher
+	x.mtx.Unlock()
+
+	msg, p, err := x.sealPacket(cipher, pkt, p)
+	if err != nil {
+		return err
+	}
+
+	return x.scheduler.push(msg, p, priority)
+}
+
+// tryDeliverPacket is like deliverPacket but it never blocks. pkt is dropped when
+// the exchange is not open (yet) or when the send queue is full. It is used for
+// the acks and retransmissions of channels which are sent from the receive path.
+func (x *Exchange) tryDeliverPacket(pkt *lob.Packet, p *Pipe, priority int) error {
+	x.mtx.Lock()
+	if x.state == ExchangeDialing {
+		x.mtx.Unlock()
+		return errWouldBlock
+	}
+	if !x.state.IsOpen() {
+		x.mtx.Unlock()
 		return BrokenExchangeError(x.remoteIdent.Hashname())
 	}
+	cipher := x.cipher
 	x.mtx.Unlock()
 
+	msg, p, err := x.sealPacket(cipher, pkt, p)
+	if err != nil {
+		return err
+	}
+
+	return x.scheduler.tryPush(msg, p, priority)
+}
+
+// sealPacket encrypts and encodes pkt for delivery over p (or the active path
+// when p is nil).
+func (x *Exchange) sealPacket(cipher cipherset.State, pkt *lob.Packet, p *Pipe) (*bufpool.Buffer, *Pipe, error) {
 	if p == nil {
 		p = x.addressBook.ActiveConnection()
 	}
 
-	pkt2, err := x.cipher.EncryptPacket(pkt)
+	x.capture.packet(capture.Out, p, pkt)
+
+	pkt2, err := cipher.EncryptPacket(pkt)
 	if err != nil {
-		return err
+		return nil, p, err
 	}
 
 	msg, err := lob.Encode(pkt2)
 	pkt2.Free()
 	if err != nil {
-		return err
+		return nil, p, err
 	}
 
-	_, err = p.Write(msg)
-	msg.Free()
-
-	return err
+	return msg, p, nil
 }
 
 func (x *Exchange) expire(err error) {
@@ -576,21 +727,24 @@ func (x *Exchange) expire(err error) {
 	}
 
 	if err == nil {
-		x.state = ExchangeExpired
+		x.setState(ExchangeExpired)
 	} else {
 		if x.err != nil {
 			x.err = err
 		}
-		x.state = ExchangeBroken
+		x.setState(ExchangeBroken)
 	}
 	x.cndState.Broadcast()
 
 	x.tBreak.Stop()
 	x.tExpire.Stop()
+	x.tUnreachable.Stop()
 	x.tDeliverHandshake.Stop()
 
 	x.mtx.Unlock()
 
+	x.scheduler.close()
+
 	for _, c := range x.channels.All() {
 		c.onCloseDeadlineReached()
 	}
@@ -603,6 +757,12 @@ func (x *Exchange) expire(err error) {
 	x.exchangeHooks.Closed(err)
 }
 
+// setState changes the state of the exchange. The caller must hold x.mtx.
+func (x *Exchange) setState(state ExchangeState) {
+	x.metrics.exchangeState(x.state, state)
+	x.state = state
+}
+
 func (x *Exchange) getNextSeq() uint32 {
 	seq := x.nextSeq
 	if n := uint32(time.Now().Unix()); seq < n {
@@ -654,7 +814,7 @@ func (x *Exchange) onBreak() {
 	if x == nil {
 		return
 	}
-	x.expire(BrokenExchangeError(x.remoteIdent.Hashname()))
+	x.expire(BrokenExchangeError(x.RemoteHashname()))
 }
 
 func (x *Exchange) resetExpire() {
@@ -664,16 +824,16 @@ func (x *Exchange) resetExpire() {
 		x.tExpire.Stop()
 	} else {
 		if x.state.IsOpen() {
-			x.tExpire.Reset(2 * 60 * time.Second)
+			x.tExpire.Reset(x.cfg.idleTimeout)
 		}
 	}
 
 	if x.state.IsOpen() {
 		old := x.state
 		if active {
-			x.state = ExchangeActive
+			x.setState(ExchangeActive)
 		} else {
-			x.state = ExchangeIdle
+			x.setState(ExchangeIdle)
 		}
 		if x.state != old {
 			x.cndState.Broadcast()
@@ -681,20 +841,63 @@ func (x *Exchange) resetExpire() {
 	}
 }
 
+func (x *Exchange) onUnreachable() {
+	if x == nil {
+		return
+	}
+
+	x.mtx.Lock()
+	if !x.state.IsOpen() || x.unreachable {
+		x.mtx.Unlock()
+		return
+	}
+	x.unreachable = true
+	x.mtx.Unlock()
+
+	x.getLog().Warn("peer unreachable")
+	x.exchangeHooks.Unreachable()
+}
+
+func (x *Exchange) armUnreachable() {
+	// keep the deadline of the oldest unanswered handshake
+	if x.awaitingResponse {
+		return
+	}
+	x.awaitingResponse = true
+	x.tUnreachable.Reset(x.cfg.unreachableTimeout)
+}
+
 func (x *Exchange) resetBreak() {
-	x.tBreak.Reset(2 * 60 * time.Second)
+	x.tBreak.Reset(x.cfg.breakTimeout)
+	x.tUnreachable.Stop()
+	x.awaitingResponse = false
+
+	if x.unreachable {
+		x.unreachable = false
+		go x.exchangeHooks.Reachable()
+	}
 }
 
 func (x *Exchange) unregisterChannel(_ *Endpoint, _ *Exchange, c *Channel) error {
+	if d := c.lingerDuration(); d > 0 {
+		// keep acknowledging retransmissions of the final packets for a while
+		time.AfterFunc(d, func() { x.removeChannel(c) })
+		return nil
+	}
+
+	x.removeChannel(c)
+	return nil
+}
+
+func (x *Exchange) removeChannel(c *Channel) {
 	if x.channels.Remove(c.id) {
 		x.mtx.Lock()
 		x.resetExpire()
 		x.mtx.Unlock()
 
-		x.log.Printf("\x1B[31mClosed channel\x1B[0m %q %d", c.typ, c.id)
+		x.getLog().Info("closed channel", "type", c.typ, "id", c.id)
+		c.traceClosed()
 	}
-
-	return nil
 }
 
 func (x *Exchange) getNextChannelID() uint32 {
@@ -729,23 +932,36 @@ func (x *Exchange) waitDone() {
 	x.mtx.Unlock()
 }
 
-// Open a channel.
-func (x *Exchange) Open(typ string, reliable bool) (*Channel, error) {
-	var (
-		c *Channel
-	)
+// Open a channel. The options override the channel defaults of the endpoint.
+func (x *Exchange) Open(typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	return x.OpenContext(context.Background(), typ, reliable, options...)
+}
 
-	c = newChannel(
-		x.remoteIdent.Hashname(),
+// OpenContext is like Open but returns ctx.Err() when ctx is done before
+// the exchange is open.
+func (x *Exchange) OpenContext(ctx context.Context, typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	c, err := newChannel(
+		x.RemoteHashname(),
 		typ,
 		reliable,
 		false,
 		x,
-		registerExchange(x),
+		x.channelOptionsWith(registerExchange(x), options)...,
 	)
+	if err != nil {
+		return nil, err
+	}
 
 	x.mtx.Lock()
+	stop := wakeOnDone(ctx, &x.mtx, x.cndState)
+	defer stop()
+
 	for x.state == ExchangeDialing {
+		if err := ctx.Err(); err != nil {
+			x.mtx.Unlock()
+			c.unsetTimers()
+			return nil, err
+		}
 		x.cndState.Wait()
 	}
 	if !x.state.IsOpen() {
@@ -758,19 +974,54 @@ func (x *Exchange) Open(typ string, reliable bool) (*Channel, error) {
 	x.resetExpire()
 	x.mtx.Unlock()
 
-	x.log.Printf("\x1B[32mOpened channel\x1B[0m %q %d", typ, c.id)
+	x.getLog().Info("opened channel", "type", typ, "id", c.id)
 	c.channelHooks.Opened()
 	return c, nil
 }
 
 // LocalToken returns the token identifying the local side of the exchange.
 func (x *Exchange) LocalToken() cipherset.Token {
-	return x.cipher.LocalToken()
+	return x.getCipher().LocalToken()
 }
 
 // RemoteToken returns the token identifying the remote side of the exchange.
 func (x *Exchange) RemoteToken() cipherset.Token {
-	return x.cipher.RemoteToken()
+	return x.getCipher().RemoteToken()
+}
+
+// getCipher returns the current cipher state. Unlike x.cipher it is safe to
+// call without holding mtx; rekey may replace the cipher at any time.
+func (x *Exchange) getCipher() cipherset.State {
+	x.identMtx.RLock()
+	cipher := x.cipher
+	x.identMtx.RUnlock()
+	return cipher
+}
+
+// getRemoteIdent returns the current identity of the peer (nil while it is
+// still unknown). It is safe to call without holding mtx.
+func (x *Exchange) getRemoteIdent() *Identity {
+	x.identMtx.RLock()
+	ident := x.remoteIdent
+	x.identMtx.RUnlock()
+	return ident
+}
+
+// localHashname returns the current hashname of the local endpoint. It is safe
+// to call without holding mtx.
+func (x *Exchange) localHashname() hashname.H {
+	x.identMtx.RLock()
+	hn := x.localIdent.Hashname()
+	x.identMtx.RUnlock()
+	return hn
+}
+
+// getLog returns the current logger. It is safe to call without holding mtx.
+func (x *Exchange) getLog() *logs.Logger {
+	x.identMtx.RLock()
+	log := x.log
+	x.identMtx.RUnlock()
+	return log
 }
 
 // AddPathCandidate adds a new path tto the exchange. The path is
@@ -842,6 +1093,47 @@ func (x *Exchange) AddPipeConnection(conn net.Conn, addr net.Addr) (p *Pipe, add
 	return p, added
 }
 
+// rekey replaces the identities of the exchange (after a key rotation) and starts
+// a new handshake with the remote endpoint. The address book and the channels
+// of the exchange are kept.
+func (x *Exchange) rekey(localIdent, remoteIdent *Identity) error {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	if x.state.IsClosed() {
+		return BrokenExchangeError(remoteIdent.Hashname())
+	}
+
+	csid := cipherset.SelectCSID(localIdent.keys, remoteIdent.keys)
+	cipher, err := cipherset.NewState(csid, localIdent.keys[csid])
+	if err != nil {
+		return x.traceError(err)
+	}
+
+	err = cipher.SetRemoteKey(remoteIdent.keys[csid])
+	if err != nil {
+		return x.traceError(err)
+	}
+
+	x.identMtx.Lock()
+	x.localIdent = localIdent
+	x.remoteIdent = remoteIdent.withPaths(nil)
+	x.cipher = cipher
+	x.log = x.log.From(localIdent.Hashname()).To(remoteIdent.Hashname())
+	x.identMtx.Unlock()
+	x.csid = csid
+	x.lastRemoteSeq = 0
+	x.nextHandshake = 0
+
+	x.setState(ExchangeDialing)
+	x.cndState.Broadcast()
+
+	x.deliverHandshake()
+	x.rescheduleHandshake()
+
+	return nil
+}
+
 // ApplyHandshake applies a (out-of-band) handshake to the exchange. When the
 // handshake is accepted err is nil. When the handshake is a request-handshake
 // and it is accepted response will contain a response-handshake packet.
@@ -873,7 +1165,8 @@ func (x *Exchange) applyHandshake(handshake cipherset.Handshake, pipe *Pipe) (re
 		return nil, false
 	}
 
-	if !x.cipher.ApplyHandshake(handshake) {
+	ok = x.cipher.ApplyHandshake(handshake)
+	if !ok {
 		// drop; handshake was rejected by the cipherset
 		return nil, false
 	}
@@ -888,12 +1181,15 @@ func (x *Exchange) applyHandshake(handshake cipherset.Handshake, pipe *Pipe) (re
 			// drop; invalid identity
 			return nil, false
 		}
+		x.identMtx.Lock()
 		x.remoteIdent = ident
+		x.identMtx.Unlock()
 	}
 
 	if x.isLocalSeq(seq) {
 		x.resetBreak()
 		x.addressBook.ReceivedHandshake(pipe)
+		x.maybeProbeMTU(pipe)
 
 	} else {
 		x.addressBook.AddPipe(pipe)
@@ -908,7 +1204,7 @@ func (x *Exchange) applyHandshake(handshake cipherset.Handshake, pipe *Pipe) (re
 	if x.state == ExchangeDialing || x.state == ExchangeInitialising {
 		x.traceStarted()
 
-		x.state = ExchangeIdle
+		x.setState(ExchangeIdle)
 		x.resetExpire()
 		x.cndState.Broadcast()
 
@@ -966,9 +1262,12 @@ func (x *Exchange) receivedHandshake(msg message) bool {
 	}
 
 	x.lastRemoteSeq = handshake.At()
+	x.metrics.handshakesReceived.Add(1)
 
 	if resp != nil {
-		msg.Pipe.Write(resp)
+		if _, err := msg.Pipe.Write(resp); err == nil {
+			x.metrics.handshakesSent.Add(1)
+		}
 	}
 
 	x.traceReceivedHandshake(msg, handshake)
@@ -16,7 +16,8 @@ const (
 )
 
 type addressBook struct {
-	log *logs.Logger
+	log     *logs.Logger
+	metrics *endpointMetrics
 
 	mtx         sync.RWMutex
 	active      *addressBookEntry
@@ -42,8 +43,8 @@ type addressBookEntry struct {
 	ewma    time.Duration
 }
 
-func newAddressBook(log *logs.Logger) *addressBook {
-	return &addressBook{log: log.Module("addrbook")}
+func newAddressBook(log *logs.Logger, metrics *endpointMetrics) *addressBook {
+	return &addressBook{log: log.Module("addrbook"), metrics: metrics}
 }
 
 func (book *addressBook) ActiveConnection() *Pipe {
@@ -117,9 +118,10 @@ func (book *addressBook) NextHandshakeEpoch() {
 			if !e.ReceivedHandshakeAt.IsZero() {
 				// successful handshake: update latency
 				e.AddLatencySample(e.ReceivedHandshakeAt.Sub(e.SendHandshakeAt))
+				book.metrics.pathLatency(e.Address, e.latency)
 				e.ExpireAt = e.ReceivedHandshakeAt.Add(2 * time.Minute)
 				e.Reachable = true
-				book.log.Printf("\x1B[34mUpdated path\x1B[0m %s (latency=\x1B[33m%s\x1B[0m, emwa=\x1B[33m%s\x1B[0m)", e, e.latency, e.ewma)
+				book.log.Debug("updated path", "path", e, "latency", e.latency, "ewma", e.ewma)
 
 			} else {
 				// no response
@@ -128,11 +130,11 @@ func (book *addressBook) NextHandshakeEpoch() {
 					e.Reachable = false
 					e.latency = 125 * time.Millisecond
 					e.ewma = 125 * time.Millisecond
-					book.log.Printf("\x1B[31mDetected broken path\x1B[0m %s", e)
+					book.log.Warn("detected broken path", "path", e)
 
 				} else {
 					e.AddLatencySample(now.Sub(e.SendHandshakeAt))
-					book.log.Printf("\x1B[34mUpdated path\x1B[0m %s (latency=\x1B[33m%s\x1B[0m, emwa=\x1B[33m%s\x1B[0m)", e, e.latency, e.ewma)
+					book.log.Debug("updated path", "path", e, "latency", e.latency, "ewma", e.ewma)
 
 				}
 			}
@@ -152,6 +154,12 @@ func (book *addressBook) NextHandshakeEpoch() {
 		book.known = book.known[:cMaxAddressBookEntries]
 	}
 
+	book.updateActive()
+}
+
+// updateActive selects the active path and the backups from the (sorted)
+// known paths.
+func (book *addressBook) updateActive() {
 	// update active
 	var oldActive = book.active
 	if book.known[0].Reachable {
@@ -160,7 +168,7 @@ func (book *addressBook) NextHandshakeEpoch() {
 		book.active = nil
 	}
 	if book.active != oldActive {
-		book.log.Printf("\x1B[32mChanged path\x1B[0m from %s to %s", oldActive, book.active)
+		book.log.Info("changed path", "from", oldActive, "to", book.active)
 	}
 
 	// update fallbacks
@@ -171,7 +179,6 @@ func (book *addressBook) NextHandshakeEpoch() {
 			entry.IsBackup = false
 		}
 	}
-
 }
 
 func (book *addressBook) PipeToAddr(addr net.Addr) *Pipe {
@@ -214,12 +221,37 @@ func (book *addressBook) AddPipe(p *Pipe) {
 	e.InitSamples()
 
 	book.known = append(book.known, e)
-	book.log.Printf("\x1B[32mDiscovered path\x1B[0m %s (latency=\x1B[33m%s\x1B[0m, emwa=\x1B[33m%s\x1B[0m)", e, e.latency, e.ewma)
+	book.log.Info("discovered path", "path", e, "latency", e.latency, "ewma", e.ewma)
 
 	if book.active == nil {
 		book.active = e
-		book.log.Printf("\x1B[32mChanged path\x1B[0m from %s to %s", (*addressBookEntry)(nil), book.active)
+		book.log.Info("changed path", "from", (*addressBookEntry)(nil), "to", book.active)
+	}
+}
+
+// UnsupportedPipe marks the path of pipe as unreachable because the local
+// transport can't send to its address (like a udp address while only tls is
+// available). Otherwise the path might stay active until the latency samples
+// of the handshakes catch up.
+func (book *addressBook) UnsupportedPipe(pipe *Pipe) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	idx := book.indexOfPipe(pipe)
+	if idx < 0 {
+		return
 	}
+
+	e := book.known[idx]
+	if !e.Reachable {
+		return
+	}
+
+	e.Reachable = false
+	book.log.Debug("unsupported path", "path", e)
+
+	sort.Sort(sortedAddressBookEntries(book.known))
+	book.updateActive()
 }
 
 func (book *addressBook) SentHandshake(pipe *Pipe) {
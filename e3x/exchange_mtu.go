@@ -0,0 +1,186 @@
+package e3x
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+	"github.com/telehash/gogotelehash/transports"
+)
+
+// DefaultMTU is the MTU of a pipe until it was probed.
+const DefaultMTU = 1280
+
+const (
+	cMinMTU       = 512
+	cMaxMTU       = transports.DefaultMaxMessageSize // unless the pipe accepts larger messages
+	cMTUPrecision = 16
+
+	hdrProbe    = "probe"
+	hdrProbeAck = "probe-ack"
+	hdrProbeLen = "len"
+)
+
+var nextProbeID uint32
+
+// maybeProbeMTU starts path MTU discovery on p unless the pipe is being probed
+// or was probed recently. It is called when the peer responded to a handshake
+// on p (so p is known to work).
+func (x *Exchange) maybeProbeMTU(p *Pipe) {
+	if p == nil {
+		return
+	}
+
+	p.mtuMtx.Lock()
+	if p.mtuProbing || (!p.mtuProbedAt.IsZero() && time.Since(p.mtuProbedAt) < x.cfg.mtuProbeInterval) {
+		p.mtuMtx.Unlock()
+		return
+	}
+	p.mtuProbing = true
+	p.mtuMtx.Unlock()
+
+	go x.probeMTU(p)
+}
+
+// probeMTU determines the largest message that can be sent over p with a
+// binary search between cMinMTU and the maximum message size of the pipe.
+func (x *Exchange) probeMTU(p *Pipe) {
+	var (
+		best   int
+		lo, hi = cMinMTU, p.MaxMessageSize()
+	)
+
+	if n := x.sendProbe(p, hi); n > 0 {
+		best = n
+	} else {
+		for hi-lo > cMTUPrecision {
+			mid := (lo + hi) / 2
+			if n := x.sendProbe(p, mid); n > 0 {
+				best, lo = n, n
+			} else {
+				hi = mid
+			}
+		}
+		if best == 0 {
+			best = x.sendProbe(p, lo)
+		}
+	}
+
+	p.mtuMtx.Lock()
+	p.mtuProbing = false
+	p.mtuProbedAt = time.Now()
+	if best > 0 {
+		// keep the previous value when all probes were lost
+		p.mtu = best
+	}
+	p.mtuMtx.Unlock()
+
+	if best > 0 {
+		x.getLog().Debug("discovered path MTU", "path", p.RemoteAddr(), "mtu", best)
+	}
+}
+
+// sendProbe sends a probe of size bytes over p and waits for the response. It
+// returns the size of the probe as seen by the peer or 0 when the probe was
+// lost.
+func (x *Exchange) sendProbe(p *Pipe, size int) int {
+	id := atomic.AddUint32(&nextProbeID, 1)
+
+	newProbe := func(pad int) *lob.Packet {
+		pkt := lob.New(make([]byte, pad))
+		pkt.Header().SetUint32(hdrProbe, id)
+		return pkt
+	}
+
+	// the encryption overhead doesn't depend on the size of the body
+	msg, err := x.encryptPacket(newProbe(0))
+	if err != nil {
+		return 0
+	}
+	pad := size - msg.Len()
+	msg.Free()
+	if pad < 0 {
+		return 0
+	}
+
+	msg, err = x.encryptPacket(newProbe(pad))
+	if err != nil {
+		return 0
+	}
+
+	ack := make(chan int, 1)
+	x.probeMtx.Lock()
+	if x.probeAcks == nil {
+		x.probeAcks = make(map[uint32]chan int)
+	}
+	x.probeAcks[id] = ack
+	x.probeMtx.Unlock()
+
+	defer func() {
+		x.probeMtx.Lock()
+		delete(x.probeAcks, id)
+		x.probeMtx.Unlock()
+	}()
+
+	_, err = p.Write(msg)
+	msg.Free()
+	if err != nil {
+		return 0
+	}
+
+	select {
+	case n := <-ack:
+		return n
+	case <-time.After(x.cfg.mtuProbeTimeout):
+		return 0
+	}
+}
+
+// receivedProbe handles probes and probe responses. It returns false when pkt
+// is neither.
+func (x *Exchange) receivedProbe(msg message, pkt *lob.Packet) bool {
+	hdr := pkt.Header()
+
+	if id, ok := hdr.GetUint32(hdrProbe); ok {
+		resp := lob.New(nil)
+		resp.Header().SetUint32(hdrProbeAck, id)
+		resp.Header().SetInt(hdrProbeLen, msg.Data.Len())
+
+		buf, err := x.encryptPacket(resp)
+		if err == nil {
+			msg.Pipe.Write(buf)
+			buf.Free()
+		}
+		return true
+	}
+
+	if id, ok := hdr.GetUint32(hdrProbeAck); ok {
+		n, _ := hdr.GetInt(hdrProbeLen)
+
+		x.probeMtx.Lock()
+		if ack := x.probeAcks[id]; ack != nil {
+			select {
+			case ack <- n:
+			default:
+			}
+		}
+		x.probeMtx.Unlock()
+		return true
+	}
+
+	return false
+}
+
+// encryptPacket encrypts and encodes pkt. pkt is freed.
+func (x *Exchange) encryptPacket(pkt *lob.Packet) (*bufpool.Buffer, error) {
+	pkt2, err := x.getCipher().EncryptPacket(pkt)
+	pkt.Free()
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := lob.Encode(pkt2)
+	pkt2.Free()
+	return msg, err
+}
@@ -0,0 +1,127 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+	"github.com/telehash/gogotelehash/transports/tcp"
+)
+
+func TestPathMTUDiscovery(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	for _, limit := range []int{0, 1100} {
+		var endpoints [2]*Endpoint
+		for i := range endpoints {
+			e, err := Open(
+				Transport(throttledConfig{Config: inproc.Config{}, MaxSize: limit}),
+				ExchangeDefaults(MTUProbeTimeout(50*time.Millisecond)),
+				Log(nil))
+			if !assert.NoError(err) {
+				return
+			}
+			defer e.Close()
+			endpoints[i] = e
+		}
+		A, B := endpoints[0], endpoints[1]
+
+		identB, err := B.LocalIdentity()
+		if !assert.NoError(err) {
+			return
+		}
+
+		x, err := A.Dial(identB)
+		if !assert.NoError(err) {
+			return
+		}
+
+		p := x.ActivePipe()
+		deadline := time.Now().Add(5 * time.Second)
+		for p.MTU() == DefaultMTU && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if limit == 0 {
+			assert.Equal(cMaxMTU, p.MTU())
+		} else {
+			assert.True(p.MTU() <= limit && p.MTU() > limit-cMTUPrecision,
+				"mtu=%d limit=%d", p.MTU(), limit)
+		}
+	}
+}
+
+func TestPathMTUDiscoveryOverTCP(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var endpoints [2]*Endpoint
+	for i := range endpoints {
+		e, err := Open(
+			Transport(tcp.Config{Addr: "127.0.0.1:0"}),
+			ExchangeDefaults(MTUProbeTimeout(50*time.Millisecond)),
+			Log(nil))
+		if !assert.NoError(err) {
+			return
+		}
+		defer e.Close()
+		endpoints[i] = e
+	}
+	A, B := endpoints[0], endpoints[1]
+
+	identB, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	go func() {
+		c, err := B.Listen("large", false).AcceptChannel()
+		if err != nil {
+			return
+		}
+		defer c.Kill()
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		pkt, err := c.ReadPacket()
+		if err == nil {
+			c.WritePacket(pkt)
+		}
+	}()
+
+	x, err := A.Dial(identB)
+	if !assert.NoError(err) {
+		return
+	}
+
+	p := x.ActivePipe()
+	assert.Equal(tcp.DefaultMaxFrameSize, p.MaxMessageSize())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for p.MTU() == DefaultMTU && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(tcp.DefaultMaxFrameSize, p.MTU())
+
+	c, err := A.Open(identB, "large", false)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Kill()
+
+	size := c.MaxPacketSize()
+	assert.True(size > cMaxMTU, "size=%d", size)
+
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	assert.NoError(c.WritePacket(lob.New(make([]byte, size))))
+	pkt, err := c.ReadPacket()
+	if assert.NoError(err) {
+		assert.Equal(size, len(pkt.Body(nil)))
+		pkt.Free()
+	}
+}
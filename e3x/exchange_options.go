@@ -0,0 +1,135 @@
+package e3x
+
+import (
+	"errors"
+	"time"
+)
+
+// Default keepalive interval, timeouts, send queue size and path MTU
+// discovery timers of exchanges.
+const (
+	DefaultKeepAlive          = 60 * time.Second
+	DefaultUnreachableTimeout = 30 * time.Second
+	DefaultBreakTimeout       = 2 * 60 * time.Second
+	DefaultIdleTimeout        = 2 * 60 * time.Second
+	DefaultSendQueueSize      = 256
+	DefaultMTUProbeInterval   = 10 * 60 * time.Second
+	DefaultMTUProbeTimeout    = 1 * time.Second
+)
+
+var ErrInvalidExchangeOption = errors.New("e3x: invalid exchange option")
+
+// exchangeConfig holds the tunable keepalive interval, timeouts, send queue
+// size and path MTU discovery timers of an exchange.
+type exchangeConfig struct {
+	keepAlive          time.Duration
+	unreachableTimeout time.Duration
+	breakTimeout       time.Duration
+	idleTimeout        time.Duration
+	sendQueueSize      int
+	mtuProbeInterval   time.Duration
+	mtuProbeTimeout    time.Duration
+}
+
+var defaultExchangeConfig = exchangeConfig{
+	keepAlive:          DefaultKeepAlive,
+	unreachableTimeout: DefaultUnreachableTimeout,
+	breakTimeout:       DefaultBreakTimeout,
+	idleTimeout:        DefaultIdleTimeout,
+	sendQueueSize:      DefaultSendQueueSize,
+	mtuProbeInterval:   DefaultMTUProbeInterval,
+	mtuProbeTimeout:    DefaultMTUProbeTimeout,
+}
+
+// KeepAlive sets the maximum interval between two handshakes. Handshakes double
+// as heartbeats; the peer must respond to each of them.
+func KeepAlive(d time.Duration) ExchangeOption {
+	return func(x *Exchange) error {
+		if d <= 0 {
+			return ErrInvalidExchangeOption
+		}
+		x.cfg.keepAlive = d
+		return nil
+	}
+}
+
+// UnreachableTimeout sets how long the exchange waits for the response to a
+// keepalive handshake before the peer is reported as unreachable (see
+// ExchangeHook.OnUnreachable). The exchange itself stays open.
+func UnreachableTimeout(d time.Duration) ExchangeOption {
+	return func(x *Exchange) error {
+		if d <= 0 {
+			return ErrInvalidExchangeOption
+		}
+		x.cfg.unreachableTimeout = d
+		return nil
+	}
+}
+
+// BreakTimeout sets how long the exchange stays open without receiving a
+// handshake response. After that the exchange is broken.
+func BreakTimeout(d time.Duration) ExchangeOption {
+	return func(x *Exchange) error {
+		if d <= 0 {
+			return ErrInvalidExchangeOption
+		}
+		x.cfg.breakTimeout = d
+		return nil
+	}
+}
+
+// IdleTimeout sets how long an exchange without open channels is kept before
+// it expires.
+func IdleTimeout(d time.Duration) ExchangeOption {
+	return func(x *Exchange) error {
+		if d <= 0 {
+			return ErrInvalidExchangeOption
+		}
+		x.cfg.idleTimeout = d
+		return nil
+	}
+}
+
+// SendQueueSize sets the number of packets the send scheduler of an exchange
+// queues before the writing channels are blocked.
+func SendQueueSize(n int) ExchangeOption {
+	return func(x *Exchange) error {
+		if n <= 0 {
+			return ErrInvalidExchangeOption
+		}
+		x.cfg.sendQueueSize = n
+		return nil
+	}
+}
+
+// MTUProbeInterval sets how often the MTU of a pipe is probed again. Probes
+// are sent after the peer responded to a keepalive handshake on the pipe.
+func MTUProbeInterval(d time.Duration) ExchangeOption {
+	return func(x *Exchange) error {
+		if d <= 0 {
+			return ErrInvalidExchangeOption
+		}
+		x.cfg.mtuProbeInterval = d
+		return nil
+	}
+}
+
+// MTUProbeTimeout sets how long the exchange waits for the response to a
+// single MTU probe before the probed size is considered too large.
+func MTUProbeTimeout(d time.Duration) ExchangeOption {
+	return func(x *Exchange) error {
+		if d <= 0 {
+			return ErrInvalidExchangeOption
+		}
+		x.cfg.mtuProbeTimeout = d
+		return nil
+	}
+}
+
+// ExchangeDefaults sets the default options of all the exchanges of an endpoint.
+func ExchangeDefaults(options ...ExchangeOption) EndpointOption {
+	return func(e *Endpoint) error {
+		e.exchangeOptions = append(e.exchangeOptions, options...)
+		return nil
+	}
+}
@@ -0,0 +1,109 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestExchangeOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	x := &Exchange{cfg: defaultExchangeConfig}
+	assert.Equal(DefaultKeepAlive, x.cfg.keepAlive)
+	assert.Equal(DefaultBreakTimeout, x.cfg.breakTimeout)
+
+	err := x.setOptions(
+		KeepAlive(5*time.Second),
+		UnreachableTimeout(10*time.Second),
+		BreakTimeout(30*time.Second),
+		IdleTimeout(time.Minute))
+	assert.NoError(err)
+	assert.Equal(5*time.Second, x.cfg.keepAlive)
+	assert.Equal(10*time.Second, x.cfg.unreachableTimeout)
+	assert.Equal(30*time.Second, x.cfg.breakTimeout)
+	assert.Equal(time.Minute, x.cfg.idleTimeout)
+
+	assert.Equal(ErrInvalidExchangeOption, x.setOptions(KeepAlive(0)))
+	assert.Equal(ErrInvalidExchangeOption, x.setOptions(UnreachableTimeout(-1)))
+	assert.Equal(ErrInvalidExchangeOption, x.setOptions(BreakTimeout(0)))
+	assert.Equal(ErrInvalidExchangeOption, x.setOptions(IdleTimeout(0)))
+}
+
+func TestExchangeUnreachableBeforeBreak(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	A, err := Open(
+		Transport(inproc.Config{}),
+		ExchangeDefaults(
+			KeepAlive(100*time.Millisecond),
+			UnreachableTimeout(300*time.Millisecond),
+			BreakTimeout(2*time.Second)),
+		Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Open(Transport(inproc.Config{}), Log(nil))
+	if !assert.NoError(err) {
+		return
+	}
+
+	var (
+		unreachable = make(chan time.Time, 1)
+		closed      = make(chan time.Time, 1)
+	)
+	A.DefaultExchangeHooks().Register(ExchangeHook{
+		OnUnreachable: func(e *Endpoint, x *Exchange) error {
+			unreachable <- time.Now()
+			return nil
+		},
+		OnClosed: func(e *Endpoint, x *Exchange, reason error) error {
+			closed <- time.Now()
+			return nil
+		},
+	})
+
+	identB, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	x, err := A.Dial(identB)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// keepalives are answered
+	time.Sleep(time.Second)
+	assert.True(x.Reachable())
+	assert.Len(unreachable, 0)
+
+	start := time.Now()
+	B.Close()
+
+	var unreachableAt, closedAt time.Time
+	select {
+	case unreachableAt = <-unreachable:
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer was never reported unreachable")
+	}
+	assert.False(x.Reachable())
+	assert.True(x.State().IsOpen())
+	assert.True(unreachableAt.Sub(start) < time.Second, "unreachable after %s", unreachableAt.Sub(start))
+
+	select {
+	case closedAt = <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("exchange never broke")
+	}
+	assert.True(closedAt.After(unreachableAt))
+	assert.Equal(ExchangeBroken, x.State())
+}
@@ -4,9 +4,11 @@ import (
 	"io"
 	"net"
 	"sync"
+	"time"
 
+	"github.com/telehash/gogotelehash/e3x/capture"
+	"github.com/telehash/gogotelehash/e3x/tracer"
 	"github.com/telehash/gogotelehash/internal/util/bufpool"
-	"github.com/telehash/gogotelehash/internal/util/tracer"
 	"github.com/telehash/gogotelehash/transports"
 )
 
@@ -18,8 +20,17 @@ type Pipe struct {
 	transport transports.Transport
 	raddr     net.Addr
 	conn      net.Conn
+
+	mtuMtx      sync.Mutex
+	mtu         int // 0 until probed
+	mtuProbedAt time.Time
+	mtuProbing  bool
 }
 
+// cPooledReadSize is the size of the messages read directly into pooled
+// buffers.
+const cPooledReadSize = 1500
+
 type message struct {
 	TID         tracer.ID
 	Data        *bufpool.Buffer
@@ -29,6 +40,8 @@ type message struct {
 
 type pipeDelegate interface {
 	received(msg message)
+	getMetrics() *endpointMetrics
+	getCapture() *endpointCapture
 	dialDialerAddr(dialerAddr) (net.Conn, error)
 }
 
@@ -120,13 +133,50 @@ func (p *Pipe) RemoteAddr() net.Addr {
 	return p.raddr
 }
 
+// MTU returns the largest message (in bytes) that can be sent over the pipe as
+// determined by path MTU discovery. DefaultMTU is returned until the pipe was
+// probed.
+func (p *Pipe) MTU() int {
+	p.mtuMtx.Lock()
+	defer p.mtuMtx.Unlock()
+
+	if p.mtu == 0 {
+		return DefaultMTU
+	}
+	return p.mtu
+}
+
+// MaxMessageSize returns the largest message accepted by the connection of the
+// pipe (transports.DefaultMaxMessageSize until the pipe is connected). It is
+// the upper bound of path MTU discovery.
+func (p *Pipe) MaxMessageSize() int {
+	conn := p.currentConn()
+	if conn == nil {
+		return transports.DefaultMaxMessageSize
+	}
+	return transports.MaxMessageSize(conn)
+}
+
 func (p *Pipe) Write(b *bufpool.Buffer) (int, error) {
 	conn, err := p.dial()
 	if err != nil {
 		return 0, err
 	}
 
-	return conn.Write(b.RawBytes())
+	n, err := conn.Write(b.RawBytes())
+	if err == nil {
+		p.delegate.getMetrics().bytesOut(p.raddr, n)
+		p.delegate.getCapture().raw(capture.Out, conn.LocalAddr(), p.raddr, b.RawBytes())
+	}
+	return n, err
+}
+
+// currentConn returns the connection of the pipe or nil when the pipe was not
+// dialed yet.
+func (p *Pipe) currentConn() net.Conn {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	return p.conn
 }
 
 func (p *Pipe) Close() error {
@@ -168,15 +218,44 @@ func (p *Pipe) reader(conn net.Conn) {
 		p.wg.Done()
 	}()
 
+	scratch := newReadBuffer(conn)
 	for {
-		buf := bufpool.New()
-
-		n, err := conn.Read(buf.RawBytes()[:1500])
+		buf, err := readMessage(conn, scratch)
 		if err != nil {
-			buf.Free()
 			return
 		}
 
-		p.delegate.received(newMessage(buf.SetLen(n), p))
+		p.delegate.getMetrics().bytesIn(p.raddr, buf.Len())
+		p.delegate.getCapture().raw(capture.In, conn.LocalAddr(), p.raddr, buf.RawBytes())
+		p.delegate.received(newMessage(buf, p))
+	}
+}
+
+// newReadBuffer returns a buffer for the messages read from conn when they
+// don't fit in a pooled buffer (and nil otherwise).
+func newReadBuffer(conn net.Conn) []byte {
+	if n := transports.MaxMessageSize(conn); n > cPooledReadSize {
+		return make([]byte, n)
+	}
+	return nil
+}
+
+// readMessage reads the next message from conn into a pooled buffer. scratch
+// must be the buffer returned by newReadBuffer (or nil).
+func readMessage(conn net.Conn, scratch []byte) (*bufpool.Buffer, error) {
+	if scratch != nil {
+		n, err := conn.Read(scratch)
+		if err != nil {
+			return nil, err
+		}
+		return bufpool.New().Set(scratch[:n]), nil
+	}
+
+	buf := bufpool.New()
+	n, err := conn.Read(buf.RawBytes()[:cPooledReadSize])
+	if err != nil {
+		buf.Free()
+		return nil, err
 	}
+	return buf.SetLen(n), nil
 }
@@ -0,0 +1,220 @@
+package e3x
+
+import (
+	"container/heap"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+var (
+	errSchedulerClosed = errors.New("e3x: send scheduler closed")
+	errWouldBlock      = errors.New("e3x: send would block")
+)
+
+// sendScheduler is the bounded send queue of an exchange. Channels push their
+// (encrypted) packets onto the queue and a single goroutine writes them to the
+// pipes, highest priority first. Packets with the same priority are sent in
+// the order they were pushed. Pushing blocks while the queue is full; blocked
+// pushers are admitted in the same order. A failed write is reported by the
+// next push for the same pipe.
+type sendScheduler struct {
+	mtx     sync.Mutex
+	cndPush *sync.Cond
+	cndPop  *sync.Cond
+
+	queue   sendQueue
+	blocked sendQueue // pushers waiting for room in the queue
+	errs    map[*Pipe]error
+	size    int
+	nextSeq uint64
+	closed  bool
+}
+
+type sendQueueEntry struct {
+	msg      *bufpool.Buffer
+	pipe     *Pipe
+	priority int
+	seq      uint64
+}
+
+func newSendScheduler(size int) *sendScheduler {
+	s := &sendScheduler{size: size, errs: make(map[*Pipe]error)}
+	s.cndPush = sync.NewCond(&s.mtx)
+	s.cndPop = sync.NewCond(&s.mtx)
+	return s
+}
+
+// push queues msg for delivery on pipe. The scheduler owns msg afterwards.
+// When a previous write to pipe failed that error is returned and msg is
+// dropped.
+func (s *sendScheduler) push(msg *bufpool.Buffer, pipe *Pipe, priority int) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e := &sendQueueEntry{msg: msg, pipe: pipe, priority: priority, seq: s.nextSeq}
+	s.nextSeq++
+
+	if !s.closed && (len(s.queue) >= s.size || len(s.blocked) > 0) {
+		heap.Push(&s.blocked, e)
+		for !s.closed && (len(s.queue) >= s.size || s.blocked[0] != e) {
+			s.cndPush.Wait()
+		}
+		if !s.closed {
+			heap.Pop(&s.blocked)
+		}
+	}
+
+	if s.closed {
+		msg.Free()
+		return errSchedulerClosed
+	}
+
+	defer func() {
+		if len(s.blocked) > 0 && len(s.queue) < s.size {
+			// there is room for the next pusher as well
+			s.cndPush.Broadcast()
+		}
+	}()
+
+	return s.enqueue(e)
+}
+
+// tryPush is like push but it never waits for room in the queue. msg is dropped
+// (and errWouldBlock is returned) when the queue is full or when other
+// pushers are waiting for room.
+func (s *sendScheduler) tryPush(msg *bufpool.Buffer, pipe *Pipe, priority int) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closed {
+		msg.Free()
+		return errSchedulerClosed
+	}
+
+	if len(s.queue) >= s.size || len(s.blocked) > 0 {
+		msg.Free()
+		return errWouldBlock
+	}
+
+	e := &sendQueueEntry{msg: msg, pipe: pipe, priority: priority, seq: s.nextSeq}
+	s.nextSeq++
+	return s.enqueue(e)
+}
+
+// enqueue adds e to the queue. The caller must hold the lock.
+func (s *sendScheduler) enqueue(e *sendQueueEntry) error {
+	if err := s.errs[e.pipe]; err != nil {
+		delete(s.errs, e.pipe)
+		e.msg.Free()
+		return err
+	}
+
+	heap.Push(&s.queue, e)
+	s.cndPop.Signal()
+	return nil
+}
+
+// pop returns the next packet to send. It blocks while the queue is empty and
+// returns nil once the scheduler is closed.
+func (s *sendScheduler) pop() *sendQueueEntry {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for !s.closed && len(s.queue) == 0 {
+		s.cndPop.Wait()
+	}
+
+	if s.closed {
+		return nil
+	}
+
+	e := heap.Pop(&s.queue).(*sendQueueEntry)
+	if len(s.blocked) > 0 {
+		// wake all the blocked pushers; only the first one is admitted
+		s.cndPush.Broadcast()
+	}
+	return e
+}
+
+// failed records the error of a write to pipe. Transient errors (like a full
+// socket buffer) are handled like lost packets.
+func (s *sendScheduler) failed(pipe *Pipe, err error) {
+	if ne, ok := err.(net.Error); ok && (ne.Temporary() || ne.Timeout()) {
+		return
+	}
+
+	s.mtx.Lock()
+	if !s.closed {
+		s.errs[pipe] = err
+	}
+	s.mtx.Unlock()
+}
+
+// close drops all the queued packets and wakes the blocked pushers.
+func (s *sendScheduler) close() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	for _, e := range s.queue {
+		e.msg.Free()
+	}
+	s.queue = nil
+	s.errs = nil
+
+	s.cndPush.Broadcast()
+	s.cndPop.Broadcast()
+}
+
+// run writes the queued packets until the scheduler is closed.
+func (s *sendScheduler) run() {
+	for {
+		e := s.pop()
+		if e == nil {
+			return
+		}
+
+		n, err := e.pipe.Write(e.msg)
+		if err == nil && n < e.msg.Len() {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			s.failed(e.pipe, err)
+		}
+		e.msg.Free()
+	}
+}
+
+type sendQueue []*sendQueueEntry
+
+func (q sendQueue) Len() int { return len(q) }
+
+func (q sendQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q sendQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *sendQueue) Push(x interface{}) {
+	*q = append(*q, x.(*sendQueueEntry))
+}
+
+func (q *sendQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return e
+}
@@ -0,0 +1,336 @@
+package e3x
+
+import (
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+// throttledConfig wraps a transport and delays every write by Delay to
+// emulate the serialization time of a slow link. Writes larger than MaxSize
+// (when set) are silently dropped.
+type throttledConfig struct {
+	Config  transports.Config
+	Delay   time.Duration
+	MaxSize int
+}
+
+type throttledTransport struct {
+	transports.Transport
+	link *throttledLink
+}
+
+type throttledLink struct {
+	mtx     sync.Mutex
+	delay   time.Duration
+	maxSize int
+}
+
+type throttledConn struct {
+	net.Conn
+	link *throttledLink
+}
+
+func (c throttledConfig) Open() (transports.Transport, error) {
+	t, err := c.Config.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &throttledTransport{t, &throttledLink{delay: c.Delay, maxSize: c.MaxSize}}, nil
+}
+
+func (t *throttledTransport) Dial(addr net.Addr) (net.Conn, error) {
+	conn, err := t.Transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledConn{conn, t.link}, nil
+}
+
+func (t *throttledTransport) Accept() (net.Conn, error) {
+	conn, err := t.Transport.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &throttledConn{conn, t.link}, nil
+}
+
+func (c *throttledConn) Write(b []byte) (int, error) {
+	if c.link.maxSize > 0 && len(b) > c.link.maxSize {
+		return len(b), nil
+	}
+	c.link.mtx.Lock()
+	defer c.link.mtx.Unlock()
+	time.Sleep(c.link.delay)
+	return c.Conn.Write(b)
+}
+
+func TestSendSchedulerOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newSendScheduler(10)
+	for _, e := range []struct {
+		body     string
+		priority int
+	}{
+		{"a", 0}, {"b", 0}, {"c", 5}, {"d", -1}, {"e", 0}, {"f", 5},
+	} {
+		assert.NoError(s.push(bufpool.New().Set([]byte(e.body)), nil, e.priority))
+	}
+
+	var order string
+	for i := 0; i < 6; i++ {
+		e := s.pop()
+		order += string(e.msg.Get(nil))
+		e.msg.Free()
+	}
+	assert.Equal("cfabed", order)
+
+	s.close()
+	assert.Nil(s.pop())
+	assert.Equal(errSchedulerClosed, s.push(bufpool.New(), nil, 0))
+}
+
+func TestSendSchedulerBounded(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newSendScheduler(2)
+	assert.NoError(s.push(bufpool.New(), nil, 0))
+	assert.NoError(s.push(bufpool.New(), nil, 0))
+
+	pushed := make(chan error, 1)
+	go func() { pushed <- s.push(bufpool.New(), nil, 0) }()
+
+	select {
+	case <-pushed:
+		t.Fatal("push must block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.pop().msg.Free()
+	assert.NoError(<-pushed)
+
+	go func() { pushed <- s.push(bufpool.New(), nil, 0) }()
+	time.Sleep(10 * time.Millisecond)
+	s.close()
+	assert.Equal(errSchedulerClosed, <-pushed)
+}
+
+func TestSendSchedulerTryPush(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newSendScheduler(1)
+	assert.NoError(s.tryPush(bufpool.New(), nil, 0))
+
+	// the receive path never waits for room in the queue
+	pushed := make(chan error, 1)
+	go func() { pushed <- s.tryPush(bufpool.New(), nil, 5) }()
+
+	select {
+	case err := <-pushed:
+		assert.Equal(errWouldBlock, err)
+	case <-time.After(time.Second):
+		t.Fatal("tryPush must not block while the queue is full")
+	}
+
+	s.pop().msg.Free()
+	assert.NoError(s.tryPush(bufpool.New(), nil, 0))
+
+	s.close()
+	assert.Equal(errSchedulerClosed, s.tryPush(bufpool.New(), nil, 0))
+}
+
+func TestSendSchedulerBlockedPriority(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newSendScheduler(1)
+	assert.NoError(s.push(bufpool.New().Set([]byte("a")), nil, 0))
+
+	pushed := make(chan error, 2)
+	go func() { pushed <- s.push(bufpool.New().Set([]byte("b")), nil, 0) }()
+	time.Sleep(10 * time.Millisecond)
+	go func() { pushed <- s.push(bufpool.New().Set([]byte("c")), nil, 5) }()
+	time.Sleep(10 * time.Millisecond)
+
+	// the high priority pusher is admitted first
+	var order string
+	for i := 0; i < 3; i++ {
+		e := s.pop()
+		order += string(e.msg.Get(nil))
+		e.msg.Free()
+		if i < 2 {
+			assert.NoError(<-pushed)
+		}
+	}
+	assert.Equal("acb", order)
+
+	s.close()
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+func TestSendSchedulerWriteError(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		s = newSendScheduler(10)
+		p = &Pipe{}
+		q = &Pipe{}
+	)
+	defer s.close()
+
+	s.failed(p, temporaryError{})
+	assert.NoError(s.push(bufpool.New(), p, 0))
+
+	// a failed write is reported once by the next push for the same pipe
+	s.failed(p, io.ErrShortWrite)
+	assert.NoError(s.push(bufpool.New(), q, 0))
+	assert.Equal(io.ErrShortWrite, s.push(bufpool.New(), p, 0))
+	assert.NoError(s.push(bufpool.New(), p, 0))
+}
+
+func TestPriorityChannelLatency(t *testing.T) {
+	if testing.Short() {
+		t.Skip("this is a long running test.")
+	}
+
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+
+	var endpoints [2]*Endpoint
+	for i := range endpoints {
+		e, err := Open(
+			Transport(throttledConfig{Config: inproc.Config{}, Delay: 200 * time.Microsecond}),
+			Log(nil))
+		if !assert.NoError(err) {
+			return
+		}
+		defer e.Close()
+		endpoints[i] = e
+	}
+	A, B := endpoints[0], endpoints[1]
+
+	identB, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	// B drains the bulk channel and echoes pings
+	go func() {
+		c, err := B.Listen("bulk", true).AcceptChannel()
+		if err != nil {
+			return
+		}
+		defer c.Kill()
+		for i := 0; ; i++ {
+			pkt, err := c.ReadPacket()
+			if err != nil {
+				return
+			}
+			pkt.Free()
+			if i == 0 {
+				c.WritePacket(lob.New(nil))
+			}
+		}
+	}()
+	go func() {
+		l := B.Listen("ping", false)
+		for {
+			c, err := l.AcceptChannel()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Kill()
+				for {
+					pkt, err := c.ReadPacket()
+					if err != nil {
+						return
+					}
+					c.WritePacket(pkt)
+				}
+			}()
+		}
+	}()
+
+	bulk, err := A.Open(identB, "bulk", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer bulk.Kill()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		body := make([]byte, 1000)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if bulk.WritePacket(lob.New(body)) != nil {
+				return
+			}
+		}
+	}()
+
+	measure := func(priority int) time.Duration {
+		c, err := A.Open(identB, "ping", false, Priority(priority))
+		if !assert.NoError(err) {
+			return 0
+		}
+		defer c.Kill()
+		c.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+		var rtts []time.Duration
+		for i := 0; i < 30; i++ {
+			start := time.Now()
+			if !assert.NoError(c.WritePacket(lob.New([]byte("ping")))) {
+				return 0
+			}
+			pkt, err := c.ReadPacket()
+			if !assert.NoError(err) {
+				return 0
+			}
+			pkt.Free()
+			rtts = append(rtts, time.Since(start))
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		sort.Sort(durations(rtts))
+		return rtts[len(rtts)/2]
+	}
+
+	// let the bulk transfer fill the send queue
+	time.Sleep(500 * time.Millisecond)
+
+	low := measure(DefaultPriority)
+	high := measure(10)
+	t.Logf("median ping rtt: low priority=%s high priority=%s", low, high)
+
+	assert.True(high < 20*time.Millisecond, "high priority rtt %s", high)
+	assert.True(high*4 < low, "high priority rtt %s, low priority rtt %s", high, low)
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
@@ -32,6 +32,13 @@ type ExchangeHook struct {
 	OnOpened     func(*Endpoint, *Exchange) error
 	OnClosed     func(*Endpoint, *Exchange, error) error
 	OnDropPacket func(e *Endpoint, x *Exchange, msg []byte, pipe *Pipe, reason error) error
+	OnRotated    func(e *Endpoint, x *Exchange, rotation *KeyRotation) error
+
+	// OnUnreachable is called when the peer did not respond to a keepalive
+	// handshake within the unreachable timeout. OnReachable is called when it
+	// responds again.
+	OnUnreachable func(*Endpoint, *Exchange) error
+	OnReachable   func(*Endpoint, *Exchange) error
 }
 
 type ChannelHook struct {
@@ -135,6 +142,33 @@ func (s *ExchangeHooks) DropPacket(msg []byte, pipe *Pipe, reason error) error {
 	})
 }
 
+func (s *ExchangeHooks) Rotated(rotation *KeyRotation) error {
+	return s.trigger(func(o ExchangeHook) error {
+		if o.OnRotated == nil {
+			return nil
+		}
+		return o.OnRotated(s.endpoint, s.exchange, rotation)
+	})
+}
+
+func (s *ExchangeHooks) Unreachable() error {
+	return s.trigger(func(o ExchangeHook) error {
+		if o.OnUnreachable == nil {
+			return nil
+		}
+		return o.OnUnreachable(s.endpoint, s.exchange)
+	})
+}
+
+func (s *ExchangeHooks) Reachable() error {
+	return s.trigger(func(o ExchangeHook) error {
+		if o.OnReachable == nil {
+			return nil
+		}
+		return o.OnReachable(s.endpoint, s.exchange)
+	})
+}
+
 func (s *ChannelHooks) Opened() error {
 	return s.trigger(func(o ChannelHook) error {
 		if o.OnOpened == nil {
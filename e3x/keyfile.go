@@ -0,0 +1,192 @@
+package e3x
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/hashname"
+)
+
+// keyFile is the on-disk format of a key file. It is the same format
+// as the one produced by th-keygen.
+type keyFile struct {
+	Hashname  hashname.H            `json:"hashname,omitempty"`
+	Parts     cipherset.Parts       `json:"parts,omitempty"`
+	Keys      cipherset.PrivateKeys `json:"keys,omitempty"`
+	Encrypted json.RawMessage       `json:"encrypted,omitempty"`
+}
+
+// KeyFile loads the endpoint keys from the JSON file at path (as written by th-keygen).
+// When the file doesn't exist a new set of keys is generated and saved to path
+// (readable by the owner only). ErrConflictingKeys is returned when the keys
+// were already set by another option.
+func KeyFile(path string) EndpointOption {
+	return func(e *Endpoint) error {
+		if e.keys != nil && len(e.keys) > 0 {
+			return ErrConflictingKeys
+		}
+
+		keys, err := loadKeyFile(path, nil)
+		if os.IsNotExist(err) {
+			keys, err = generateKeyFile(path, nil)
+		}
+		if err != nil {
+			return err
+		}
+
+		return Keys(keys)(e)
+	}
+}
+
+// EncryptedKeyFile is like KeyFile but the private keys are protected with passphrase
+// (as written by th-keygen --encrypt).
+func EncryptedKeyFile(path string, passphrase []byte) EndpointOption {
+	return func(e *Endpoint) error {
+		if e.keys != nil && len(e.keys) > 0 {
+			return ErrConflictingKeys
+		}
+
+		keys, err := loadKeyFile(path, passphrase)
+		if os.IsNotExist(err) {
+			keys, err = generateKeyFile(path, passphrase)
+		}
+		if err != nil {
+			return err
+		}
+
+		return Keys(keys)(e)
+	}
+}
+
+func loadKeyFile(path string, passphrase []byte) (cipherset.Keys, error) {
+	var (
+		file keyFile
+	)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("e3x: invalid key file %s: %s", path, err)
+	}
+
+	if file.Encrypted != nil {
+		if passphrase == nil {
+			return nil, fmt.Errorf("e3x: key file %s is encrypted (use EncryptedKeyFile)", path)
+		}
+
+		file.Keys, err = cipherset.DecryptPrivateKeys(file.Encrypted, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("e3x: invalid key file %s: %s", path, err)
+		}
+	}
+
+	if len(file.Keys) == 0 {
+		return nil, fmt.Errorf("e3x: invalid key file %s: no keys", path)
+	}
+
+	keys := cipherset.Keys(file.Keys)
+	for csid, key := range keys {
+		if len(key.Private()) == 0 {
+			return nil, fmt.Errorf("e3x: invalid key file %s: missing private key for %x", path, csid)
+		}
+	}
+
+	hn, err := hashname.FromKeys(keys)
+	if err != nil {
+		return nil, fmt.Errorf("e3x: invalid key file %s: %s", path, err)
+	}
+
+	if file.Hashname != "" && file.Hashname != hn {
+		return nil, fmt.Errorf("e3x: invalid key file %s: hashname %s does not match the keys (expected %s)",
+			path, file.Hashname, hn)
+	}
+
+	return keys, nil
+}
+
+func generateKeyFile(path string, passphrase []byte) (cipherset.Keys, error) {
+	var (
+		file keyFile
+		err  error
+	)
+
+	keys, err := cipherset.GenerateKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	file.Keys = cipherset.PrivateKeys(keys)
+	file.Parts = hashname.PartsFromKeys(keys)
+	file.Hashname, err = hashname.FromIntermediates(file.Parts)
+	if err != nil {
+		return nil, err
+	}
+
+	if passphrase != nil {
+		file.Encrypted, err = cipherset.EncryptPrivateKeys(file.Keys, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		file.Keys = nil
+	}
+
+	data, err := json.MarshalIndent(&file, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeFileAtomic(path, data, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// writeFileAtomic writes data to a temporary file (in the same directory as path)
+// and moves it into place once it is completely written.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	tmpName := f.Name()
+	cleanup := func(err error) error {
+		f.Close()
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err = f.Chmod(perm); err != nil {
+		return cleanup(err)
+	}
+
+	if _, err = f.Write(data); err != nil {
+		return cleanup(err)
+	}
+
+	if err = f.Sync(); err != nil {
+		return cleanup(err)
+	}
+
+	if err = f.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err = os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package e3x
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestKeyFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "e3x-keyfile")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys.json")
+
+	a, err := Open(KeyFile(path), Transport(inproc.Config{}))
+	if !assert.NoError(err) {
+		return
+	}
+	a.Close()
+
+	info, err := os.Stat(path)
+	if assert.NoError(err) {
+		assert.Equal(os.FileMode(0600), info.Mode().Perm())
+	}
+
+	b, err := Open(KeyFile(path), Transport(inproc.Config{}))
+	if !assert.NoError(err) {
+		return
+	}
+	b.Close()
+
+	assert.Equal(a.LocalHashname(), b.LocalHashname())
+}
+
+func TestKeyFileHashnameMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "e3x-keyfile")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys.json")
+
+	_, err = generateKeyFile(path, nil)
+	if !assert.NoError(err) {
+		return
+	}
+
+	var file map[string]interface{}
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.NoError(json.Unmarshal(data, &file))
+	file["hashname"] = "jlde3gftwvd3o5ktkdxhqa3pweixuzemzyuyqrknrgxxqtxl3kvq"
+	data, err = json.Marshal(file)
+	assert.NoError(err)
+	assert.NoError(ioutil.WriteFile(path, data, 0600))
+
+	_, err = Open(KeyFile(path), Transport(inproc.Config{}))
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "does not match")
+	}
+}
+
+func TestEncryptedKeyFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "e3x-keyfile")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys.json")
+
+	a, err := Open(EncryptedKeyFile(path, []byte("secret")), Transport(inproc.Config{}))
+	if !assert.NoError(err) {
+		return
+	}
+	a.Close()
+
+	_, err = Open(KeyFile(path), Transport(inproc.Config{}))
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "is encrypted")
+	}
+
+	_, err = Open(EncryptedKeyFile(path, []byte("wrong")), Transport(inproc.Config{}))
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "invalid passphrase")
+	}
+
+	b, err := Open(EncryptedKeyFile(path, []byte("secret")), Transport(inproc.Config{}))
+	if !assert.NoError(err) {
+		return
+	}
+	b.Close()
+
+	assert.Equal(a.LocalHashname(), b.LocalHashname())
+}
+
+func TestConflictingKeyOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "e3x-keyfile")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys.json")
+
+	keys, err := cipherset.GenerateKeys()
+	if !assert.NoError(err) {
+		return
+	}
+
+	_, err = Open(Keys(keys), KeyFile(path), Transport(inproc.Config{}))
+	assert.Equal(ErrConflictingKeys, err)
+
+	_, err = Open(Keys(keys), EncryptedKeyFile(path, []byte("secret")), Transport(inproc.Config{}))
+	assert.Equal(ErrConflictingKeys, err)
+
+	fileKeys, err := generateKeyFile(path, nil)
+	if !assert.NoError(err) {
+		return
+	}
+
+	_, err = Open(KeyFile(path), Keys(keys), Transport(inproc.Config{}))
+	assert.Equal(ErrConflictingKeys, err)
+
+	// the same keys twice don't conflict
+	e, err := Open(KeyFile(path), Keys(fileKeys), Transport(inproc.Config{}))
+	if assert.NoError(err) {
+		e.Close()
+	}
+}
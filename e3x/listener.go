@@ -2,6 +2,7 @@ package e3x
 
 import (
 	"container/list"
+	"context"
 	"errors"
 	"io"
 	"net"
@@ -21,6 +22,7 @@ type listenerSet struct {
 	mtx       sync.RWMutex
 	parent    *listenerSet
 	listeners map[string]*Listener
+	closed    bool
 }
 
 var (
@@ -58,6 +60,10 @@ func (set *listenerSet) Get(typ string) *Listener {
 		return nil
 	}
 
+	if set.isClosed() {
+		return nil
+	}
+
 	set.mtx.RLock()
 	if set.listeners != nil {
 		l = set.listeners[typ]
@@ -71,6 +77,41 @@ func (set *listenerSet) Get(typ string) *Listener {
 	return l
 }
 
+func (set *listenerSet) isClosed() bool {
+	if set == nil {
+		return false
+	}
+
+	set.mtx.RLock()
+	closed := set.closed
+	set.mtx.RUnlock()
+
+	if closed {
+		return true
+	}
+
+	return set.parent.isClosed()
+}
+
+// Close closes all the listeners in the set. Once closed the set (and all the sets
+// inheriting from it) will no longer accept new channels.
+func (set *listenerSet) Close() {
+	var (
+		listeners []*Listener
+	)
+
+	set.mtx.Lock()
+	set.closed = true
+	for _, l := range set.listeners {
+		listeners = append(listeners, l)
+	}
+	set.mtx.Unlock()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
 func (set *listenerSet) remove(typ string) {
 	set.mtx.Lock()
 	defer set.mtx.Unlock()
@@ -92,7 +133,7 @@ func (set *listenerSet) dropChannel(c *Channel, reason error) {
 	}
 }
 
-func (set *listenerSet) Listen(typ string, reliable bool) *Listener {
+func (set *listenerSet) Listen(typ string, reliable bool, options ...ChannelOption) *Listener {
 	set.mtx.Lock()
 	defer set.mtx.Unlock()
 
@@ -105,6 +146,12 @@ func (set *listenerSet) Listen(typ string, reliable bool) *Listener {
 	}
 
 	l := newListener(set, typ, reliable, 0)
+	l.channelOptions = options
+	if set.closed {
+		l.closed = true
+		return l
+	}
+
 	set.listeners[typ] = l
 	return l
 }
@@ -113,9 +160,10 @@ type Listener struct {
 	mtx sync.Mutex
 	cnd *sync.Cond
 
-	set         *listenerSet
-	channelType string
-	reliable    bool
+	set            *listenerSet
+	channelType    string
+	reliable       bool
+	channelOptions []ChannelOption
 
 	closed         bool
 	maxBacklogSize int
@@ -175,11 +223,42 @@ func (l *Listener) Addr() net.Addr {
 	return l.set.Addr()
 }
 
+// Accept implements the net.Listener Accept method. A reliable listener returns
+// a *Stream (like AcceptStream); an unreliable listener returns a *Channel which
+// keeps the packet boundaries (like a UDP connection).
 func (l *Listener) Accept() (net.Conn, error) {
-	return l.AcceptChannel()
+	if l != nil && !l.reliable {
+		return l.AcceptChannel()
+	}
+	return l.AcceptStream()
+}
+
+// AcceptStream accepts a reliable channel and wraps it in a Stream.
+func (l *Listener) AcceptStream() (*Stream, error) {
+	for {
+		c, err := l.AcceptChannel()
+		if err != nil {
+			return nil, err
+		}
+
+		s, err := newStream(c)
+		if err != nil {
+			// the remote endpoint already gave up on the channel
+			c.Kill()
+			continue
+		}
+
+		return s, nil
+	}
 }
 
 func (l *Listener) AcceptChannel() (*Channel, error) {
+	return l.AcceptChannelContext(context.Background())
+}
+
+// AcceptChannelContext is like AcceptChannel but returns ctx.Err() when ctx is done
+// before a channel was accepted.
+func (l *Listener) AcceptChannelContext(ctx context.Context) (*Channel, error) {
 	if l == nil {
 		return nil, io.EOF
 	}
@@ -187,8 +266,14 @@ func (l *Listener) AcceptChannel() (*Channel, error) {
 	l.mtx.Lock()
 	defer l.mtx.Unlock()
 
+	stop := wakeOnDone(ctx, &l.mtx, l.cnd)
+	defer stop()
+
 WAIT:
 	for !l.closed && l.backlogSize == 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		l.cnd.Wait()
 	}
 
@@ -0,0 +1,287 @@
+package e3x
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x/metrics"
+)
+
+// Metrics sets the metrics sink of the endpoint. All the metrics are labelled
+// with the hashname of the endpoint.
+func Metrics(m metrics.Metrics) EndpointOption {
+	return func(e *Endpoint) error {
+		e.metricsSink = m
+		return nil
+	}
+}
+
+// endpointMetrics holds the instruments used by an endpoint, its exchanges and
+// their channels. The instruments can be moved to another sink (see relabel).
+type endpointMetrics struct {
+	mtx  sync.Mutex
+	m    metrics.Metrics
+	refs []*instrumentRef
+
+	handshakesSent     metrics.Counter
+	handshakesReceived metrics.Counter
+
+	channelPacketsSent      metrics.Counter
+	channelPacketsReceived  metrics.Counter
+	channelRetransmits      metrics.Counter
+	channelMissed           metrics.Counter
+	channelRetriesExceeded  metrics.Counter
+	channelFragmentsExpired metrics.Counter
+	channelAcksSentInline   metrics.Counter
+	channelAcksSentAdHoc    metrics.Counter
+	channelAcksRcvdInline   metrics.Counter
+	channelAcksRcvdAdHoc    metrics.Counter
+	channelRoundTripTime    metrics.Histogram
+
+	packetsDropped  *instrumentVec // by reason
+	bytesReceived   *instrumentVec // by transport
+	bytesSent       *instrumentVec // by transport
+	pathLatencies   *instrumentVec // by transport
+	exchanges       *instrumentVec // by state
+	exchangesClosed *instrumentVec // by state
+}
+
+// discardMetrics is used by exchanges and channels which don't belong to an
+// endpoint.
+var discardMetrics = newEndpointMetrics(metrics.Discard)
+
+func newEndpointMetrics(m metrics.Metrics) *endpointMetrics {
+	if m == nil {
+		m = metrics.Discard
+	}
+
+	em := &endpointMetrics{m: m}
+
+	em.handshakesSent = em.ref(kindCounter, "e3x_handshakes_total", metrics.Labels{"direction": "out"})
+	em.handshakesReceived = em.ref(kindCounter, "e3x_handshakes_total", metrics.Labels{"direction": "in"})
+
+	em.channelPacketsSent = em.ref(kindCounter, "e3x_channel_packets_sent_total", nil)
+	em.channelPacketsReceived = em.ref(kindCounter, "e3x_channel_packets_received_total", nil)
+	em.channelRetransmits = em.ref(kindCounter, "e3x_channel_retransmits_total", nil)
+	em.channelMissed = em.ref(kindCounter, "e3x_channel_packets_missed_total", nil)
+	em.channelRetriesExceeded = em.ref(kindCounter, "e3x_channel_retries_exceeded_total", nil)
+	em.channelFragmentsExpired = em.ref(kindCounter, "e3x_channel_fragments_expired_total", nil)
+	em.channelAcksSentInline = em.ref(kindCounter, "e3x_channel_acks_sent_total", metrics.Labels{"kind": "inline"})
+	em.channelAcksSentAdHoc = em.ref(kindCounter, "e3x_channel_acks_sent_total", metrics.Labels{"kind": "ad-hoc"})
+	em.channelAcksRcvdInline = em.ref(kindCounter, "e3x_channel_acks_received_total", metrics.Labels{"kind": "inline"})
+	em.channelAcksRcvdAdHoc = em.ref(kindCounter, "e3x_channel_acks_received_total", metrics.Labels{"kind": "ad-hoc"})
+	em.channelRoundTripTime = em.ref(kindHistogram, "e3x_channel_rtt_seconds", nil)
+
+	em.packetsDropped = newInstrumentVec(em, kindCounter, "e3x_packets_dropped_total", "reason", nil)
+	em.bytesReceived = newInstrumentVec(em, kindCounter, "e3x_transport_bytes_total", "transport", metrics.Labels{"direction": "in"})
+	em.bytesSent = newInstrumentVec(em, kindCounter, "e3x_transport_bytes_total", "transport", metrics.Labels{"direction": "out"})
+	em.pathLatencies = newInstrumentVec(em, kindHistogram, "e3x_path_latency_seconds", "transport", nil)
+	em.exchanges = newInstrumentVec(em, kindGauge, "e3x_exchanges", "state", nil)
+	em.exchangesClosed = newInstrumentVec(em, kindCounter, "e3x_exchanges_closed_total", "state", nil)
+
+	return em
+}
+
+// ref returns a new instrument of the current sink.
+func (m *endpointMetrics) ref(kind instrumentKind, name string, labels metrics.Labels) *instrumentRef {
+	r := &instrumentRef{kind: kind, name: name, labels: labels}
+
+	m.mtx.Lock()
+	r.resolve(m.m)
+	m.refs = append(m.refs, r)
+	m.mtx.Unlock()
+
+	return r
+}
+
+// relabel moves all the instruments to m (like the sink with the new hashname
+// label after a key rotation). Counters and histograms start over while the
+// values of the gauges are moved.
+func (m *endpointMetrics) relabel(sink metrics.Metrics) {
+	if sink == nil {
+		sink = metrics.Discard
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.m = sink
+	for _, r := range m.refs {
+		r.resolve(sink)
+	}
+}
+
+// dropped counts a dropped packet (or handshake).
+func (m *endpointMetrics) dropped(reason string) {
+	m.packetsDropped.counter(reason).Add(1)
+}
+
+// exchangeState tracks the number of exchanges in the dialing, idle and active
+// states and counts the closed exchanges.
+func (m *endpointMetrics) exchangeState(from, to ExchangeState) {
+	if from == to {
+		return
+	}
+
+	if from != ExchangeInitialising && !from.IsClosed() {
+		m.exchanges.gauge(from.String()).Add(-1)
+	}
+	if to.IsClosed() {
+		m.exchangesClosed.counter(to.String()).Add(1)
+	} else if to != ExchangeInitialising {
+		m.exchanges.gauge(to.String()).Add(1)
+	}
+}
+
+// pathLatency records the handshake round trip time over a path.
+func (m *endpointMetrics) pathLatency(addr net.Addr, d time.Duration) {
+	m.pathLatencies.histogram(addr.Network()).Observe(d.Seconds())
+}
+
+func (m *endpointMetrics) bytesIn(addr net.Addr, n int) {
+	if addr == nil {
+		return
+	}
+	m.bytesReceived.counter(addr.Network()).Add(float64(n))
+}
+
+func (m *endpointMetrics) bytesOut(addr net.Addr, n int) {
+	if addr == nil {
+		return
+	}
+	m.bytesSent.counter(addr.Network()).Add(float64(n))
+}
+
+type instrumentKind uint8
+
+const (
+	kindCounter instrumentKind = iota
+	kindGauge
+	kindHistogram
+)
+
+// instrumentVec caches the instruments of a metric by the value of one of its
+// labels. Looking up an instrument in the sink formats its labels, which is
+// too expensive to do for every packet.
+type instrumentVec struct {
+	m      *endpointMetrics
+	kind   instrumentKind
+	name   string
+	label  string
+	labels metrics.Labels
+
+	mtx         sync.RWMutex
+	instruments map[string]*instrumentRef
+}
+
+func newInstrumentVec(m *endpointMetrics, kind instrumentKind, name, label string, labels metrics.Labels) *instrumentVec {
+	return &instrumentVec{
+		m:           m,
+		kind:        kind,
+		name:        name,
+		label:       label,
+		labels:      labels,
+		instruments: make(map[string]*instrumentRef),
+	}
+}
+
+func (v *instrumentVec) counter(value string) metrics.Counter {
+	return v.get(value)
+}
+
+func (v *instrumentVec) gauge(value string) metrics.Gauge {
+	return v.get(value)
+}
+
+func (v *instrumentVec) histogram(value string) metrics.Histogram {
+	return v.get(value)
+}
+
+func (v *instrumentVec) get(value string) *instrumentRef {
+	v.mtx.RLock()
+	r := v.instruments[value]
+	v.mtx.RUnlock()
+	if r != nil {
+		return r
+	}
+
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	if r = v.instruments[value]; r != nil {
+		return r
+	}
+
+	labels := make(metrics.Labels, len(v.labels)+1)
+	for k, x := range v.labels {
+		labels[k] = x
+	}
+	labels[v.label] = value
+
+	r = v.m.ref(v.kind, v.name, labels)
+	v.instruments[value] = r
+	return r
+}
+
+// instrumentRef is an instrument which can be moved to another sink.
+type instrumentRef struct {
+	kind   instrumentKind
+	name   string
+	labels metrics.Labels
+	v      atomic.Value // instrumentBox
+
+	// gauges only; the value is moved along with the gauge
+	mtx   sync.Mutex
+	value float64
+}
+
+type instrumentBox struct {
+	instrument interface{}
+}
+
+func (r *instrumentRef) load() interface{} {
+	return r.v.Load().(instrumentBox).instrument
+}
+
+func (r *instrumentRef) resolve(m metrics.Metrics) {
+	switch r.kind {
+	case kindCounter:
+		r.v.Store(instrumentBox{m.Counter(r.name, r.labels)})
+	case kindHistogram:
+		r.v.Store(instrumentBox{m.Histogram(r.name, r.labels)})
+	case kindGauge:
+		r.mtx.Lock()
+		if box, ok := r.v.Load().(instrumentBox); ok {
+			box.instrument.(metrics.Gauge).Add(-r.value)
+		}
+		g := m.Gauge(r.name, r.labels)
+		g.Add(r.value)
+		r.v.Store(instrumentBox{g})
+		r.mtx.Unlock()
+	}
+}
+
+func (r *instrumentRef) Add(delta float64) {
+	if r.kind != kindGauge {
+		r.load().(metrics.Counter).Add(delta)
+		return
+	}
+
+	r.mtx.Lock()
+	r.value += delta
+	r.load().(metrics.Gauge).Add(delta)
+	r.mtx.Unlock()
+}
+
+func (r *instrumentRef) Set(v float64) {
+	r.mtx.Lock()
+	r.value = v
+	r.load().(metrics.Gauge).Set(v)
+	r.mtx.Unlock()
+}
+
+func (r *instrumentRef) Observe(v float64) {
+	r.load().(metrics.Histogram).Observe(v)
+}
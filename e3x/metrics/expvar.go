@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+)
+
+// Expvar records metrics and publishes them as a single expvar variable. The
+// variable is a JSON object which maps the series (name{labels}) to their
+// values. Histograms are reported as objects with a count, a sum and the
+// cumulative bucket counts.
+type Expvar struct {
+	registry
+}
+
+var (
+	_ Metrics    = (*Expvar)(nil)
+	_ expvar.Var = (*Expvar)(nil)
+)
+
+// NewExpvar publishes a new Expvar adapter as the expvar variable name. When
+// an Expvar adapter was already published under name it is returned instead
+// (so multiple endpoints in one process can share it).
+func NewExpvar(name string) *Expvar {
+	if v, ok := expvar.Get(name).(*Expvar); ok {
+		return v
+	}
+
+	v := &Expvar{}
+	expvar.Publish(name, v)
+	return v
+}
+
+// String implements expvar.Var.
+func (e *Expvar) String() string {
+	var (
+		buf   bytes.Buffer
+		first = true
+	)
+
+	buf.WriteByte('{')
+	e.each(func(f *family, s *series) {
+		if !first {
+			buf.WriteString(", ")
+		}
+		first = false
+
+		key := f.name
+		if s.labels != "" {
+			key += "{" + s.labels + "}"
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteString(": ")
+
+		if s.hist == nil {
+			buf.WriteString(formatJSONFloat(s.value.Load()))
+			return
+		}
+
+		s.hist.mtx.Lock()
+		buf.WriteString(`{"count": ` + formatJSONFloat(float64(s.hist.count)))
+		buf.WriteString(`, "sum": ` + formatJSONFloat(s.hist.sum))
+		buf.WriteString(`, "buckets": {`)
+		for i, le := range s.hist.buckets {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(`"` + formatFloat(le) + `": ` + formatJSONFloat(float64(s.hist.counts[i])))
+		}
+		buf.WriteString("}}")
+		s.hist.mtx.Unlock()
+	})
+	buf.WriteByte('}')
+
+	return buf.String()
+}
+
+func formatJSONFloat(v float64) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// NaN and Inf are not valid JSON
+		return "null"
+	}
+	return string(data)
+}
@@ -0,0 +1,118 @@
+// Package metrics defines the interface through which an endpoint reports its
+// metrics. Adapters for expvar and for the Prometheus text format are
+// included.
+//
+// Metric names follow the Prometheus conventions (for example
+// e3x_channel_packets_sent_total). The endpoint labels all its metrics with
+// its hashname.
+package metrics
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Labels are the dimensions of a metric.
+type Labels map[string]string
+
+// Metrics creates (or looks up) the instruments of a metric. Calls with the
+// same name and labels must return instruments that report on the same series.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	Counter(name string, labels Labels) Counter
+	Gauge(name string, labels Labels) Gauge
+	Histogram(name string, labels Labels) Histogram
+}
+
+// Counter is a monotonically increasing value.
+type Counter interface {
+	Add(delta float64)
+}
+
+// Gauge is a value that can go up and down.
+type Gauge interface {
+	Set(v float64)
+	Add(delta float64)
+}
+
+// Histogram samples observations (like latencies) into buckets.
+type Histogram interface {
+	Observe(v float64)
+}
+
+// DefaultBuckets are the upper bounds of the histogram buckets (in seconds).
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Discard is a Metrics implementation that drops all values.
+var Discard Metrics = discard{}
+
+type discard struct{}
+
+func (discard) Counter(string, Labels) Counter     { return discard{} }
+func (discard) Gauge(string, Labels) Gauge         { return discard{} }
+func (discard) Histogram(string, Labels) Histogram { return discard{} }
+func (discard) Add(float64)                        {}
+func (discard) Set(float64)                        {}
+func (discard) Observe(float64)                    {}
+
+// WithLabels returns a Metrics which adds labels to all the metrics created by m.
+func WithLabels(m Metrics, labels Labels) Metrics {
+	if m == nil {
+		return Discard
+	}
+	if len(labels) == 0 {
+		return m
+	}
+	return &labelled{m, labels}
+}
+
+type labelled struct {
+	m      Metrics
+	labels Labels
+}
+
+func (l *labelled) merge(labels Labels) Labels {
+	merged := make(Labels, len(l.labels)+len(labels))
+	for k, v := range l.labels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged
+}
+
+func (l *labelled) Counter(name string, labels Labels) Counter {
+	return l.m.Counter(name, l.merge(labels))
+}
+
+func (l *labelled) Gauge(name string, labels Labels) Gauge {
+	return l.m.Gauge(name, l.merge(labels))
+}
+
+func (l *labelled) Histogram(name string, labels Labels) Histogram {
+	return l.m.Histogram(name, l.merge(labels))
+}
+
+// atomicFloat is a float64 which can be updated atomically.
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+func (f *atomicFloat) Store(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		v := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&f.bits, old, v) {
+			return
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusTextFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewPrometheus()
+	m := WithLabels(p, Labels{"hashname": "abc"})
+
+	m.Counter("test_packets_total", Labels{"reason": `bad "header"`}).Add(2)
+	m.Counter("test_packets_total", Labels{"reason": `bad "header"`}).Add(1)
+	m.Counter("test_packets_total", nil).Add(-1) // ignored
+	m.Gauge("test_exchanges", Labels{"state": "idle"}).Add(2)
+	m.Gauge("test_exchanges", Labels{"state": "idle"}).Add(-1)
+	m.Histogram("test_latency_seconds", nil).Observe(0.02)
+	m.Histogram("test_latency_seconds", nil).Observe(3)
+
+	var buf bytes.Buffer
+	_, err := p.WriteTo(&buf)
+	assert.NoError(err)
+	assert.Equal(`# TYPE test_exchanges gauge
+test_exchanges{hashname="abc",state="idle"} 1
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{hashname="abc",le="0.001"} 0
+test_latency_seconds_bucket{hashname="abc",le="0.005"} 0
+test_latency_seconds_bucket{hashname="abc",le="0.01"} 0
+test_latency_seconds_bucket{hashname="abc",le="0.025"} 1
+test_latency_seconds_bucket{hashname="abc",le="0.05"} 1
+test_latency_seconds_bucket{hashname="abc",le="0.1"} 1
+test_latency_seconds_bucket{hashname="abc",le="0.25"} 1
+test_latency_seconds_bucket{hashname="abc",le="0.5"} 1
+test_latency_seconds_bucket{hashname="abc",le="1"} 1
+test_latency_seconds_bucket{hashname="abc",le="2.5"} 1
+test_latency_seconds_bucket{hashname="abc",le="5"} 2
+test_latency_seconds_bucket{hashname="abc",le="10"} 2
+test_latency_seconds_bucket{hashname="abc",le="+Inf"} 2
+test_latency_seconds_sum{hashname="abc"} 3.02
+test_latency_seconds_count{hashname="abc"} 2
+# TYPE test_packets_total counter
+test_packets_total{hashname="abc"} 0
+test_packets_total{hashname="abc",reason="bad \"header\""} 3
+`, buf.String())
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(buf.String(), w.Body.String())
+	assert.Contains(w.Header().Get("Content-Type"), "text/plain")
+}
+
+func TestMetricTypeMismatch(t *testing.T) {
+	p := NewPrometheus()
+	p.Counter("test_total", nil)
+	assert.Panics(t, func() { p.Gauge("test_total", nil) })
+}
+
+func TestExpvar(t *testing.T) {
+	assert := assert.New(t)
+
+	e := NewExpvar("test_metrics")
+	assert.True(e == NewExpvar("test_metrics"), "adapters must be shared by name")
+	assert.True(expvar.Get("test_metrics") == e)
+
+	m := WithLabels(e, Labels{"hashname": "abc"})
+	m.Counter("test_packets_total", nil).Add(5)
+	m.Histogram("test_latency_seconds", nil).Observe(0.5)
+
+	var v map[string]interface{}
+	if !assert.NoError(json.Unmarshal([]byte(e.String()), &v)) {
+		return
+	}
+	assert.Equal(5.0, v[`test_packets_total{hashname="abc"}`])
+
+	hist, _ := v[`test_latency_seconds{hashname="abc"}`].(map[string]interface{})
+	if assert.NotNil(hist) {
+		assert.Equal(1.0, hist["count"])
+		assert.Equal(0.5, hist["sum"])
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	m := WithLabels(nil, Labels{"hashname": "abc"})
+	m.Counter("test_total", nil).Add(1)
+	m.Gauge("test", nil).Set(1)
+	m.Histogram("test_seconds", nil).Observe(1)
+}
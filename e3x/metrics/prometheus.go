@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+)
+
+// Prometheus records metrics and exposes them in the Prometheus text
+// exposition format. It can be mounted as the /metrics handler of an HTTP
+// server.
+type Prometheus struct {
+	registry
+}
+
+var _ Metrics = (*Prometheus)(nil)
+
+// NewPrometheus returns an empty Prometheus adapter.
+func NewPrometheus() *Prometheus {
+	return &Prometheus{}
+}
+
+// WriteTo writes all the metrics in the Prometheus text format to w.
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	var (
+		cw   = &countingWriter{w: w}
+		bw   = bufio.NewWriter(cw)
+		last *family
+	)
+
+	p.each(func(f *family, s *series) {
+		if f != last {
+			last = f
+			bw.WriteString("# TYPE " + f.name + " " + f.kind.String() + "\n")
+		}
+
+		if s.hist == nil {
+			writeSample(bw, f.name, s.labels, "", formatFloat(s.value.Load()))
+			return
+		}
+
+		s.hist.mtx.Lock()
+		for i, le := range s.hist.buckets {
+			writeSample(bw, f.name+"_bucket", s.labels, `le="`+formatFloat(le)+`"`, formatFloat(float64(s.hist.counts[i])))
+		}
+		writeSample(bw, f.name+"_bucket", s.labels, `le="+Inf"`, formatFloat(float64(s.hist.count)))
+		writeSample(bw, f.name+"_sum", s.labels, "", formatFloat(s.hist.sum))
+		writeSample(bw, f.name+"_count", s.labels, "", formatFloat(float64(s.hist.count)))
+		s.hist.mtx.Unlock()
+	})
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP implements http.Handler.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	p.WriteTo(w)
+}
+
+func writeSample(w *bufio.Writer, name, labels, extra, value string) {
+	w.WriteString(name)
+	if labels != "" || extra != "" {
+		w.WriteByte('{')
+		w.WriteString(labels)
+		if labels != "" && extra != "" {
+			w.WriteByte(',')
+		}
+		w.WriteString(extra)
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(value)
+	w.WriteByte('\n')
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,192 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type kind uint8
+
+const (
+	kindCounter kind = iota
+	kindGauge
+	kindHistogram
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindCounter:
+		return "counter"
+	case kindGauge:
+		return "gauge"
+	default:
+		return "histogram"
+	}
+}
+
+// registry holds the series of the metrics recorded by the adapters.
+type registry struct {
+	mtx      sync.RWMutex
+	families map[string]*family
+}
+
+type family struct {
+	name   string
+	kind   kind
+	series map[string]*series
+}
+
+type series struct {
+	labels string // canonical (sorted and quoted) labels
+	value  atomicFloat
+	hist   *histogram
+}
+
+type histogram struct {
+	mtx     sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+type counter struct{ s *series }
+type gauge struct{ s *series }
+
+func (c counter) Add(delta float64) {
+	if delta < 0 {
+		return // counters never decrease
+	}
+	c.s.value.Add(delta)
+}
+
+func (g gauge) Set(v float64)     { g.s.value.Store(v) }
+func (g gauge) Add(delta float64) { g.s.value.Add(delta) }
+
+func (h *histogram) Observe(v float64) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	for i, le := range h.buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (r *registry) Counter(name string, labels Labels) Counter {
+	return counter{r.get(name, kindCounter, labels)}
+}
+
+func (r *registry) Gauge(name string, labels Labels) Gauge {
+	return gauge{r.get(name, kindGauge, labels)}
+}
+
+func (r *registry) Histogram(name string, labels Labels) Histogram {
+	return r.get(name, kindHistogram, labels).hist
+}
+
+func (r *registry) get(name string, k kind, labels Labels) *series {
+	key := formatLabels(labels)
+
+	r.mtx.RLock()
+	f := r.families[name]
+	var s *series
+	if f != nil && f.kind == k {
+		s = f.series[key]
+	}
+	r.mtx.RUnlock()
+	if s != nil {
+		return s
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.families == nil {
+		r.families = make(map[string]*family)
+	}
+
+	f = r.families[name]
+	if f == nil {
+		f = &family{name: name, kind: k, series: make(map[string]*series)}
+		r.families[name] = f
+	}
+	if f.kind != k {
+		panic(fmt.Sprintf("metrics: %s is a %s (not a %s)", name, f.kind, k))
+	}
+
+	s = f.series[key]
+	if s == nil {
+		s = &series{labels: key}
+		if k == kindHistogram {
+			s.hist = &histogram{
+				buckets: DefaultBuckets,
+				counts:  make([]uint64, len(DefaultBuckets)),
+			}
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// each calls fn for all the series in a stable order.
+func (r *registry) each(fn func(f *family, s *series)) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := r.families[name]
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fn(f, f.series[key])
+		}
+	}
+}
+
+// formatLabels returns the labels in the Prometheus text format
+// (without braces): a="x",b="y"
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + quoteLabel(labels[name])
+	}
+	return strings.Join(parts, ",")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
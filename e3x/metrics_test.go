@@ -0,0 +1,132 @@
+package e3x
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x/metrics"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/logs"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestEndpointMetrics(t *testing.T) {
+	logs.ResetLogger()
+
+	assert := assert.New(t)
+	m := metrics.NewPrometheus()
+
+	var endpoints [2]*Endpoint
+	for i := range endpoints {
+		e, err := Open(Transport(inproc.Config{}), Metrics(m), Log(nil))
+		if !assert.NoError(err) {
+			return
+		}
+		defer e.Close()
+		endpoints[i] = e
+	}
+	A, B := endpoints[0], endpoints[1]
+
+	identB, err := B.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		c, err := B.Listen("ping", false).AcceptChannel()
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Kill()
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		pkt, err := c.ReadPacket()
+		if assert.NoError(err) {
+			assert.NoError(c.WritePacket(pkt))
+		}
+	}()
+
+	c, err := A.Open(identB, "ping", false)
+	if !assert.NoError(err) {
+		return
+	}
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	assert.NoError(c.WritePacket(lob.New([]byte("ping"))))
+	pkt, err := c.ReadPacket()
+	if assert.NoError(err) {
+		pkt.Free()
+	}
+	<-served
+
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	out := buf.String()
+
+	for _, e := range endpoints {
+		hn := `hashname="` + string(e.LocalHashname()) + `"`
+		assert.Contains(out, `e3x_handshakes_total{direction="in",`+hn+`} `)
+		assert.Contains(out, `e3x_handshakes_total{direction="out",`+hn+`} `)
+		assert.Contains(out, `e3x_transport_bytes_total{direction="in",`+hn+`,transport="inproc"}`)
+		assert.Contains(out, `e3x_transport_bytes_total{direction="out",`+hn+`,transport="inproc"}`)
+		assert.Contains(out, `e3x_channel_packets_received_total{`+hn+`} 1`)
+		assert.Contains(out, `e3x_channel_packets_sent_total{`+hn+`} 1`)
+	}
+
+	hn := `hashname="` + string(A.LocalHashname()) + `"`
+	assert.Contains(out, `e3x_exchanges{`+hn+`,state="active"} 1`)
+
+	// closing the endpoint breaks its exchanges
+	c.Kill()
+	A.Close()
+	buf.Reset()
+	m.WriteTo(&buf)
+	assert.Contains(buf.String(), `e3x_exchanges{`+hn+`,state="active"} 0`)
+	assert.Contains(buf.String(), `e3x_exchanges_closed_total{`+hn+`,state="broken"} 1`)
+}
+
+func TestEndpointMetricsHotPath(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newEndpointMetrics(metrics.WithLabels(metrics.NewPrometheus(),
+		metrics.Labels{"hashname": "test"}))
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4000}
+
+	m.bytesIn(addr, 1)
+	m.bytesOut(addr, 1)
+	m.dropped("duplicate packet")
+
+	// the instruments are resolved once (per transport and reason)
+	allocs := testing.AllocsPerRun(100, func() {
+		m.bytesIn(addr, 1)
+		m.bytesOut(addr, 1)
+		m.dropped("duplicate packet")
+	})
+	assert.Equal(0.0, allocs)
+}
+
+func TestEndpointMetricsRelabel(t *testing.T) {
+	assert := assert.New(t)
+	m := metrics.NewPrometheus()
+
+	em := newEndpointMetrics(metrics.WithLabels(m, metrics.Labels{"hashname": "old"}))
+	em.exchanges.gauge("open").Add(2)
+	em.channelPacketsSent.Add(1)
+
+	em.relabel(metrics.WithLabels(m, metrics.Labels{"hashname": "new"}))
+	em.exchanges.gauge("open").Add(-1)
+	em.channelPacketsSent.Add(1)
+
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	out := buf.String()
+
+	assert.Contains(out, `e3x_exchanges{hashname="old",state="open"} 0`)
+	assert.Contains(out, `e3x_exchanges{hashname="new",state="open"} 1`)
+	assert.Contains(out, `e3x_channel_packets_sent_total{hashname="old"} 1`)
+	assert.Contains(out, `e3x_channel_packets_sent_total{hashname="new"} 1`)
+}
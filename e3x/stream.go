@@ -0,0 +1,193 @@
+package e3x
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+var (
+	_ net.Conn = (*Stream)(nil)
+)
+
+// Stream is a byte stream on top of a reliable channel. Writes are fragmented
+// into packets which fit the path of the exchange (see Channel.MaxPacketSize)
+// and reads reassemble the packets into the caller's buffer (short buffers are
+// allowed). Both sides of a stream
+// can be closed independently (see CloseWrite).
+type Stream struct {
+	c *Channel
+
+	rmtx sync.Mutex
+	rbuf []byte
+	roff int
+	rerr error
+
+	wmtx sync.Mutex
+}
+
+// newStream wraps c in a Stream. A channel must be used as a stream on both
+// ends; the client sends an empty packet to open the channel and the server
+// responds with an empty packet. After that either side can read or write
+// first.
+func newStream(c *Channel) (*Stream, error) {
+	s := &Stream{c: c}
+
+	if !c.serverside {
+		err := c.WritePacket(&lob.Packet{})
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	// the initial packet is already buffered by the channel
+	pkt, err := c.ReadPacket()
+	if err != nil {
+		s.rerr = err
+		return s, nil
+	}
+	s.rbuf = pkt.Body(s.rbuf[:0])
+	pkt.Free()
+
+	err = c.WritePacket(&lob.Packet{})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// OpenStream opens a reliable channel of type typ and wraps it in a Stream. The
+// remote endpoint must accept it with Listener.AcceptStream (or Accept).
+func (x *Exchange) OpenStream(typ string, options ...ChannelOption) (*Stream, error) {
+	c, err := x.Open(typ, true, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newStream(c)
+	if err != nil {
+		c.Kill()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// OpenStream dials the exchange of i and opens a Stream of type typ.
+func (e *Endpoint) OpenStream(i Identifier, typ string, options ...ChannelOption) (*Stream, error) {
+	x, err := e.Dial(i)
+	if err != nil {
+		return nil, err
+	}
+
+	return x.OpenStream(typ, options...)
+}
+
+// Channel returns the underlying channel.
+func (s *Stream) Channel() *Channel {
+	return s.c
+}
+
+// Read implements the net.Conn Read method. io.EOF is returned after the remote
+// endpoint closed its write side.
+func (s *Stream) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	s.rmtx.Lock()
+	defer s.rmtx.Unlock()
+
+	for s.roff == len(s.rbuf) {
+		if s.rerr != nil {
+			return 0, s.rerr
+		}
+
+		pkt, err := s.c.ReadPacket()
+		if err != nil {
+			if err == io.EOF {
+				// a closed read side stays closed
+				s.rerr = err
+			}
+			return 0, err
+		}
+
+		// empty packets are only used to open the stream
+		s.rbuf = pkt.Body(s.rbuf[:0])
+		s.roff = 0
+		pkt.Free()
+	}
+
+	n := copy(b, s.rbuf[s.roff:])
+	s.roff += n
+	return n, nil
+}
+
+// Write implements the net.Conn Write method.
+func (s *Stream) Write(b []byte) (int, error) {
+	s.wmtx.Lock()
+	defer s.wmtx.Unlock()
+
+	var n int
+	for len(b) > 0 {
+		chunk := b
+		if size := s.c.MaxPacketSize(); len(chunk) > size {
+			chunk = chunk[:size]
+		}
+
+		err := s.c.WritePacket(lob.New(chunk))
+		if err != nil {
+			return n, err
+		}
+
+		n += len(chunk)
+		b = b[len(chunk):]
+	}
+
+	return n, nil
+}
+
+// CloseWrite closes the write side of the stream. The remote endpoint reads
+// io.EOF once all the data was received while this side can keep reading.
+func (s *Stream) CloseWrite() error {
+	s.wmtx.Lock()
+	defer s.wmtx.Unlock()
+
+	return s.c.CloseWrite()
+}
+
+// Close closes both sides of the stream. Unread data is discarded and Close waits
+// until the remote endpoint closed its write side (or the close timeout is reached).
+func (s *Stream) Close() error {
+	return s.c.Close()
+}
+
+// LocalAddr implements the net.Conn LocalAddr method.
+func (s *Stream) LocalAddr() net.Addr {
+	return s.c.LocalAddr()
+}
+
+// RemoteAddr implements the net.Conn RemoteAddr method.
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.c.RemoteAddr()
+}
+
+// SetDeadline implements the net.Conn SetDeadline method.
+func (s *Stream) SetDeadline(d time.Time) error {
+	return s.c.SetDeadline(d)
+}
+
+// SetReadDeadline implements the net.Conn SetReadDeadline method.
+func (s *Stream) SetReadDeadline(d time.Time) error {
+	return s.c.SetReadDeadline(d)
+}
+
+// SetWriteDeadline implements the net.Conn SetWriteDeadline method.
+func (s *Stream) SetWriteDeadline(d time.Time) error {
+	return s.c.SetWriteDeadline(d)
+}
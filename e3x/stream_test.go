@@ -0,0 +1,198 @@
+package e3x
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+func TestStreamEchoHalfClose(t *testing.T) {
+	assert := assert.New(t)
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		identB, err := B.LocalIdentity()
+		if !assert.NoError(err) {
+			return
+		}
+
+		l := B.Listen("echo", true)
+		defer l.Close()
+
+		served := make(chan struct{})
+		go func() {
+			defer close(served)
+
+			s, err := l.AcceptStream()
+			if !assert.NoError(err) {
+				return
+			}
+
+			// echo until the client closes its write side
+			_, err = io.Copy(s, s)
+			assert.NoError(err)
+			assert.NoError(s.CloseWrite())
+			assert.NoError(s.Close())
+		}()
+
+		s, err := A.OpenStream(identB, "echo")
+		if !assert.NoError(err) {
+			return
+		}
+		s.SetDeadline(time.Now().Add(30 * time.Second))
+
+		data := make([]byte, 256*1024+17)
+		rand.Read(data)
+
+		var (
+			echoed []byte
+			done   = make(chan struct{})
+		)
+		go func() {
+			defer close(done)
+			echoed, err = ioutil.ReadAll(s)
+		}()
+
+		_, werr := io.Copy(s, bytes.NewReader(data))
+		assert.NoError(werr)
+		assert.NoError(s.CloseWrite())
+
+		// writes after CloseWrite fail while the read side is still open
+		_, werr = s.Write([]byte("late"))
+		assert.Equal(io.EOF, werr)
+
+		<-done
+		assert.NoError(err)
+		assert.True(bytes.Equal(data, echoed), "echoed %d of %d bytes", len(echoed), len(data))
+
+		assert.NoError(s.Close())
+		<-served
+	})
+}
+
+func TestStreamServerWritesFirst(t *testing.T) {
+	assert := assert.New(t)
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		identB, err := B.LocalIdentity()
+		if !assert.NoError(err) {
+			return
+		}
+
+		l := B.Listen("greet", true)
+		defer l.Close()
+
+		served := make(chan struct{})
+		go func() {
+			defer close(served)
+
+			s, err := l.AcceptStream()
+			if !assert.NoError(err) {
+				return
+			}
+			s.SetDeadline(time.Now().Add(10 * time.Second))
+
+			_, err = io.WriteString(s, "220 hello\n")
+			assert.NoError(err)
+
+			line, err := bufio.NewReader(s).ReadString('\n')
+			assert.NoError(err)
+			assert.Equal("QUIT\n", line)
+
+			assert.NoError(s.CloseWrite())
+			assert.NoError(s.Close())
+		}()
+
+		s, err := A.OpenStream(identB, "greet")
+		if !assert.NoError(err) {
+			return
+		}
+		s.SetDeadline(time.Now().Add(10 * time.Second))
+
+		// read the greeting with a tiny buffer
+		var (
+			greeting []byte
+			buf      [3]byte
+		)
+		for !bytes.HasSuffix(greeting, []byte("\n")) {
+			n, err := s.Read(buf[:])
+			if !assert.NoError(err) {
+				return
+			}
+			greeting = append(greeting, buf[:n]...)
+		}
+		assert.Equal("220 hello\n", string(greeting))
+
+		_, err = io.WriteString(s, "QUIT\n")
+		assert.NoError(err)
+
+		_, err = s.Read(buf[:])
+		assert.Equal(io.EOF, err)
+
+		assert.NoError(s.CloseWrite())
+		assert.NoError(s.Close())
+		<-served
+	})
+}
+
+func TestListenerAcceptStream(t *testing.T) {
+	assert := assert.New(t)
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		identB, err := B.LocalIdentity()
+		if !assert.NoError(err) {
+			return
+		}
+
+		// use the endpoint like any other net.Listener
+		var l net.Listener = B.Listen("copy", true)
+		defer l.Close()
+
+		served := make(chan struct{})
+		go func() {
+			defer close(served)
+
+			conn, err := l.Accept()
+			if !assert.NoError(err) {
+				return
+			}
+			assert.IsType(&Stream{}, conn)
+			conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+			_, err = io.Copy(conn, conn)
+			assert.NoError(err)
+			assert.NoError(conn.(*Stream).CloseWrite())
+			assert.NoError(conn.Close())
+		}()
+
+		s, err := A.OpenStream(identB, "copy")
+		if !assert.NoError(err) {
+			return
+		}
+		s.SetDeadline(time.Now().Add(30 * time.Second))
+
+		// larger than a packet; the stream fragments the writes
+		data := make([]byte, 64*1024+3)
+		rand.Read(data)
+
+		go func() {
+			_, err := io.Copy(s, bytes.NewReader(data))
+			assert.NoError(err)
+			assert.NoError(s.CloseWrite())
+		}()
+
+		var echoed bytes.Buffer
+		_, err = io.Copy(&echoed, s)
+		assert.NoError(err)
+		assert.True(bytes.Equal(data, echoed.Bytes()), "copied %d of %d bytes", echoed.Len(), len(data))
+
+		assert.NoError(s.Close())
+		<-served
+	})
+}
@@ -0,0 +1,178 @@
+package tracer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// OTLPJSONExporter writes spans in the OTLP/JSON encoding (one
+// ExportTraceServiceRequest per line) as read by the file receiver of the
+// OpenTelemetry collector.
+type OTLPJSONExporter struct {
+	mtx     sync.Mutex
+	w       io.Writer
+	service string
+}
+
+// NewOTLPJSONExporter returns an exporter which writes to w. The spans are
+// reported for the service named service.
+func NewOTLPJSONExporter(w io.Writer, service string) *OTLPJSONExporter {
+	return &OTLPJSONExporter{w: w, service: service}
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	DroppedEvents     int            `json:"droppedEventsCount,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusError      = 2
+)
+
+// ExportSpans implements SpanExporter.
+func (x *OTLPJSONExporter) ExportSpans(spans []Span) error {
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: map[string]interface{}{"stringValue": x.service}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/telehash/gogotelehash"},
+			Spans: make([]otlpSpan, 0, len(spans)),
+		}},
+	}}}
+
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(span.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.SpanID[:]),
+			Name:              span.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+			DroppedEvents:     span.DroppedEvents,
+		}
+		if span.ParentID != [8]byte{} {
+			s.ParentSpanID = hex.EncodeToString(span.ParentID[:])
+		}
+		if span.Error != "" {
+			s.Status = &otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+		for _, e := range span.Events {
+			s.Events = append(s.Events, otlpEvent{
+				TimeUnixNano: strconv.FormatInt(e.Time.UnixNano(), 10),
+				Name:         e.Name,
+				Attributes:   otlpAttributes(e.Attributes),
+			})
+		}
+		req.ResourceSpans[0].ScopeSpans[0].Spans = append(req.ResourceSpans[0].ScopeSpans[0].Spans, s)
+	}
+
+	data, err := json.Marshal(&req)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	_, err = x.w.Write(data)
+	return err
+}
+
+// otlpAttributes flattens info into OTLP attributes. Nested Info values are
+// flattened with dotted keys; other composite values are encoded as JSON.
+func otlpAttributes(info Info) []otlpKeyValue {
+	var kvs []otlpKeyValue
+
+	var flatten func(prefix string, info Info)
+	flatten = func(prefix string, info Info) {
+		for k, v := range info {
+			if nested, ok := v.(Info); ok {
+				flatten(prefix+k+".", nested)
+				continue
+			}
+			kvs = append(kvs, otlpKeyValue{Key: prefix + k, Value: otlpValue(v)})
+		}
+	}
+	flatten("", info)
+
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs
+}
+
+func otlpValue(v interface{}) map[string]interface{} {
+	switch x := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": x}
+	case bool:
+		return map[string]interface{}{"boolValue": x}
+	case ID:
+		return map[string]interface{}{"intValue": strconv.FormatUint(uint64(x), 10)}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return map[string]interface{}{"intValue": fmt.Sprint(x)}
+	case float32, float64:
+		return map[string]interface{}{"doubleValue": x}
+	case fmt.Stringer:
+		return map[string]interface{}{"stringValue": x.String()}
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		return map[string]interface{}{"stringValue": string(data)}
+	}
+}
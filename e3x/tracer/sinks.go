@@ -0,0 +1,98 @@
+package tracer
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// JSONLines is a Sink which writes every event as a single line of JSON.
+type JSONLines struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+// NewJSONLines returns a sink which writes the events to w. Write errors are
+// ignored.
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{w: w}
+}
+
+func (s *JSONLines) Emit(e Event) {
+	data, err := json.Marshal(&e)
+	if err != nil {
+		panic(err)
+	}
+	data = append(data, '\n')
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.w.Write(data)
+}
+
+// Ring is a Sink which keeps the most recent events in memory. It is meant
+// for tests.
+type Ring struct {
+	mtx    sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+// NewRing returns a ring buffer which keeps the last size events.
+func NewRing(size int) *Ring {
+	if size <= 0 {
+		panic("ring size must be positive")
+	}
+	return &Ring{events: make([]Event, size)}
+}
+
+func (r *Ring) Emit(e Event) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.events[r.next] = e
+	r.next++
+	if r.next == len(r.events) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Events returns the buffered events, oldest first.
+func (r *Ring) Events() []Event {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if !r.full {
+		return append([]Event(nil), r.events[:r.next]...)
+	}
+
+	events := make([]Event, 0, len(r.events))
+	events = append(events, r.events[r.next:]...)
+	events = append(events, r.events[:r.next]...)
+	return events
+}
+
+// Filter returns the buffered events of type typ, oldest first.
+func (r *Ring) Filter(typ string) []Event {
+	var events []Event
+	for _, e := range r.Events() {
+		if e.Type == typ {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Reset drops all the buffered events.
+func (r *Ring) Reset() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for i := range r.events {
+		r.events[i] = Event{}
+	}
+	r.next = 0
+	r.full = false
+}
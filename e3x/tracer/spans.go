@@ -0,0 +1,225 @@
+package tracer
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxSpanEvents is the number of events recorded per span. Endpoint and
+// exchange spans live as long as the endpoint; the (per-packet) events beyond
+// this limit are dropped and counted in Span.DroppedEvents.
+const MaxSpanEvents = 128
+
+// Span follows the OpenTelemetry span data model. The SpanID is derived from
+// the tracer ID of the endpoint, exchange or channel the span represents.
+type Span struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	ParentID   [8]byte // zero for root spans
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes Info
+	Events     []SpanEvent
+	Error      string // set when the span recorded an error
+
+	DroppedEvents int // events beyond MaxSpanEvents
+}
+
+// SpanEvent is a timestamped event within a span (like a sent or a dropped
+// packet).
+type SpanEvent struct {
+	Name       string
+	Time       time.Time
+	Attributes Info
+}
+
+// SpanExporter exports finished spans. ExportSpans is called with the sink
+// lock held; slow exporters should buffer.
+type SpanExporter interface {
+	ExportSpans(spans []Span) error
+}
+
+// SpanSink is a Sink which links the events of an endpoint, its exchanges,
+// their channels and packets into spans:
+//
+//	endpoint
+//	└── exchange (one span per peer)
+//	    └── channel (one span per channel)
+//
+// All other events are recorded as span events on the most specific span they
+// refer to (channel_id, exchange_id or endpoint_id), up to MaxSpanEvents per
+// span. Spans are exported when the endpoint, exchange or channel is closed,
+// or by Flush.
+type SpanSink struct {
+	mtx   sync.Mutex
+	exp   SpanExporter
+	spans map[ID]*spanState
+}
+
+type spanState struct {
+	Span
+	tid      ID
+	parent   ID
+	children map[ID]bool
+}
+
+// spanStarts maps the event types which start a span to the keys of the ID of
+// the new span and of its parent.
+var spanStarts = map[string][2]string{
+	"endpoint.new": {"endpoint_id", ""},
+	"exchange.new": {"exchange_id", "endpoint_id"},
+	"channel.new":  {"channel_id", "exchange_id"},
+}
+
+// spanEnds maps the event types which end a span to the key of the ID of the
+// span.
+var spanEnds = map[string]string{
+	"endpoint.stopped": "endpoint_id",
+	"exchange.stopped": "exchange_id",
+	"channel.closed":   "channel_id",
+}
+
+// NewSpanSink returns a sink which exports spans to exp.
+func NewSpanSink(exp SpanExporter) *SpanSink {
+	return &SpanSink{exp: exp, spans: make(map[ID]*spanState)}
+}
+
+func (s *SpanSink) Emit(e Event) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if keys, ok := spanStarts[e.Type]; ok {
+		s.start(e, keys[0], keys[1])
+		return
+	}
+
+	if key, ok := spanEnds[e.Type]; ok {
+		if span := s.spans[infoID(e.Info, key)]; span != nil {
+			span.addEvent(e)
+			s.end(span, e.Time)
+		}
+		return
+	}
+
+	for _, key := range []string{"channel_id", "exchange_id", "endpoint_id"} {
+		if span := s.spans[infoID(e.Info, key)]; span != nil {
+			span.addEvent(e)
+			return
+		}
+	}
+}
+
+// Flush ends and exports all the open spans.
+func (s *SpanSink) Flush() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var (
+		now   = time.Now()
+		spans []Span
+	)
+
+	for _, span := range s.spans {
+		span.End = now
+		spans = append(spans, span.Span)
+	}
+	s.spans = make(map[ID]*spanState)
+
+	if len(spans) == 0 {
+		return nil
+	}
+	return s.exp.ExportSpans(spans)
+}
+
+func (s *SpanSink) start(e Event, key, parentKey string) {
+	tid := infoID(e.Info, key)
+	if tid == 0 {
+		return
+	}
+
+	span := &spanState{tid: tid}
+	span.Name = e.Type[:strings.IndexByte(e.Type, '.')]
+	span.Start = e.Time
+	span.SpanID = spanID(tid)
+	span.Attributes = Info{}
+	for k, v := range e.Info {
+		if k != key && k != parentKey {
+			span.Attributes[k] = v
+		}
+	}
+
+	if parent := s.spans[infoID(e.Info, parentKey)]; parentKey != "" && parent != nil {
+		span.parent = parent.tid
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+		if parent.children == nil {
+			parent.children = make(map[ID]bool)
+		}
+		parent.children[tid] = true
+	} else {
+		rand.Read(span.TraceID[:])
+	}
+
+	s.spans[tid] = span
+}
+
+// end ends span and its open child spans and exports them.
+func (s *SpanSink) end(span *spanState, t time.Time) {
+	var spans []Span
+
+	var walk func(span *spanState)
+	walk = func(span *spanState) {
+		for child := range span.children {
+			if c := s.spans[child]; c != nil {
+				walk(c)
+			}
+		}
+		delete(s.spans, span.tid)
+		span.End = t
+		spans = append(spans, span.Span)
+	}
+	walk(span)
+
+	if parent := s.spans[span.parent]; parent != nil {
+		delete(parent.children, span.tid)
+	}
+
+	s.exp.ExportSpans(spans)
+}
+
+func (span *spanState) addEvent(e Event) {
+	if strings.HasSuffix(e.Type, ".error") {
+		if msg, ok := e.Info["error"].(string); ok {
+			span.Error = msg
+		}
+	}
+
+	if len(span.Events) >= MaxSpanEvents {
+		span.DroppedEvents++
+		return
+	}
+
+	span.Events = append(span.Events, SpanEvent{
+		Name:       e.Type,
+		Time:       e.Time,
+		Attributes: e.Info,
+	})
+}
+
+func infoID(info Info, key string) ID {
+	if key == "" {
+		return 0
+	}
+	id, _ := info[key].(ID)
+	return id
+}
+
+func spanID(tid ID) [8]byte {
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], uint64(tid))
+	return id
+}
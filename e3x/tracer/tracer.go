@@ -0,0 +1,90 @@
+// Package tracer emits structured trace events. Events are delivered to a
+// pluggable Sink; every endpoint can have its own sink.
+//
+// Built-in sinks write JSON lines to an io.Writer (JSONLines), keep the most
+// recent events in memory (Ring) or link the events of endpoints, exchanges,
+// channels and packets into OpenTelemetry-style spans (SpanSink).
+package tracer
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+type ID uint64
+type Info map[string]interface{}
+
+// Event is a single trace event.
+type Event struct {
+	ID   ID        `json:"id"`
+	Type string    `json:"ty"`
+	Time time.Time `json:"ts"`
+	Info Info      `json:"in,omitempty"`
+}
+
+// Sink receives trace events. Implementations must be safe for concurrent use.
+type Sink interface {
+	Emit(e Event)
+}
+
+// Tracer emits events to a Sink. A nil Tracer or a Tracer without a sink is
+// disabled.
+type Tracer struct {
+	sink Sink
+}
+
+var (
+	// Enabled is true when the TH_TRACER environment variable is set to "on".
+	// The Default tracer then writes JSON lines to stdout.
+	Enabled      bool = os.Getenv("TH_TRACER") == "on"
+	Default      *Tracer
+	lastTracerId uint64 = 0
+)
+
+func init() {
+	if Enabled {
+		Default = New(NewJSONLines(os.Stdout))
+	} else {
+		Default = New(nil)
+	}
+}
+
+// New returns a Tracer which emits its events to sink. The tracer is disabled
+// when sink is nil.
+func New(sink Sink) *Tracer {
+	return &Tracer{sink: sink}
+}
+
+func NewID() ID {
+	return ID(atomic.AddUint64(&lastTracerId, 1))
+}
+
+// Enabled returns true when the events of t are delivered to a sink. Callers
+// should check Enabled before building the event info.
+func (t *Tracer) Enabled() bool {
+	return t != nil && t.sink != nil
+}
+
+// Emit delivers an event to the sink of t.
+func (t *Tracer) Emit(typ string, info Info) {
+	if !t.Enabled() {
+		return
+	}
+
+	if typ == "" {
+		panic("type must not be blank")
+	}
+
+	t.sink.Emit(Event{
+		ID:   NewID(),
+		Type: typ,
+		Time: time.Now(),
+		Info: info,
+	})
+}
+
+// Emit delivers an event to the Default tracer.
+func Emit(typ string, info Info) {
+	Default.Emit(typ, info)
+}
@@ -0,0 +1,210 @@
+package tracer
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+func TestDisabledTracer(t *testing.T) {
+	assert := assert.New(t)
+
+	var nilTracer *Tracer
+	assert.False(nilTracer.Enabled())
+	assert.False(New(nil).Enabled())
+	assert.True(New(NewRing(1)).Enabled())
+
+	// must not panic
+	nilTracer.Emit("test", nil)
+}
+
+func TestJSONLines(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	tr := New(NewJSONLines(&buf))
+	tr.Emit("a", Info{"x": 1})
+	tr.Emit("b", nil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !assert.Equal(2, len(lines)) {
+		return
+	}
+
+	var e struct {
+		Type string                 `json:"ty"`
+		Info map[string]interface{} `json:"in"`
+	}
+	assert.NoError(json.Unmarshal([]byte(lines[0]), &e))
+	assert.Equal("a", e.Type)
+	assert.Equal(float64(1), e.Info["x"])
+}
+
+func TestRing(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewRing(3)
+	tr := New(r)
+	for _, typ := range []string{"a", "b", "a", "c"} {
+		tr.Emit(typ, nil)
+	}
+
+	var types []string
+	for _, e := range r.Events() {
+		types = append(types, e.Type)
+	}
+	assert.Equal([]string{"b", "a", "c"}, types)
+	assert.Equal(1, len(r.Filter("a")))
+
+	r.Reset()
+	assert.Equal(0, len(r.Events()))
+}
+
+type recordExporter struct {
+	spans []Span
+}
+
+func (x *recordExporter) ExportSpans(spans []Span) error {
+	x.spans = append(x.spans, spans...)
+	return nil
+}
+
+func TestSpanSink(t *testing.T) {
+	assert := assert.New(t)
+
+	exp := &recordExporter{}
+	sink := NewSpanSink(exp)
+	tr := New(sink)
+
+	var (
+		endpointID = NewID()
+		exchangeID = NewID()
+		channelID  = NewID()
+		packetID   = NewID()
+	)
+
+	tr.Emit("endpoint.new", Info{"endpoint_id": endpointID, "hashname": "abc"})
+	tr.Emit("exchange.new", Info{"exchange_id": exchangeID, "endpoint_id": endpointID})
+	tr.Emit("channel.new", Info{"channel_id": channelID, "exchange_id": exchangeID})
+	tr.Emit("channel.write", Info{"channel_id": channelID, "packet_id": packetID})
+	tr.Emit("exchange.error", Info{"exchange_id": exchangeID, "error": "boom"})
+	tr.Emit("channel.closed", Info{"channel_id": channelID})
+
+	if !assert.Equal(1, len(exp.spans)) {
+		return
+	}
+	channel := exp.spans[0]
+	assert.Equal("channel", channel.Name)
+	assert.Equal(spanID(channelID), channel.SpanID)
+	assert.Equal(spanID(exchangeID), channel.ParentID)
+	if assert.Equal(2, len(channel.Events)) {
+		assert.Equal("channel.write", channel.Events[0].Name)
+		assert.Equal(packetID, channel.Events[0].Attributes["packet_id"])
+	}
+
+	// ending the endpoint ends the exchange too
+	tr.Emit("endpoint.stopped", Info{"endpoint_id": endpointID})
+	if !assert.Equal(3, len(exp.spans)) {
+		return
+	}
+	exchange, endpoint := exp.spans[1], exp.spans[2]
+	assert.Equal("exchange", exchange.Name)
+	assert.Equal("boom", exchange.Error)
+	assert.Equal(spanID(endpointID), exchange.ParentID)
+	assert.Equal("endpoint", endpoint.Name)
+	assert.Equal("abc", endpoint.Attributes["hashname"])
+	assert.Equal([8]byte{}, endpoint.ParentID)
+	assert.Equal(endpoint.TraceID, exchange.TraceID)
+	assert.Equal(endpoint.TraceID, channel.TraceID)
+
+	// open spans are exported by Flush
+	tr.Emit("endpoint.new", Info{"endpoint_id": NewID()})
+	assert.NoError(sink.Flush())
+	assert.Equal(4, len(exp.spans))
+}
+
+func TestSpanSinkMaxEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	exp := &recordExporter{}
+	tr := New(NewSpanSink(exp))
+
+	endpointID := NewID()
+	tr.Emit("endpoint.new", Info{"endpoint_id": endpointID})
+	for i := 0; i < 10*MaxSpanEvents; i++ {
+		tr.Emit("endpoint.packet", Info{"endpoint_id": endpointID, "packet_id": NewID()})
+	}
+	tr.Emit("endpoint.stopped", Info{"endpoint_id": endpointID})
+
+	if assert.Equal(1, len(exp.spans)) {
+		assert.Equal(MaxSpanEvents, len(exp.spans[0].Events))
+		assert.Equal(9*MaxSpanEvents+1, exp.spans[0].DroppedEvents)
+	}
+}
+
+func TestOTLPJSONExporter(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	sink := NewSpanSink(NewOTLPJSONExporter(&buf, "test"))
+	tr := New(sink)
+
+	endpointID, exchangeID := NewID(), NewID()
+	tr.Emit("endpoint.new", Info{"endpoint_id": endpointID})
+	tr.Emit("exchange.new", Info{"exchange_id": exchangeID, "endpoint_id": endpointID})
+	tr.Emit("exchange.started", Info{"exchange_id": exchangeID, "peer": "xyz"})
+	tr.Emit("exchange.error", Info{"exchange_id": exchangeID, "error": "boom"})
+	tr.Emit("exchange.stopped", Info{"exchange_id": exchangeID})
+
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Events       []struct {
+						Name       string `json:"name"`
+						Attributes []struct {
+							Key   string                 `json:"key"`
+							Value map[string]interface{} `json:"value"`
+						} `json:"attributes"`
+					} `json:"events"`
+					Status struct {
+						Code    int    `json:"code"`
+						Message string `json:"message"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if !assert.NoError(json.Unmarshal(buf.Bytes(), &req)) {
+		return
+	}
+
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if !assert.Equal(1, len(spans)) {
+		return
+	}
+	span := spans[0]
+	assert.Equal("exchange", span.Name)
+	assert.Equal(32, len(span.TraceID))
+	assert.Equal(16, len(span.SpanID))
+	assert.Equal(16, len(span.ParentSpanID))
+	assert.Equal(2, span.Status.Code)
+	assert.Equal("boom", span.Status.Message)
+	if assert.Equal(3, len(span.Events)) {
+		assert.Equal("exchange.started", span.Events[0].Name)
+		var peer interface{}
+		for _, kv := range span.Events[0].Attributes {
+			if kv.Key == "peer" {
+				peer = kv.Value["stringValue"]
+			}
+		}
+		assert.Equal("xyz", peer)
+	}
+}
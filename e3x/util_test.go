@@ -1,12 +1,14 @@
 package e3x
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/mock"
 
+	"github.com/telehash/gogotelehash/e3x/metrics"
+	"github.com/telehash/gogotelehash/e3x/tracer"
 	"github.com/telehash/gogotelehash/internal/lob"
-	"github.com/telehash/gogotelehash/internal/util/tracer"
 )
 
 type MockExchange struct {
@@ -17,7 +19,13 @@ func (m *MockExchange) getTID() tracer.ID {
 	return tracer.ID(0)
 }
 
-func (m *MockExchange) deliverPacket(pkt *lob.Packet, dst *Pipe) error {
+func (m *MockExchange) deliverPacket(pkt *lob.Packet, dst *Pipe, priority int) error {
+	pkt.TID = 0
+	args := m.Called(pkt)
+	return args.Error(0)
+}
+
+func (m *MockExchange) tryDeliverPacket(pkt *lob.Packet, dst *Pipe, priority int) error {
 	pkt.TID = 0
 	args := m.Called(pkt)
 	return args.Error(0)
@@ -28,7 +36,12 @@ func (m *MockExchange) RemoteIdentity() *Identity {
 	return args.Get(0).(*Identity)
 }
 
-func dumpExpVar(tb testing.TB) {
-	tb.Logf("stat: %s", statsMap)
-	resetStats()
+// testMetrics collects the metrics of the endpoints used by the benchmarks.
+var testMetrics = metrics.NewPrometheus()
+
+func dumpMetrics(tb testing.TB) {
+	var buf bytes.Buffer
+	testMetrics.WriteTo(&buf)
+	tb.Logf("metrics:\n%s", buf.String())
+	testMetrics = metrics.NewPrometheus()
 }
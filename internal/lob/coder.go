@@ -1,6 +1,6 @@
 // Package lob implemnets the Length-Object-Binary encoding (Packet Format).
 //
-// Reference
+// # Reference
 //
 // https://github.com/telehash/telehash.org/blob/v3/v3/lob/README.md
 package lob
@@ -13,8 +13,8 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/telehash/gogotelehash/e3x/tracer"
 	"github.com/telehash/gogotelehash/internal/util/bufpool"
-	"github.com/telehash/gogotelehash/internal/util/tracer"
 )
 
 // ErrInvalidPacket is returned by Decode
@@ -35,18 +35,20 @@ type Packet struct {
 type Header struct {
 	Bytes []byte `json:"-"`
 
-	C       uint32   `json:"c,omitempty"`
-	Type    string   `json:"type,omitempty"`
-	End     bool     `json:"end,omitempty"`
-	Seq     uint32   `json:"seq,omitempty"`
-	Ack     uint32   `json:"ack,omitempty"`
-	Miss    []uint32 `json:"miss,omitempty"`
-	HasC    bool     `json:"-"`
-	HasType bool     `json:"-"`
-	HasEnd  bool     `json:"-"`
-	HasSeq  bool     `json:"-"`
-	HasAck  bool     `json:"-"`
-	HasMiss bool     `json:"-"`
+	C         uint32   `json:"c,omitempty"`
+	Type      string   `json:"type,omitempty"`
+	End       bool     `json:"end,omitempty"`
+	Seq       uint32   `json:"seq,omitempty"`
+	Ack       uint32   `json:"ack,omitempty"`
+	Miss      []uint32 `json:"miss,omitempty"`
+	Window    uint32   `json:"window,omitempty"`
+	HasC      bool     `json:"-"`
+	HasType   bool     `json:"-"`
+	HasEnd    bool     `json:"-"`
+	HasSeq    bool     `json:"-"`
+	HasAck    bool     `json:"-"`
+	HasMiss   bool     `json:"-"`
+	HasWindow bool     `json:"-"`
 
 	Extra map[string]interface{} `json:"extra,omitempty"`
 }
@@ -275,6 +277,16 @@ func (h *Header) writeTo(buf *bytes.Buffer) error {
 		first = false
 	}
 
+	if h.HasWindow {
+		if !first {
+			buf.WriteByte(',')
+		}
+		buf.Write(hdrWindow)
+		buf.WriteByte(':')
+		fmt.Fprintf(buf, "%d", h.Window)
+		first = false
+	}
+
 	if len(h.Extra) > 0 {
 		enc := json.NewEncoder(buf)
 		for k, v := range h.Extra {
@@ -298,7 +310,7 @@ func (h *Header) writeTo(buf *bytes.Buffer) error {
 
 // IsZero returns true when the header is the zero value or equivalent.
 func (h *Header) IsZero() bool {
-	return !h.HasC && !h.HasEnd && !h.HasType && !h.HasSeq && !h.HasAck && (!h.HasMiss || len(h.Miss) == 0) && len(h.Extra) == 0 && len(h.Bytes) == 0
+	return !h.HasC && !h.HasEnd && !h.HasType && !h.HasSeq && !h.HasAck && (!h.HasMiss || len(h.Miss) == 0) && !h.HasWindow && len(h.Extra) == 0 && len(h.Bytes) == 0
 }
 
 func (h *Header) IsBinary() bool {
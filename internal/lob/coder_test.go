@@ -20,6 +20,8 @@ func TestCoding(t *testing.T) {
 		New(nil).SetHeader(Header{HasSeq: true, Seq: 123}),
 		New(nil).SetHeader(Header{HasType: true, Type: "foo"}),
 		New(nil).SetHeader(Header{HasMiss: true, Miss: []uint32{123, 246}}),
+		New(nil).SetHeader(Header{HasWindow: true, Window: 64}),
+		New(nil).SetHeader(Header{HasAck: true, Ack: 12, HasWindow: true, Window: 0}),
 	}
 
 	for i, e := range tab {
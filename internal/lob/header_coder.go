@@ -17,12 +17,13 @@ var (
 	tokenTrue  = []byte("true")
 	tokenFalse = []byte("false")
 
-	hdrC    = []byte(`"c"`)
-	hdrType = []byte(`"type"`)
-	hdrSeq  = []byte(`"seq"`)
-	hdrAck  = []byte(`"ack"`)
-	hdrMiss = []byte(`"miss"`)
-	hdrEnd  = []byte(`"end"`)
+	hdrC      = []byte(`"c"`)
+	hdrType   = []byte(`"type"`)
+	hdrSeq    = []byte(`"seq"`)
+	hdrAck    = []byte(`"ack"`)
+	hdrMiss   = []byte(`"miss"`)
+	hdrEnd    = []byte(`"end"`)
+	hdrWindow = []byte(`"window"`)
 )
 
 func parseHeader(hdr *Header, p []byte) error {
@@ -53,6 +54,8 @@ func parseHeader(hdr *Header, p []byte) error {
 			f = parseType
 		} else if p, ok = parsePrefix(p, hdrEnd); ok {
 			f = parseEnd
+		} else if p, ok = parsePrefix(p, hdrWindow); ok {
+			f = parseWindow
 		} else if key, p, ok = parseString(p); ok {
 			f = parseOther
 		} else {
@@ -145,6 +148,17 @@ func parseAck(hdr *Header, key string, p []byte) ([]byte, error) {
 	return p, nil
 }
 
+func parseWindow(hdr *Header, key string, p []byte) ([]byte, error) {
+	n, p, ok := parseUint32(p)
+	if !ok {
+		return nil, ErrInvalidPacket
+	}
+
+	hdr.Window = n
+	hdr.HasWindow = true
+	return p, nil
+}
+
 func parseType(hdr *Header, key string, p []byte) ([]byte, error) {
 	s, p, ok := parseString(p)
 	if !ok {
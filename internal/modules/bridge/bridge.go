@@ -1,7 +1,10 @@
 package bridge
 
 import (
+	"bytes"
+	"encoding/hex"
 	"io"
+	"sort"
 	"sync"
 	"time"
 
@@ -21,6 +24,14 @@ type Config struct {
 type Bridge interface {
 	RouteToken(token cipherset.Token, source *e3x.Exchange)
 	BreakRoute(token cipherset.Token)
+	Routes() []Route
+}
+
+// Route is a packet route of the bridge. Packets with Token are forwarded to
+// the exchange with Source.
+type Route struct {
+	Token  cipherset.Token
+	Source hashname.H
 }
 
 type module struct {
@@ -74,7 +85,7 @@ func newBridge(e *e3x.Endpoint, config Config) *module {
 }
 
 func (mod *module) Init() error {
-	mod.log = logs.Module("bridge").From(mod.e.LocalHashname())
+	mod.log = mod.e.Log().Module("bridge")
 
 	mod.e.DefaultExchangeHooks().Register(e3x.ExchangeHook{
 		OnClosed:     mod.on_exchange_closed,
@@ -210,6 +221,25 @@ func (mod *module) BreakRoute(token cipherset.Token) {
 	mod.mtx.Unlock()
 }
 
+// Routes returns the packet routes of the bridge.
+func (mod *module) Routes() []Route {
+	mod.mtx.RLock()
+	routes := make([]Route, 0, len(mod.packetRoutes))
+	for token, x := range mod.packetRoutes {
+		routes = append(routes, Route{Token: token, Source: x.RemoteHashname()})
+	}
+	mod.mtx.RUnlock()
+
+	sort.Sort(routesByToken(routes))
+	return routes
+}
+
+type routesByToken []Route
+
+func (s routesByToken) Len() int           { return len(s) }
+func (s routesByToken) Less(i, j int) bool { return bytes.Compare(s[i].Token[:], s[j].Token[:]) < 0 }
+func (s routesByToken) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 func (mod *module) lookupToken(token cipherset.Token) (source *e3x.Exchange) {
 	mod.mtx.RLock()
 	source = mod.packetRoutes[token]
@@ -347,10 +377,10 @@ func (mod *module) forwardMessage(e *e3x.Endpoint, x *e3x.Exchange, msg []byte,
 	_, err := dst.Write(buf)
 	buf.Free()
 	if err != nil {
-		mod.log.To(ex.RemoteHashname()).Printf("\x1B[35mFWD %x %s error=%s\x1B[0m", token, dst.RemoteAddr(), err)
+		mod.log.To(ex.RemoteHashname()).Warn("failed to forward", "token", hex.EncodeToString(token[:]), "path", dst.RemoteAddr(), "error", err)
 		return nil
 	} else {
-		mod.log.To(ex.RemoteHashname()).Printf("\x1B[35mFWD %x %s\x1B[0m", token, dst.RemoteAddr())
+		mod.log.To(ex.RemoteHashname()).Debug("forwarded", "token", hex.EncodeToString(token[:]), "path", dst.RemoteAddr())
 		return e3x.ErrStopPropagation
 	}
 }
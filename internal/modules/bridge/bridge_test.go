@@ -1,6 +1,7 @@
 package bridge
 
 import (
+	"encoding/hex"
 	"net"
 	"testing"
 
@@ -115,13 +116,9 @@ func TestBridge(t *testing.T) {
 		Bident = Bident.AddPathCandiate(addr)
 	}
 
-	log.Println("\x1B[31m------------------------------------------------\x1B[0m")
-
 	// blacklist A
 	blacklist = append(blacklist, Aident.Addresses()...)
-	log.Println("\x1B[32mblacklist:\x1B[0m", blacklist)
-
-	log.Println("\x1B[31m------------------------------------------------\x1B[0m")
+	log.Info("blacklisted A", "blacklist", blacklist)
 
 	_, err = R.Dial(Bident)
 	assert.NoError(err)
@@ -130,10 +127,8 @@ func TestBridge(t *testing.T) {
 	ABex, err := A.Dial(Bident)
 	assert.NoError(err)
 
-	log.Println("\x1B[31m------------------------------------------------\x1B[0m")
-	log.Printf("ab-local-token  = %x", ABex.LocalToken())
-	log.Printf("ab-remote-token = %x", ABex.RemoteToken())
-	log.Println("\x1B[31m------------------------------------------------\x1B[0m")
+	localToken, remoteToken := ABex.LocalToken(), ABex.RemoteToken()
+	log.Info("dialed B", "local-token", hex.EncodeToString(localToken[:]), "remote-token", hex.EncodeToString(remoteToken[:]))
 
 	{
 		ch, err := B.Open(Aident, "ping", true)
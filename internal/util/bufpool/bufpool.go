@@ -51,15 +51,18 @@ func (b *Buffer) Len() int {
 }
 
 func (b *Buffer) Get(buf []byte) []byte {
+	if b == nil {
+		return buf
+	}
+
 	b.secure()
 	return append(buf, b.bytes...)
 }
 
+// Set copies buf into the buffer. Data larger than the pooled buffer size is
+// stored in a dedicated slice which is dropped by Free.
 func (b *Buffer) Set(buf []byte) *Buffer {
 	b.secure()
-	if len(buf) > bufferSize {
-		panic("data too large")
-	}
 	b.bytes = append(b.bytes[:0], buf...)
 	return b
 }
@@ -69,8 +72,16 @@ func (b *Buffer) RawBytes() []byte {
 	return b.bytes
 }
 
+// SetLen resizes the buffer to n bytes. Buffers larger than the pooled buffer
+// size are stored in a dedicated slice which is dropped by Free.
 func (b *Buffer) SetLen(n int) *Buffer {
 	b.secure()
+	if n > cap(b.bytes) {
+		bytes := make([]byte, n)
+		copy(bytes, b.bytes)
+		b.bytes = bytes
+		return b
+	}
 	b.bytes = b.bytes[:n]
 	return b
 }
@@ -93,10 +104,15 @@ func (b *Buffer) Free() {
 		return
 	}
 
-	if b.bytes == nil || cap(b.bytes) != bufferSize {
+	if b.bytes == nil {
 		panic("invalid buffer return")
 	}
 
+	if cap(b.bytes) != bufferSize {
+		// drop the oversized slice
+		b.bytes = make([]byte, 0, bufferSize)
+	}
+
 	b.bytes = b.bytes[:0]
 	bufferPool.Put(b)
 }
@@ -2,6 +2,8 @@ package logs
 
 import (
 	"hash/crc32"
+	"io"
+	"os"
 )
 
 var colors = []string{
@@ -36,11 +38,35 @@ var colors = []string{
 	// "\x1b[04;37m", // white
 }
 
+var levelColors = map[Level]string{
+	LevelDebug: "\x1b[2;37m", // dim white
+	LevelInfo:  "\x1b[32m",   // green
+	LevelWarn:  "\x1b[33m",   // yellow
+	LevelError: "\x1b[31m",   // red
+}
+
 var ncolors = uint32(len(colors))
 
-const reset = "\x1b[0m"
+const (
+	reset = "\x1b[0m"
+	dim   = "\x1b[2;37m"
+)
 
 func colorize(term string) string {
 	idx := int(crc32.ChecksumIEEE([]byte(term)) % ncolors)
 	return colors[idx] + term + reset
 }
+
+// isTerminal returns true when w is a character device (like a terminal).
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
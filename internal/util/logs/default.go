@@ -1,6 +1,7 @@
 package logs
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/telehash/gogotelehash/internal/hashname"
@@ -8,9 +9,23 @@ import (
 
 var defaultLogger = New(os.Stdout)
 
+func init() {
+	levelsFromEnv()
+}
+
+// levelsFromEnv applies the levels of the TH_LOG environment variable.
+func levelsFromEnv() {
+	if spec := os.Getenv("TH_LOG"); spec != "" {
+		if err := ParseLevels(spec); err != nil {
+			fmt.Fprintf(os.Stderr, "TH_LOG: %s\n", err)
+		}
+	}
+}
+
 func ResetLogger() {
 	defaultLogger = New(os.Stderr)
-	disabledMods = map[string]bool{}
+	resetLevels()
+	levelsFromEnv()
 }
 
 func DisableLogger() {
@@ -33,14 +48,7 @@ func ResetTimer() *Logger {
 	return defaultLogger.ResetTimer()
 }
 
-func Print(args ...interface{}) {
-	defaultLogger.Print(args...)
-}
-
-func Println(args ...interface{}) {
-	defaultLogger.Println(args...)
-}
-
-func Printf(format string, args ...interface{}) {
-	defaultLogger.Printf(format, args...)
-}
+func Debug(msg string, kv ...interface{}) { defaultLogger.Debug(msg, kv...) }
+func Info(msg string, kv ...interface{})  { defaultLogger.Info(msg, kv...) }
+func Warn(msg string, kv ...interface{})  { defaultLogger.Warn(msg, kv...) }
+func Error(msg string, kv ...interface{}) { defaultLogger.Error(msg, kv...) }
@@ -0,0 +1,221 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+)
+
+// Entry is a single log entry.
+type Entry struct {
+	Time    time.Time
+	Elapsed time.Duration // since the logger (timer) was created
+	Level   Level
+	Module  string
+	From    hashname.H
+	To      hashname.H
+	Message string
+	Fields  []Field
+}
+
+// Field is a key-value pair of an entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Handler writes log entries. Implementations must be safe for concurrent use.
+type Handler interface {
+	Handle(e *Entry)
+}
+
+// TextHandler writes entries as human readable lines:
+//
+//	00:00:01.234 | 3a7f 5c1e | e3x          | info  opened channel type=ping id=1
+type TextHandler struct {
+	mtx   sync.Mutex
+	w     io.Writer
+	color bool
+}
+
+// NewText returns a handler which writes lines to w. Colours are used only
+// when w is a terminal.
+func NewText(w io.Writer) *TextHandler {
+	return &TextHandler{w: w, color: isTerminal(w)}
+}
+
+func (h *TextHandler) Handle(e *Entry) {
+	var (
+		buf bytes.Buffer
+		d   = e.Elapsed
+	)
+
+	th := d / time.Hour
+	d -= th * time.Hour
+	tm := d / time.Minute
+	d -= tm * time.Minute
+	ts := d / time.Second
+	d -= ts * time.Second
+	tms := d / time.Millisecond
+
+	sep := " | "
+	if h.color {
+		sep = " " + dim + "|" + reset + " "
+		buf.WriteString(dim)
+	}
+	fmt.Fprintf(&buf, "%02d:%02d:%02d.%03d", th, tm, ts, tms)
+	if h.color {
+		buf.WriteString(reset)
+	}
+	buf.WriteString(sep)
+
+	buf.WriteString(h.short(e.From))
+	buf.WriteByte(' ')
+	buf.WriteString(h.short(e.To))
+	buf.WriteString(sep)
+
+	module := e.Module
+	if h.color && module != "" {
+		module = colorize(module)
+	}
+	buf.WriteString(module)
+	if n := len(e.Module); n < 12 {
+		buf.WriteString(strings.Repeat(" ", 12-n))
+	}
+	buf.WriteString(sep)
+
+	level := fmt.Sprintf("%-5s", e.Level)
+	if c := levelColors[e.Level]; h.color && c != "" {
+		level = c + level + reset
+	}
+	buf.WriteString(level)
+	buf.WriteByte(' ')
+	buf.WriteString(e.Message)
+
+	for _, f := range e.Fields {
+		buf.WriteByte(' ')
+		if h.color {
+			buf.WriteString(dim + f.Key + "=" + reset)
+		} else {
+			buf.WriteString(f.Key + "=")
+		}
+		buf.WriteString(textValue(f.Value))
+	}
+	buf.WriteByte('\n')
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.w.Write(buf.Bytes())
+}
+
+func (h *TextHandler) short(id hashname.H) string {
+	if id == "" {
+		return "    " // 4 spaces
+	}
+
+	s := string(id)
+	if len(s) > 4 {
+		s = s[:4]
+	}
+	if h.color {
+		s = colorize(s)
+	}
+	return s
+}
+
+func textValue(v interface{}) string {
+	var s string
+	switch x := v.(type) {
+	case string:
+		s = x
+	case error:
+		s = x.Error()
+	case fmt.Stringer:
+		s = x.String()
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// JSONHandler writes every entry as a single line of JSON:
+//
+//	{"time":"...","level":"info","module":"e3x","from":"...","to":"...","msg":"opened channel","type":"ping","id":1}
+//
+// Errors and values implementing fmt.Stringer (like net.Addr and
+// time.Duration) are written as strings.
+type JSONHandler struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+// NewJSONHandler returns a handler which writes JSON lines to w.
+func NewJSONHandler(w io.Writer) *JSONHandler {
+	return &JSONHandler{w: w}
+}
+
+func (h *JSONHandler) Handle(e *Entry) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	writeJSONField(&buf, "time", e.Time.Format(time.RFC3339Nano), true)
+	writeJSONField(&buf, "level", e.Level.String(), false)
+	if e.Module != "" {
+		writeJSONField(&buf, "module", e.Module, false)
+	}
+	if e.From != "" {
+		writeJSONField(&buf, "from", string(e.From), false)
+	}
+	if e.To != "" {
+		writeJSONField(&buf, "to", string(e.To), false)
+	}
+	writeJSONField(&buf, "msg", e.Message, false)
+	for _, f := range e.Fields {
+		writeJSONField(&buf, f.Key, jsonValue(f.Value), false)
+	}
+	buf.WriteString("}\n")
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.w.Write(buf.Bytes())
+}
+
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}, first bool) {
+	if !first {
+		buf.WriteByte(',')
+	}
+
+	k, _ := json.Marshal(key)
+	buf.Write(k)
+	buf.WriteByte(':')
+
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(v)
+}
+
+func jsonValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case error:
+		return x.Error()
+	case json.Marshaler:
+		return x
+	case fmt.Stringer:
+		return x.String()
+	default:
+		return v
+	}
+}
@@ -1,161 +1,287 @@
+// Package logs implements a leveled, structured logger.
+//
+// Every log entry has a level, a message and a list of key-value fields:
+//
+//	log.Info("opened channel", "type", typ, "id", cid)
+//
+// Loggers are scoped to a module (like "e3x" or "addrbook") and can carry the
+// local (From) and remote (To) hashname. The minimum level can be selected per
+// module with SetLevel or with the TH_LOG environment variable:
+//
+//	TH_LOG=debug                    # all modules
+//	TH_LOG=info,addrbook=debug      # debug for the address book only
+//	TH_LOG=bridge=off               # disable the bridge module
+//
+// Entries are written by a Handler. NewText writes human readable lines (with
+// colours when writing to a terminal); NewJSON writes one JSON object per line.
 package logs
 
 import (
 	"fmt"
 	"io"
-	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/telehash/gogotelehash/internal/hashname"
 )
 
-var disabledMods = map[string]bool{}
+// Level is the severity of a log entry.
+type Level int8
 
-type Logger struct {
-	module string
-	from   string
-	to     string
-	start  time.Time
-	log    *log.Logger
+const (
+	LevelDebug Level = iota - 1
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelOff Level = 127 // disables a module
+)
+
+// DefaultLevel is the minimum level of the modules without an explicit level.
+const DefaultLevel = LevelInfo
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	case LevelOff:
+		return "off"
+	default:
+		return fmt.Sprintf("level(%d)", int8(l))
+	}
 }
 
-func New(out io.Writer) *Logger {
-	l := new(Logger)
-	l.start = time.Now()
-	l.log = log.New(out, "", 0)
-	return l
+// ParseLevel parses the name of a level.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	case "off", "none":
+		return LevelOff, nil
+	default:
+		return 0, fmt.Errorf("logs: unknown level %q", s)
+	}
 }
 
+var (
+	levelsMtx    sync.RWMutex
+	defaultLevel = DefaultLevel
+	moduleLevels = map[string]Level{}
+)
+
+// SetLevel sets the minimum level of module. An empty module sets the level of
+// all modules without an explicit level.
+func SetLevel(module string, level Level) {
+	levelsMtx.Lock()
+	defer levelsMtx.Unlock()
+
+	if module == "" {
+		defaultLevel = level
+	} else {
+		moduleLevels[module] = level
+	}
+}
+
+// DisableModule disables all logging of module.
 func DisableModule(name string) {
-	disabledMods[name] = true
+	SetLevel(name, LevelOff)
 }
 
-func (l *Logger) Module(name string) *Logger {
-	if l == nil {
-		return nil
+// ParseLevels applies a level specification like "info,addrbook=debug" (as
+// used by the TH_LOG environment variable).
+func ParseLevels(spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var module, name = "", part
+		if idx := strings.IndexByte(part, '='); idx >= 0 {
+			module, name = part[:idx], part[idx+1:]
+		}
+
+		level, err := ParseLevel(name)
+		if err != nil {
+			return err
+		}
+		SetLevel(module, level)
 	}
+	return nil
+}
 
-	if disabledMods[name] {
-		return nil
+func resetLevels() {
+	levelsMtx.Lock()
+	defer levelsMtx.Unlock()
+
+	defaultLevel = DefaultLevel
+	moduleLevels = map[string]Level{}
+}
+
+func moduleLevel(module string) Level {
+	levelsMtx.RLock()
+	defer levelsMtx.RUnlock()
+
+	if level, ok := moduleLevels[module]; ok {
+		return level
 	}
+	return defaultLevel
+}
+
+// Logger writes entries to a Handler. A nil Logger discards all entries.
+type Logger struct {
+	handler Handler
+	module  string
+	from    hashname.H
+	to      hashname.H
+	start   time.Time
+	fields  []interface{}
+}
+
+// New returns a logger which writes human readable lines to out. Colours are
+// used only when out is a terminal.
+func New(out io.Writer) *Logger {
+	return NewWithHandler(NewText(out))
+}
 
+// NewJSON returns a logger which writes one JSON object per entry to out.
+func NewJSON(out io.Writer) *Logger {
+	return NewWithHandler(NewJSONHandler(out))
+}
+
+// NewWithHandler returns a logger which passes its entries to h.
+func NewWithHandler(h Handler) *Logger {
+	return &Logger{handler: h, start: time.Now()}
+}
+
+func (l *Logger) clone() *Logger {
 	x := new(Logger)
 	*x = *l
-	x.module = name
 	return x
 }
 
-func (l *Logger) From(id hashname.H) *Logger {
+// Module returns a logger for the module name. The level of the module is
+// checked for every entry; a module disabled now can be enabled later.
+func (l *Logger) Module(name string) *Logger {
 	if l == nil {
 		return nil
 	}
 
-	x := new(Logger)
-	*x = *l
-	x.from = string(id)[:4]
+	x := l.clone()
+	x.module = name
 	return x
 }
 
-func (l *Logger) To(id hashname.H) *Logger {
+// From returns a logger for entries concerning the local endpoint id.
+func (l *Logger) From(id hashname.H) *Logger {
 	if l == nil {
 		return nil
 	}
 
-	x := new(Logger)
-	*x = *l
-	x.to = string(id)[:4]
+	x := l.clone()
+	x.from = id
 	return x
 }
 
-func (l *Logger) ResetTimer() *Logger {
+// To returns a logger for entries concerning the remote endpoint id.
+func (l *Logger) To(id hashname.H) *Logger {
 	if l == nil {
 		return nil
 	}
 
-	x := new(Logger)
-	*x = *l
-	x.start = time.Now()
+	x := l.clone()
+	x.to = id
 	return x
 }
 
-func (l *Logger) Print(args ...interface{}) {
+// With returns a logger which adds the key-value pairs kv to all its entries.
+func (l *Logger) With(kv ...interface{}) *Logger {
 	if l == nil {
-		return
+		return nil
 	}
 
-	l.emit(fmt.Sprint(args...))
+	x := l.clone()
+	x.fields = append(append([]interface{}(nil), l.fields...), kv...)
+	return x
 }
 
-func (l *Logger) Println(args ...interface{}) {
+func (l *Logger) ResetTimer() *Logger {
 	if l == nil {
-		return
+		return nil
 	}
 
-	l.emit(fmt.Sprintln(args...))
+	x := l.clone()
+	x.start = time.Now()
+	return x
 }
 
-func (l *Logger) Printf(format string, args ...interface{}) {
-	if l == nil {
-		return
-	}
-
-	l.emit(fmt.Sprintf(format, args...))
+// Enabled returns true when entries of level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= moduleLevel(l.module)
 }
 
-func (l *Logger) emit(msg string) {
-	if l == nil {
-		return
-	}
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.Log(LevelDebug, msg, kv...) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.Log(LevelInfo, msg, kv...) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.Log(LevelWarn, msg, kv...) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.Log(LevelError, msg, kv...) }
 
-	if msg == "" {
+// Log writes an entry with the message msg and the key-value pairs kv. Keys
+// must be strings; a key without a value is logged with a nil value.
+func (l *Logger) Log(level Level, msg string, kv ...interface{}) {
+	if !l.Enabled(level) {
 		return
 	}
 
-	var (
-		th, tm, ts, tms time.Duration
-		from            string
-		to              string
-		module          string
-	)
-
-	{
-		d := time.Since(l.start)
-
-		th = d / time.Hour
-		d -= th * time.Hour
-
-		tm = d / time.Minute
-		d -= tm * time.Minute
+	var fields []interface{}
+	if len(l.fields) > 0 {
+		fields = append(append(fields, l.fields...), kv...)
+	} else {
+		fields = kv
+	}
 
-		ts = d / time.Second
-		d -= ts * time.Second
+	now := time.Now()
+	l.handler.Handle(&Entry{
+		Time:    now,
+		Elapsed: now.Sub(l.start),
+		Level:   level,
+		Module:  l.module,
+		From:    l.from,
+		To:      l.to,
+		Message: msg,
+		Fields:  makeFields(fields),
+	})
+}
 
-		tms = d / time.Millisecond
+func makeFields(kv []interface{}) []Field {
+	if len(kv) == 0 {
+		return nil
 	}
 
-	from = l.from
-	if from == "" {
-		from = "    " // 4 spaces
-	} else {
-		from = colorize(from)
-	}
+	fields := make([]Field, 0, (len(kv)+1)/2)
+	for i := 0; i < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
 
-	to = l.to
-	if to == "" {
-		to = "    " // 4 spaces
-	} else {
-		to = colorize(to)
-	}
+		var value interface{}
+		if i+1 < len(kv) {
+			value = kv[i+1]
+		}
 
-	module = l.module
-	moduleLen := len(module)
-	if moduleLen > 0 {
-		module = colorize(module)
+		fields = append(fields, Field{key, value})
 	}
-	if moduleLen < 12 {
-		module += strings.Repeat(" ", 12-moduleLen)
-	}
-
-	l.log.Printf("\x1B[2;37m%02d:%02d:%02d.%03d |\x1B[0m %s %s \x1B[2;37m|\x1B[0m %s \x1B[2;37m|\x1B[0m %s", th, tm, ts, tms, from, to, module, msg)
+	return fields
 }
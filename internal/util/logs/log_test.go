@@ -0,0 +1,92 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+func TestTextHandler(t *testing.T) {
+	defer resetLevels()
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	log := New(&buf).Module("e3x").From("3a7fabcdef").To("5c1eabcdef")
+
+	log.Info("opened channel", "type", "ping", "id", 1)
+	log.Debug("not logged")
+	log.Warn("failed", "error", errors.New("no route"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !assert.Equal(2, len(lines)) {
+		return
+	}
+	assert.NotContains(buf.String(), "\x1b[", "no colours when not writing to a terminal")
+	assert.Contains(lines[0], "| 3a7f 5c1e | e3x          | info  opened channel type=ping id=1")
+	assert.Contains(lines[1], `warn  failed error="no route"`)
+}
+
+func TestJSONHandler(t *testing.T) {
+	defer resetLevels()
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	log := NewJSON(&buf).Module("addrbook").From("3a7fabcdef").With("peer", "5c1e")
+	log.Info("updated path", "latency", 25*time.Millisecond, "reachable", true)
+
+	var entry map[string]interface{}
+	if !assert.NoError(json.Unmarshal(buf.Bytes(), &entry)) {
+		return
+	}
+	assert.Equal("info", entry["level"])
+	assert.Equal("addrbook", entry["module"])
+	assert.Equal("3a7fabcdef", entry["from"])
+	assert.Equal("updated path", entry["msg"])
+	assert.Equal("5c1e", entry["peer"])
+	assert.Equal("25ms", entry["latency"])
+	assert.Equal(true, entry["reachable"])
+	_, hasTo := entry["to"]
+	assert.False(hasTo)
+}
+
+func TestModuleLevels(t *testing.T) {
+	defer resetLevels()
+	assert := assert.New(t)
+
+	assert.NoError(ParseLevels("warn,addrbook=debug,bridge=off"))
+	assert.Error(ParseLevels("e3x=loud"))
+
+	var buf bytes.Buffer
+	root := New(&buf)
+
+	assert.False(root.Module("bridge").Enabled(LevelError))
+	assert.False(root.Module("e3x").Enabled(LevelInfo))
+	assert.True(root.Module("e3x").Enabled(LevelWarn))
+	assert.True(root.Module("addrbook").Enabled(LevelDebug))
+
+	// levels are applied when logging, not when the logger is created
+	log := root.Module("e3x")
+	log.Info("hidden")
+	SetLevel("e3x", LevelInfo)
+	log.Info("shown")
+	assert.NotContains(buf.String(), "hidden")
+	assert.Contains(buf.String(), "shown")
+
+	// the same goes for disabled modules
+	bridge := root.Module("bridge")
+	bridge.Info("muted")
+	SetLevel("bridge", LevelInfo)
+	bridge.Info("unmuted")
+	assert.NotContains(buf.String(), " muted")
+	assert.Contains(buf.String(), "unmuted")
+
+	// a nil logger discards everything
+	var nilLog *Logger
+	nilLog.Error("discarded")
+	assert.False(nilLog.Enabled(LevelError))
+}
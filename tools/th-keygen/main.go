@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/docopt/docopt-go"
 
@@ -16,29 +20,101 @@ import (
 const usage = `Telehash key generation tool.
 
 Usage:
-  th-keygen [--output=<file>]
+  th-keygen [--output=<file>] [--encrypt] [--passphrase-file=<file>]
+  th-keygen --encrypt --input=<file> [--output=<file>] [--passphrase-file=<file>]
+  th-keygen --decrypt --input=<file> [--output=<file>] [--passphrase-file=<file>]
   th-keygen -h | --help
   th-keygen --version
 
 Options:
-  -o --output=<file>  Location to store the keys. [default: -]
-  -h --help           Show this screen.
-  --version           Show version.
+  -o --output=<file>        Location to store the keys. [default: -]
+  -i --input=<file>         Location of existing keys.
+  --encrypt                 Protect the private keys with a passphrase.
+  --decrypt                 Remove the passphrase protection from the private keys.
+  --passphrase-file=<file>  Read the passphrase from a file (instead of
+                            $TH_KEYGEN_PASSPHRASE or a prompt).
+  -h --help                 Show this screen.
+  --version                 Show version.
 `
 
+type keyFile struct {
+	Hashname  hashname.H            `json:"hashname,omitempty"`
+	Parts     cipherset.Parts       `json:"parts,omitempty"`
+	Keys      cipherset.PrivateKeys `json:"keys,omitempty"`
+	Encrypted json.RawMessage       `json:"encrypted,omitempty"`
+}
+
 func main() {
 	args, _ := docopt.Parse(usage, nil, true, "0.1-dev", false)
 
 	var (
-		output = args["--output"].(string)
-		keys   = cipherset.Keys{}
-		data   []byte
-		err    error
-		out    struct {
-			Hashname hashname.H            `json:"hashname,omitempty"`
-			Parts    cipherset.Parts       `json:"parts,omitempty"`
-			Keys     cipherset.PrivateKeys `json:"keys,omitempty"`
+		output      = args["--output"].(string)
+		input, _    = args["--input"].(string)
+		encrypt, _  = args["--encrypt"].(bool)
+		decrypt, _  = args["--decrypt"].(bool)
+		passFile, _ = args["--passphrase-file"].(string)
+		out         keyFile
+		data        []byte
+		err         error
+	)
+
+	if input != "" {
+		out, err = readKeyFile(input)
+		assert(err)
+	} else {
+		out, err = generateKeyFile()
+		assert(err)
+		fmt.Fprintf(os.Stderr, "Generated keys for: %s\n", out.Hashname)
+	}
+
+	if encrypt {
+		if out.Encrypted != nil {
+			assert(errors.New("keys are already encrypted"))
+		}
+
+		passphrase, err := readPassphrase(passFile, true)
+		assert(err)
+
+		out.Encrypted, err = cipherset.EncryptPrivateKeys(out.Keys, passphrase)
+		assert(err)
+		out.Keys = nil
+	}
+
+	if decrypt {
+		if out.Encrypted == nil {
+			assert(errors.New("keys are not encrypted"))
+		}
+
+		passphrase, err := readPassphrase(passFile, false)
+		assert(err)
+
+		out.Keys, err = cipherset.DecryptPrivateKeys(out.Encrypted, passphrase)
+		assert(err)
+		out.Encrypted = nil
+
+		hn, err := hashname.FromKeys(cipherset.Keys(out.Keys))
+		assert(err)
+		if out.Hashname != "" && out.Hashname != hn {
+			assert(fmt.Errorf("hashname %s does not match the keys (expected %s)", out.Hashname, hn))
 		}
+	}
+
+	data, err = json.MarshalIndent(out, "", "  ")
+	assert(err)
+
+	if output == "-" {
+		fmt.Println(string(data))
+	} else {
+		err := ioutil.WriteFile(output, data, 0600)
+		assert(err)
+	}
+}
+
+func generateKeyFile() (keyFile, error) {
+	var (
+		out  keyFile
+		keys = cipherset.Keys{}
+		err  error
 	)
 
 	{ // CS 1a
@@ -47,6 +123,12 @@ func main() {
 		keys[0x1a] = k
 	}
 
+	{ // CS 2a
+		k, err := cipherset.GenerateKey(0x2a)
+		assert(err)
+		keys[0x2a] = k
+	}
+
 	{ // CS 3a
 		k, err := cipherset.GenerateKey(0x3a)
 		assert(err)
@@ -56,7 +138,9 @@ func main() {
 	out.Keys = cipherset.PrivateKeys(keys)
 	out.Parts = hashname.PartsFromKeys(keys)
 	out.Hashname, err = hashname.FromIntermediates(out.Parts)
-	assert(err)
+	if err != nil {
+		return out, err
+	}
 
 	if len(out.Keys) == 0 {
 		out.Keys = nil
@@ -65,16 +149,116 @@ func main() {
 		out.Keys = nil
 	}
 
-	data, err = json.MarshalIndent(out, "", "  ")
-	assert(err)
+	return out, nil
+}
 
-	fmt.Fprintf(os.Stderr, "Generated keys for: %s\n", out.Hashname)
+func readKeyFile(path string) (keyFile, error) {
+	var (
+		out keyFile
+	)
 
-	if output == "-" {
-		fmt.Println(string(data))
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return out, err
+	}
+
+	err = json.Unmarshal(data, &out)
+	if err != nil {
+		return out, err
+	}
+
+	if len(out.Keys) == 0 && out.Encrypted == nil {
+		return out, fmt.Errorf("%s contains no keys", path)
+	}
+
+	return out, nil
+}
+
+// readPassphrase reads the passphrase from (in order) the passphrase file,
+// the TH_KEYGEN_PASSPHRASE environment variable or the terminal.
+func readPassphrase(path string, confirm bool) ([]byte, error) {
+	var (
+		passphrase string
+	)
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		passphrase = strings.TrimRight(string(data), "\r\n")
+	} else if env := os.Getenv("TH_KEYGEN_PASSPHRASE"); env != "" {
+		passphrase = env
 	} else {
-		err := ioutil.WriteFile(output, data, 0600)
-		assert(err)
+		r := bufio.NewReader(os.Stdin)
+
+		line, err := prompt(r, "Passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		passphrase = line
+
+		if confirm {
+			line, err = prompt(r, "Confirm passphrase: ")
+			if err != nil {
+				return nil, err
+			}
+			if line != passphrase {
+				return nil, errors.New("passphrases do not match")
+			}
+		}
+	}
+
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+
+	return []byte(passphrase), nil
+}
+
+var errNotTerminal = errors.New("not a terminal")
+
+// prompt reads a line from stdin. The line is not echoed when stdin is a
+// terminal; otherwise it is read from r.
+func prompt(r *bufio.Reader, msg string) (string, error) {
+	fmt.Fprint(os.Stderr, msg)
+
+	line, err := readHiddenLine(os.Stdin)
+	if err == errNotTerminal {
+		line, err = r.ReadString('\n')
+		if err != nil && line != "" {
+			err = nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readLine reads up to (and including) the next newline from f without
+// buffering beyond it.
+func readLine(f *os.File) (string, error) {
+	var (
+		line []byte
+		b    [1]byte
+	)
+
+	for {
+		n, err := f.Read(b[:])
+		if n == 1 {
+			line = append(line, b[0])
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return string(line), nil
+			}
+			return "", err
+		}
 	}
 }
 
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// readHiddenLine is not supported on this platform; the passphrase must be
+// passed with --passphrase-file or $TH_KEYGEN_PASSPHRASE instead.
+func readHiddenLine(f *os.File) (string, error) {
+	return "", errors.New("can't read a passphrase from the terminal on this platform, use --passphrase-file or $TH_KEYGEN_PASSPHRASE")
+}
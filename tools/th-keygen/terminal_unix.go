@@ -0,0 +1,50 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// readHiddenLine reads a line from the terminal f without echoing it.
+// errNotTerminal is returned when f is not a terminal.
+func readHiddenLine(f *os.File) (string, error) {
+	var (
+		fd     = f.Fd()
+		old    syscall.Termios
+		noEcho syscall.Termios
+	)
+
+	if err := ioctlTermios(fd, ioctlGetTermios, &old); err != nil {
+		return "", errNotTerminal
+	}
+
+	noEcho = old
+	noEcho.Lflag &^= syscall.ECHO
+	noEcho.Lflag |= syscall.ICANON | syscall.ISIG
+	noEcho.Iflag |= syscall.ICRNL
+
+	if err := ioctlTermios(fd, ioctlSetTermios, &noEcho); err != nil {
+		return "", err
+	}
+	defer ioctlTermios(fd, ioctlSetTermios, &old)
+
+	line, err := readLine(f)
+
+	// the newline was not echoed either
+	fmt.Fprintln(os.Stderr)
+
+	return line, err
+}
+
+func ioctlTermios(fd, req uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
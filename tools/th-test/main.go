@@ -13,7 +13,7 @@ import (
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/docopt/docopt-go"
 
 	"github.com/telehash/gogotelehash"
-	"github.com/telehash/gogotelehash/internal/util/tracer"
+	"github.com/telehash/gogotelehash/e3x/tracer"
 )
 
 var usage = `Test tool for telehash
@@ -0,0 +1,93 @@
+package http
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/telehash/gogotelehash/transports"
+)
+
+func init() {
+	transports.RegisterAddr(&httpAddr{})
+
+	transports.RegisterResolver("http", func(str string) (net.Addr, error) {
+		if !strings.Contains(str, "://") {
+			str = "http://" + str
+		}
+		return parseAddr(str)
+	})
+}
+
+// httpAddr is the base URL of an HTTP transport:
+//
+//	{"type":"http","url":"https://example.com/telehash"}
+type httpAddr struct {
+	url string
+}
+
+var _ transports.AddrMarshaler = (*httpAddr)(nil)
+
+func parseAddr(s string) (*httpAddr, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, transports.ErrInvalidAddr
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, transports.ErrInvalidAddr
+	}
+
+	if u.Host == "" || u.User != nil || u.Fragment != "" || u.RawQuery != "" {
+		return nil, transports.ErrInvalidAddr
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	if u.Path == "" {
+		u.Path = DefaultPath
+	}
+
+	return &httpAddr{url: u.String()}, nil
+}
+
+func (a *httpAddr) Network() string { return "http" }
+func (a *httpAddr) String() string  { return a.url }
+
+func (a *httpAddr) Equal(other net.Addr) bool {
+	if b, ok := other.(*httpAddr); ok {
+		return a.url == b.url
+	}
+	return false
+}
+
+func (a *httpAddr) MarshalJSON() ([]byte, error) {
+	var desc = struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}{
+		Type: a.Network(),
+		URL:  a.url,
+	}
+
+	return json.Marshal(&desc)
+}
+
+func (a *httpAddr) UnmarshalJSON(data []byte) error {
+	var desc struct {
+		URL string `json:"url"`
+	}
+
+	err := json.Unmarshal(data, &desc)
+	if err != nil {
+		return transports.ErrInvalidAddr
+	}
+
+	addr, err := parseAddr(desc.URL)
+	if err != nil {
+		return err
+	}
+
+	*a = *addr
+	return nil
+}
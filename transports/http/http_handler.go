@@ -0,0 +1,312 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/transportsutil"
+)
+
+// Handler is an http.Handler which serves the sessions of the transport it is
+// used by. It must be mounted on a path prefix (like "/telehash/").
+type Handler struct {
+	attachment transportsutil.Attachment
+}
+
+var _ nethttp.Handler = (*Handler)(nil)
+
+// serverConn is an accepted session.
+type serverConn struct {
+	transport *transport
+	id        string
+	raddr     net.Addr
+	halfPipe  *transportsutil.HalfPipe
+	out       chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mtx      sync.Mutex
+	lastSeen time.Time
+	polling  int
+}
+
+// NewHandler returns a handler which can be passed to a single transport (in
+// Config.Handler).
+func NewHandler() *Handler {
+	return &Handler{}
+}
+
+// ServeHTTP serves the session requests. Service Unavailable is returned when
+// the handler is not used by an open transport.
+func (h *Handler) ServeHTTP(rw nethttp.ResponseWriter, req *nethttp.Request) {
+	t, _ := h.attachment.Owner().(*transport)
+	if t == nil {
+		nethttp.Error(rw, "telehash transport is not available", nethttp.StatusServiceUnavailable)
+		return
+	}
+
+	rw.Header().Set("Cache-Control", "no-store")
+
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	n := len(parts)
+
+	switch {
+	case parts[n-1] == "sessions":
+		if req.Method != "POST" {
+			rw.Header().Set("Allow", "POST")
+			nethttp.Error(rw, "method not allowed", nethttp.StatusMethodNotAllowed)
+			return
+		}
+		t.serveOpen(rw, req)
+
+	case n >= 2 && parts[n-2] == "sessions":
+		t.mtx.Lock()
+		c := t.sessions[parts[n-1]]
+		t.mtx.Unlock()
+
+		if c == nil {
+			nethttp.NotFound(rw, req)
+			return
+		}
+
+		switch req.Method {
+		case "GET":
+			c.serveMessages(rw, req)
+		case "POST":
+			c.receiveMessages(rw, req)
+		case "DELETE":
+			c.Close()
+			rw.WriteHeader(nethttp.StatusNoContent)
+		default:
+			rw.Header().Set("Allow", "GET, POST, DELETE")
+			nethttp.Error(rw, "method not allowed", nethttp.StatusMethodNotAllowed)
+		}
+
+	default:
+		nethttp.NotFound(rw, req)
+	}
+}
+
+func (t *transport) serveOpen(rw nethttp.ResponseWriter, req *nethttp.Request) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		nethttp.Error(rw, "internal error", nethttp.StatusInternalServerError)
+		return
+	}
+
+	c := &serverConn{
+		transport: t,
+		id:        hex.EncodeToString(id[:]),
+		raddr:     &httpAddr{url: (&url.URL{Scheme: "http", Host: req.RemoteAddr}).String()},
+		halfPipe:  transportsutil.NewHalfPipe(),
+		out:       make(chan []byte, outQueueSize),
+		done:      make(chan struct{}),
+		lastSeen:  time.Now(),
+	}
+
+	t.mtx.Lock()
+	t.sessions[c.id] = c
+	t.mtx.Unlock()
+
+	select {
+	case t.accept <- c:
+	case <-t.done:
+		c.Close()
+		nethttp.Error(rw, "telehash transport is not available", nethttp.StatusServiceUnavailable)
+		return
+	case <-req.Context().Done():
+		c.Close()
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/plain")
+	rw.WriteHeader(nethttp.StatusCreated)
+	io.WriteString(rw, c.id)
+}
+
+// serveMessages streams queued messages to the client until PollTimeout
+// expires. In long-poll mode the response ends after the first batch of
+// messages.
+func (c *serverConn) serveMessages(rw nethttp.ResponseWriter, req *nethttp.Request) {
+	c.touch(1)
+	defer c.touch(-1)
+
+	longPoll := req.URL.Query().Get("mode") == "poll"
+	flusher, _ := rw.(nethttp.Flusher)
+
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.WriteHeader(nethttp.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	timer := time.NewTimer(PollTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case msg := <-c.out:
+			if _, err := rw.Write(frame(msg)); err != nil {
+				return
+			}
+
+			// write the messages which are queued already
+			for drained := false; !drained; {
+				select {
+				case msg := <-c.out:
+					if _, err := rw.Write(frame(msg)); err != nil {
+						return
+					}
+				default:
+					drained = true
+				}
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+			if longPoll {
+				return
+			}
+
+		case <-timer.C:
+			return
+		case <-req.Context().Done():
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// receiveMessages reads the messages in the request body.
+func (c *serverConn) receiveMessages(rw nethttp.ResponseWriter, req *nethttp.Request) {
+	c.touch(0)
+
+	body := nethttp.MaxBytesReader(rw, req.Body, maxBodySize)
+	err := readMessages(body, c.halfPipe.PushMessage)
+	if err != nil {
+		nethttp.Error(rw, "invalid messages", nethttp.StatusBadRequest)
+		return
+	}
+
+	rw.WriteHeader(nethttp.StatusNoContent)
+}
+
+// touch marks the session as used and updates the number of active GET
+// requests by delta.
+func (c *serverConn) touch(delta int) {
+	c.mtx.Lock()
+	c.polling += delta
+	c.lastSeen = time.Now()
+	c.mtx.Unlock()
+}
+
+func (c *serverConn) idle(now time.Time) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.polling == 0 && now.Sub(c.lastSeen) > idleTimeout
+}
+
+// reapSessions closes the sessions which are no longer used by their client.
+func (t *transport) reapSessions() {
+	ticker := time.NewTicker(idleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case now := <-ticker.C:
+			var idle []*serverConn
+
+			t.mtx.Lock()
+			for _, c := range t.sessions {
+				if c.idle(now) {
+					idle = append(idle, c)
+				}
+			}
+			t.mtx.Unlock()
+
+			for _, c := range idle {
+				c.Close()
+			}
+		}
+	}
+}
+
+func (c *serverConn) Read(b []byte) (n int, err error) {
+	return c.halfPipe.Read(b)
+}
+
+// Write queues b for the client. Like a datagram the message is dropped when
+// the client doesn't keep up.
+func (c *serverConn) Write(b []byte) (n int, err error) {
+	if len(b) > transports.DefaultMaxMessageSize {
+		return 0, io.ErrShortWrite
+	}
+
+	msg := append([]byte(nil), b...)
+
+	select {
+	case <-c.done:
+		return 0, io.EOF
+	default:
+	}
+
+	select {
+	case c.out <- msg:
+	default:
+	}
+
+	return len(b), nil
+}
+
+func (c *serverConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.halfPipe.Close()
+
+		c.transport.mtx.Lock()
+		delete(c.transport.sessions, c.id)
+		c.transport.mtx.Unlock()
+	})
+	return nil
+}
+
+func (c *serverConn) LocalAddr() net.Addr {
+	return c.transport.localAddr()
+}
+
+func (c *serverConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *serverConn) SetDeadline(t time.Time) error {
+	return c.halfPipe.SetReadDeadline(t)
+}
+
+func (c *serverConn) SetReadDeadline(t time.Time) error {
+	return c.halfPipe.SetReadDeadline(t)
+}
+
+func (c *serverConn) SetWriteDeadline(t time.Time) error {
+	// noop
+	return nil
+}
+
+func validSessionID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,504 @@
+// Package http implements a transport which tunnels messages over HTTP(S).
+//
+// It is a fallback for networks where neither UDP nor TCP (to arbitrary ports)
+// work. A client opens a session with a POST request, sends messages with POST
+// requests and receives messages from a streaming GET request. The GET request
+// is renewed every PollTimeout; in long-poll mode (for proxies which buffer
+// responses) it is renewed after every batch of messages. Messages are
+// prefixed with their 2 byte length (like in the tcp transport).
+//
+//	POST   {url}/sessions       open a session (the response body is its id)
+//	GET    {url}/sessions/{id}  receive messages (?mode=poll for long polling)
+//	POST   {url}/sessions/{id}  send messages
+//	DELETE {url}/sessions/{id}  close the session
+//
+// The transport can listen on its own:
+//
+//	e3x.New(keys, http.Config{Addr: ":8080"})
+//
+// or serve the sessions from a Handler mounted on an existing http.Server:
+//
+//	h := http.NewHandler()
+//	mux.Handle("/telehash/", h)
+//	e3x.New(keys, http.Config{Handler: h, URLs: []string{"https://example.com/telehash"}})
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	nethttp "net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/transportsutil"
+)
+
+// DefaultPath is the path at which the transport serves sessions when it
+// listens on its own.
+const DefaultPath = "/telehash"
+
+const (
+	// PollTimeout is the maximum duration of a GET request.
+	PollTimeout = 25 * time.Second
+
+	idleTimeout    = 2 * PollTimeout // sessions without requests are closed
+	requestTimeout = 10 * time.Second
+	maxBodySize    = 64 * 1024
+	outQueueSize   = 128
+	maxPollErrors  = 3
+)
+
+// Config for the HTTP transport. The zero value listens on a random port on
+// all interfaces.
+type Config struct {
+	// Can be set to an address and/or port (like the tcp transport).
+	// Ignored when Handler is set.
+	Addr string
+
+	// The path at which sessions are served. Defaults to DefaultPath.
+	// Ignored when Handler is set.
+	Path string
+
+	// When set the transport does not listen on its own. Instead it serves
+	// the sessions of Handler (which must be mounted on an http.Server by the
+	// caller).
+	Handler *Handler
+
+	// The http:// or https:// URLs at which the transport is reachable.
+	// Required when Handler is set; otherwise the URLs are derived from the
+	// listener.
+	URLs []string
+
+	// Used to dial https:// URLs. When it holds certificates the transport
+	// serves https:// on its own listener.
+	TLSConfig *tls.Config
+
+	// When set dialed sessions receive messages with long polling instead of
+	// streaming responses.
+	LongPoll bool
+}
+
+type transport struct {
+	addrs    []net.Addr
+	server   *nethttp.Server
+	handler  *Handler
+	client   *nethttp.Client
+	longPoll bool
+
+	accept    chan *serverConn
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mtx      sync.Mutex
+	sessions map[string]*serverConn
+	clients  map[*clientConn]struct{}
+}
+
+// clientConn is a dialed session.
+type clientConn struct {
+	transport *transport
+	raddr     *httpAddr
+	url       string // of the session
+	halfPipe  *transportsutil.HalfPipe
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+var (
+	_ transports.Transport = (*transport)(nil)
+	_ transports.Config    = Config{}
+)
+
+// Open opens the transport.
+func (c Config) Open() (transports.Transport, error) {
+	t := &transport{
+		longPoll: c.LongPoll,
+		accept:   make(chan *serverConn),
+		done:     make(chan struct{}),
+		sessions: make(map[string]*serverConn),
+		clients:  make(map[*clientConn]struct{}),
+	}
+
+	var clientTLS *tls.Config
+	if c.TLSConfig != nil {
+		clientTLS = c.TLSConfig.Clone()
+	}
+	t.client = &nethttp.Client{Transport: &nethttp.Transport{
+		Proxy:               nethttp.ProxyFromEnvironment,
+		TLSClientConfig:     clientTLS,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     idleTimeout,
+	}}
+
+	for _, s := range c.URLs {
+		addr, err := parseAddr(s)
+		if err != nil {
+			return nil, errors.New("http: invalid URL " + s)
+		}
+		t.addrs = append(t.addrs, addr)
+	}
+
+	if c.Handler != nil {
+		if len(t.addrs) == 0 {
+			return nil, errors.New("http: URLs are required when using a Handler")
+		}
+
+		err := c.Handler.attachment.Attach(t)
+		if err != nil {
+			return nil, err
+		}
+
+		t.handler = c.Handler
+		go t.reapSessions()
+		return t, nil
+	}
+
+	if c.Addr == "" {
+		c.Addr = ":0"
+	}
+	if c.Path == "" {
+		c.Path = DefaultPath
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		return nil, errors.New("http: Path must start with a `/`")
+	}
+	c.Path = strings.TrimSuffix(c.Path, "/")
+
+	listener, err := net.Listen("tcp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "http"
+	if c.TLSConfig != nil && (len(c.TLSConfig.Certificates) > 0 || c.TLSConfig.GetCertificate != nil) {
+		scheme = "https"
+		listener = tls.NewListener(listener, c.TLSConfig)
+	}
+
+	if len(t.addrs) == 0 {
+		for _, u := range transportsutil.ListenerURLs(listener.Addr().(*net.TCPAddr), scheme, c.Path) {
+			t.addrs = append(t.addrs, &httpAddr{url: u})
+		}
+	}
+
+	h := NewHandler()
+	h.attachment.Attach(t)
+	t.handler = h
+
+	mux := nethttp.NewServeMux()
+	mux.Handle(c.Path+"/", h)
+
+	t.server = &nethttp.Server{Handler: mux}
+	go t.server.Serve(listener)
+	go t.reapSessions()
+
+	return t, nil
+}
+
+func (t *transport) Addrs() []net.Addr {
+	return append([]net.Addr(nil), t.addrs...)
+}
+
+func (t *transport) localAddr() net.Addr {
+	if len(t.addrs) > 0 {
+		return t.addrs[0]
+	}
+	return nil
+}
+
+// Dial opens a session at addr.
+func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
+	x, ok := addr.(*httpAddr)
+	if !ok {
+		return nil, transports.ErrInvalidAddr
+	}
+
+	select {
+	case <-t.done:
+		return nil, io.EOF
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	req, err := nethttp.NewRequest("POST", x.url+"/sessions", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != nethttp.StatusCreated {
+		return nil, errors.New("http: unexpected response: " + resp.Status)
+	}
+
+	id, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return nil, err
+	}
+	if !validSessionID(string(id)) {
+		return nil, errors.New("http: invalid session id")
+	}
+
+	c := &clientConn{
+		transport: t,
+		raddr:     x,
+		url:       x.url + "/sessions/" + string(id),
+		halfPipe:  transportsutil.NewHalfPipe(),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	t.mtx.Lock()
+	t.clients[c] = struct{}{}
+	t.mtx.Unlock()
+
+	go c.poll()
+
+	return c, nil
+}
+
+func (t *transport) Accept() (c net.Conn, err error) {
+	select {
+	case conn := <-t.accept:
+		return conn, nil
+	case <-t.done:
+		return nil, io.EOF
+	}
+}
+
+func (t *transport) Close() error {
+	var err error
+
+	t.closeOnce.Do(func() {
+		close(t.done)
+		t.handler.attachment.Detach(t)
+
+		if t.server != nil {
+			err = t.server.Close()
+		}
+
+		var (
+			sessions []*serverConn
+			clients  []*clientConn
+		)
+
+		t.mtx.Lock()
+		for _, c := range t.sessions {
+			sessions = append(sessions, c)
+		}
+		for c := range t.clients {
+			clients = append(clients, c)
+		}
+		t.mtx.Unlock()
+
+		for _, c := range sessions {
+			c.Close()
+		}
+		for _, c := range clients {
+			c.Close()
+		}
+	})
+
+	return err
+}
+
+// poll receives messages until the session is closed.
+func (c *clientConn) poll() {
+	var (
+		failures int
+		target   = c.url
+	)
+
+	if c.transport.longPoll {
+		target += "?mode=poll"
+	}
+
+	for failures < maxPollErrors {
+		req, err := nethttp.NewRequest("GET", target, nil)
+		if err != nil {
+			break
+		}
+
+		resp, err := c.transport.client.Do(req.WithContext(c.ctx))
+		if c.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			failures++
+			time.Sleep(time.Duration(failures) * time.Second)
+			continue
+		}
+
+		if resp.StatusCode == nethttp.StatusNotFound {
+			resp.Body.Close()
+			break
+		}
+		if resp.StatusCode != nethttp.StatusOK {
+			resp.Body.Close()
+			failures++
+			time.Sleep(time.Duration(failures) * time.Second)
+			continue
+		}
+
+		failures = 0
+		readMessages(resp.Body, c.halfPipe.PushMessage)
+		resp.Body.Close()
+	}
+
+	c.close(false)
+}
+
+func (c *clientConn) Read(b []byte) (n int, err error) {
+	return c.halfPipe.Read(b)
+}
+
+func (c *clientConn) Write(b []byte) (n int, err error) {
+	if len(b) > transports.DefaultMaxMessageSize {
+		return 0, io.ErrShortWrite
+	}
+
+	if c.ctx.Err() != nil {
+		return 0, io.EOF
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, requestTimeout)
+	defer cancel()
+
+	req, err := nethttp.NewRequest("POST", c.url, bytes.NewReader(frame(b)))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := c.transport.client.Do(req.WithContext(ctx))
+	if err != nil {
+		if c.ctx.Err() != nil {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case nethttp.StatusNoContent:
+		return len(b), nil
+	case nethttp.StatusNotFound:
+		c.close(false)
+		return 0, io.EOF
+	default:
+		return 0, errors.New("http: unexpected response: " + resp.Status)
+	}
+}
+
+// Close closes the session.
+func (c *clientConn) Close() error {
+	c.close(true)
+	return nil
+}
+
+func (c *clientConn) close(notify bool) {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.halfPipe.Close()
+
+		c.transport.mtx.Lock()
+		delete(c.transport.clients, c)
+		c.transport.mtx.Unlock()
+
+		if notify {
+			go c.sendDelete()
+		}
+	})
+}
+
+func (c *clientConn) sendDelete() {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	req, err := nethttp.NewRequest("DELETE", c.url, nil)
+	if err != nil {
+		return
+	}
+
+	resp, err := c.transport.client.Do(req.WithContext(ctx))
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (c *clientConn) LocalAddr() net.Addr {
+	return c.transport.localAddr()
+}
+
+func (c *clientConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *clientConn) SetDeadline(t time.Time) error {
+	return c.halfPipe.SetReadDeadline(t)
+}
+
+func (c *clientConn) SetReadDeadline(t time.Time) error {
+	return c.halfPipe.SetReadDeadline(t)
+}
+
+func (c *clientConn) SetWriteDeadline(t time.Time) error {
+	// noop
+	return nil
+}
+
+// frame prefixes msg with its length.
+func frame(msg []byte) []byte {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	return buf
+}
+
+// readMessages reads framed messages from r until r is exhausted or a message
+// is too large.
+func readMessages(r io.Reader, fn func(msg []byte)) error {
+	var (
+		bufr = bufio.NewReader(r)
+		hdr  [2]byte
+		buf  [transports.DefaultMaxMessageSize]byte
+	)
+
+	for {
+		_, err := io.ReadFull(bufr, hdr[:])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		msgLen := int(binary.BigEndian.Uint16(hdr[:]))
+		if msgLen > transports.DefaultMaxMessageSize {
+			return io.ErrShortBuffer
+		}
+
+		_, err = io.ReadFull(bufr, buf[:msgLen])
+		if err != nil {
+			return err
+		}
+
+		fn(buf[:msgLen])
+	}
+}
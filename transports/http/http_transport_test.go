@@ -0,0 +1,77 @@
+package http
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/transportstest"
+)
+
+func TestAddr(t *testing.T) {
+	assert := assert.New(t)
+
+	transportstest.Addr(t, "http", "127.0.0.1:8080",
+		"http://127.0.0.1:8080/telehash",
+		`{"type":"http","url":"http://127.0.0.1:8080/telehash"}`)
+
+	_, err := transports.DecodeAddr([]byte(`{"type":"http","url":"ws://127.0.0.1/"}`))
+	assert.Equal(transports.ErrInvalidAddr, err)
+}
+
+func TestSessions(t *testing.T) {
+	testSessions(t, false)
+}
+
+func TestLongPollSessions(t *testing.T) {
+	testSessions(t, true)
+}
+
+func testSessions(t *testing.T, longPoll bool) {
+	assert := assert.New(t)
+
+	h := NewHandler()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	B, err := Config{Handler: h, URLs: []string{srv.URL + "/telehash"}}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	_, err = Config{Handler: h, URLs: []string{srv.URL}}.Open()
+	assert.Error(err, "a handler can only be used by one transport")
+
+	A, err := Config{Addr: "127.0.0.1:0", LongPoll: longPoll}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	w, r := transportstest.Transport(t, A, B)
+	defer w.Close()
+
+	for i := 0; i < 2; i++ {
+		transportstest.Exchange(t, w, r)
+		transportstest.Exchange(t, r, w)
+	}
+
+	// closing the dialed side ends the session
+	w.Close()
+	_, err = r.Read(make([]byte, 1500))
+	assert.Equal(io.EOF, err)
+}
+
+func TestEndpoints(t *testing.T) {
+	h := NewHandler()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	transportstest.Endpoints(t,
+		Config{Handler: h, URLs: []string{srv.URL}},
+		Config{Addr: "127.0.0.1:0"})
+}
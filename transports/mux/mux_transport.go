@@ -56,6 +56,11 @@ func (c Config) Open() (transports.Transport, error) {
 	return t, nil
 }
 
+// Transports returns the opened sub-transports.
+func (t *transport) Transports() []transports.Transport {
+	return append([]transports.Transport(nil), t.transports...)
+}
+
 func (t *transport) Addrs() []net.Addr {
 	var addrs []net.Addr
 
@@ -7,6 +7,7 @@ package nat
 import (
 	"fmt"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -52,6 +53,12 @@ type transport struct {
 	mapping map[string]*natMapping
 }
 
+// Mapping is a port mapping of the NAT gateway.
+type Mapping struct {
+	Internal net.Addr
+	External net.Addr
+}
+
 type natMapping struct {
 	external net.Addr
 	internal net.Addr
@@ -76,6 +83,42 @@ func (c Config) Open() (transports.Transport, error) {
 	return nat, nil
 }
 
+// Mappings returns the active port mappings of t. t must be a nat transport or
+// a transport which wraps other transports (like mux); otherwise nil is
+// returned.
+func Mappings(t transports.Transport) []Mapping {
+	switch x := t.(type) {
+	case *transport:
+		var mappings []Mapping
+		x.mtx.RLock()
+		for _, m := range x.mapping {
+			mappings = append(mappings, Mapping{Internal: m.internal, External: m.external})
+		}
+		x.mtx.RUnlock()
+
+		sort.Sort(mappingsByAddr(mappings))
+		return append(mappings, Mappings(x.t)...)
+
+	case interface {
+		Transports() []transports.Transport
+	}:
+		var mappings []Mapping
+		for _, sub := range x.Transports() {
+			mappings = append(mappings, Mappings(sub)...)
+		}
+		return mappings
+
+	default:
+		return nil
+	}
+}
+
+type mappingsByAddr []Mapping
+
+func (s mappingsByAddr) Len() int           { return len(s) }
+func (s mappingsByAddr) Less(i, j int) bool { return s[i].Internal.String() < s[j].Internal.String() }
+func (s mappingsByAddr) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 func (t *transport) Addrs() []net.Addr {
 	addrs := t.t.Addrs()
 
@@ -1,13 +1,34 @@
 // Package tcp implements the TCP transport.
+//
+// When reuse is enabled connections are pooled by the address of the remote
+// transport: Dial reuses an open connection (dialed or accepted) to the same
+// peer. Every Dial and Accept caller gets its own net.Conn and the connection
+// is closed when the last of them is closed. To make this work for accepted connections the dialing side starts
+// every connection with a hello frame (an empty frame followed by a frame with
+// the 8 byte id of the transport and the 2 byte port it listens on). The
+// accepting side replies with an empty frame followed by a 1 byte frame which
+// tells whether the connection was accepted. A connection is only returned by
+// Dial or Accept once this exchange completed.
+//
+// When two transports dial each other at the same time the transport with the
+// higher id rejects the connection dialed by the other one; the other
+// transport then uses the connection it accepts instead. A hello never
+// replaces a pooled connection.
+//
+// Every transport reads and replies to hello frames, but only transports with
+// EnableReuse send them.
 package tcp
 
 import (
 	"bufio"
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"io"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/telehash/gogotelehash/transports"
@@ -27,8 +48,52 @@ type Config struct {
 	// When port is unspecified ("127.0.0.1") a random port will be chosen.
 	// When ip is unspecified (":3000") the transport will listen on all interfaces.
 	Addr string
+
+	// The largest message (in bytes) that can be sent over a connection.
+	// Defaults to DefaultMaxFrameSize and can't exceed MaxFrameSize. Larger
+	// messages sent by the peer are dropped.
+	MaxFrameSize int
+
+	// Connections without any traffic are closed after IdleTimeout.
+	// Defaults to DefaultIdleTimeout; a negative value disables it.
+	IdleTimeout time.Duration
+
+	// The maximum number of open connections. The least recently used
+	// connection is closed to make room for a new one.
+	// Defaults to DefaultMaxConns; a negative value disables the limit.
+	MaxConns int
+
+	// When set connections are shared by all the Dial and Accept callers for
+	// the same peer. Every dialed connection then starts with a hello frame;
+	// only enable it when all peers use a transport which replies to it.
+	EnableReuse bool
 }
 
+const (
+	// DefaultMaxFrameSize is the default maximum message size of a connection.
+	DefaultMaxFrameSize = 16 * 1024
+
+	// MaxFrameSize is the largest message size supported by the 2 byte
+	// length prefix.
+	MaxFrameSize = 0xffff
+
+	// DefaultIdleTimeout is the default idle timeout of a connection.
+	DefaultIdleTimeout = 5 * time.Minute
+
+	// DefaultMaxConns is the default maximum number of open connections.
+	DefaultMaxConns = 256
+)
+
+const (
+	helloLen     = 10 // id (8 bytes) and port (2 bytes)
+	helloTimeout = 10 * time.Second
+
+	replyRejected = 0
+	replyAccepted = 1
+)
+
+var errRejected = errors.New("tcp: connection was rejected by the peer")
+
 const (
 	// TCPv4 is used for IPv4 TCP networks
 	TCPv4 = "tcp4"
@@ -37,23 +102,61 @@ const (
 )
 
 type transport struct {
-	net      string
-	laddr    tcpAddr
-	listener *net.TCPListener
+	net          string
+	laddr        tcpAddr
+	listener     *net.TCPListener
+	id           uint64
+	maxFrameSize int
+	idleTimeout  time.Duration
+	maxConns     int
+	reuse        bool
+
+	accept    chan *handle
+	acceptErr chan error
+	done      chan struct{}
+
+	mtx     sync.Mutex
+	changed *sync.Cond // signaled when peers or dialing change
+	closed  bool
+	conns   map[*connection]struct{}
+	peers   map[string]*connection // shared connections by remote address
+	dialing map[string]int         // pending dials by remote address
 }
 
+// connection is a TCP connection. It is shared by the handles returned by
+// Dial and Accept and it is closed when the last handle is closed.
 type connection struct {
 	transport *transport
 	raddr     tcpAddr
 	conn      *net.TCPConn
 	bufr      *bufio.Reader
 	mtxWrite  sync.Mutex
-	mtxRead   sync.Mutex
+
+	dialerID uint64 // id of the transport which dialed the connection
+	shared   bool   // pooled for raddr
+	refs     int    // open handles; guarded by transport.mtx
+	lastUsed int64  // unix nanoseconds; accessed atomically
+
+	mtxRead sync.Mutex
+	cndRead *sync.Cond
+	frames  [][]byte // read by readFrames
+	readErr error
+}
+
+// handle is the net.Conn returned to a single Dial or Accept caller.
+type handle struct {
+	c *connection
+
+	// guarded by c.mtxRead
+	closed        bool
+	deadline      time.Time
+	deadlineTimer *time.Timer
 }
 
 var (
-	_ transports.Transport = (*transport)(nil)
-	_ transports.Config    = Config{}
+	_ transports.Transport    = (*transport)(nil)
+	_ transports.Config       = Config{}
+	_ transports.MessageSizer = (*handle)(nil)
 )
 
 // Open opens the transport.
@@ -70,10 +173,24 @@ func (c Config) Open() (transports.Transport, error) {
 		c.Addr = ":0"
 	}
 
+	if c.MaxFrameSize == 0 {
+		c.MaxFrameSize = DefaultMaxFrameSize
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = DefaultIdleTimeout
+	}
+	if c.MaxConns == 0 {
+		c.MaxConns = DefaultMaxConns
+	}
+
 	if c.Network != TCPv4 && c.Network != TCPv6 {
 		return nil, errors.New("tcp: Network must be either `tcp4` or `tcp6`")
 	}
 
+	if c.MaxFrameSize < transports.DefaultMaxMessageSize || c.MaxFrameSize > MaxFrameSize {
+		return nil, errors.New("tcp: MaxFrameSize must be between 1472 and 65535")
+	}
+
 	{ // parse and verify source address
 		addr, err = net.ResolveTCPAddr(c.Network, c.Addr)
 		if err != nil {
@@ -96,7 +213,36 @@ func (c Config) Open() (transports.Transport, error) {
 
 	addr = listener.Addr().(*net.TCPAddr)
 
-	return &transport{net: c.Network, laddr: wrapAddr(addr), listener: listener}, nil
+	var id [8]byte
+	if _, err = rand.Read(id[:]); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	t := &transport{
+		net:          c.Network,
+		laddr:        wrapAddr(addr),
+		listener:     listener,
+		id:           binary.BigEndian.Uint64(id[:]),
+		maxFrameSize: c.MaxFrameSize,
+		idleTimeout:  c.IdleTimeout,
+		maxConns:     c.MaxConns,
+		reuse:        c.EnableReuse,
+		accept:       make(chan *handle),
+		acceptErr:    make(chan error, 1),
+		done:         make(chan struct{}),
+		conns:        make(map[*connection]struct{}),
+		peers:        make(map[string]*connection),
+		dialing:      make(map[string]int),
+	}
+	t.changed = sync.NewCond(&t.mtx)
+
+	go t.acceptConnections()
+	if t.idleTimeout > 0 {
+		go t.reapConnections()
+	}
+
+	return t, nil
 }
 
 func (t *transport) Addrs() []net.Addr {
@@ -135,12 +281,15 @@ func (t *transport) Addrs() []net.Addr {
 func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
 	switch x := addr.(type) {
 	case tcpAddr:
-		conn, err := net.DialTCP("tcp", nil, x.ToTCPAddr())
+		if h := t.lookup(x); h != nil {
+			return h, nil
+		}
+
+		h, err := t.dial(x)
 		if err != nil {
 			return nil, err
 		}
-
-		return &connection{transport: t, raddr: x, conn: conn, bufr: bufio.NewReader(conn)}, nil
+		return h, nil
 	case *net.TCPAddr:
 		return t.Dial(wrapAddr(x))
 	default:
@@ -148,43 +297,505 @@ func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
 	}
 }
 
-func (t *transport) Accept() (c net.Conn, err error) {
-	tconn, err := t.listener.AcceptTCP()
+// lookup returns a new handle for the shared connection to addr (or nil).
+func (t *transport) lookup(addr tcpAddr) *handle {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if c := t.peers[addr.String()]; c != nil {
+		return c.newHandle()
+	}
+	return nil
+}
+
+// dial opens a new connection to addr and adds it to the pool. When the peer
+// rejected the connection (or a connection to the peer was pooled in the mean
+// time) the pooled connection is used instead.
+func (t *transport) dial(addr tcpAddr) (*handle, error) {
+	var (
+		key     = addr.String()
+		h       *handle
+		closing []*connection
+	)
+
+	t.mtx.Lock()
+	t.dialing[key]++
+	t.mtx.Unlock()
+
+	c, err := t.connect(addr)
+
+	t.mtx.Lock()
+	if t.dialing[key]--; t.dialing[key] == 0 {
+		delete(t.dialing, key)
+	}
+	t.changed.Broadcast()
+
+	switch {
+	case err != nil:
+	case t.closed:
+		if c != nil {
+			closing = append(closing, c)
+		}
+		err = io.EOF
+	case c == nil:
+		h, err = t.await(key)
+	case !c.shared:
+		h, closing = t.add(c)
+	case t.peers[key] != nil:
+		// the connection dialed by the peer was registered first
+		h = t.peers[key].newHandle()
+		closing = append(closing, c)
+	default:
+		t.peers[key] = c
+		h, closing = t.add(c)
+	}
+
+	t.mtx.Unlock()
+
+	for _, x := range closing {
+		x.conn.Close()
+	}
+
+	return h, err
+}
+
+// connect opens a TCP connection to addr. With reuse enabled it sends the
+// hello frame and returns nil when the peer rejected the connection.
+func (t *transport) connect(addr tcpAddr) (*connection, error) {
+	tconn, err := net.DialTCP("tcp", nil, addr.ToTCPAddr())
 	if err != nil {
 		return nil, err
 	}
 
-	raddr := tconn.RemoteAddr().(*net.TCPAddr)
+	c := t.newConnection(tconn, addr, t.id)
+	if !t.reuse {
+		return c, nil
+	}
+
+	var accepted bool
+	tconn.SetDeadline(time.Now().Add(helloTimeout))
+	err = c.writeHello()
+	if err == nil {
+		accepted, err = c.readReply()
+	}
+	tconn.SetDeadline(time.Time{})
+
+	if err != nil || !accepted {
+		tconn.Close()
+		return nil, err
+	}
+
+	c.shared = true
+	return c, nil
+}
+
+// await waits until the peer's connection to key is registered and returns a
+// handle for it. The caller must hold t.mtx.
+func (t *transport) await(key string) (*handle, error) {
+	timer := time.AfterFunc(helloTimeout, func() {
+		t.mtx.Lock()
+		t.changed.Broadcast()
+		t.mtx.Unlock()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(helloTimeout)
+	for {
+		if c := t.peers[key]; c != nil {
+			return c.newHandle(), nil
+		}
+		if t.closed {
+			return nil, io.EOF
+		}
+		if !time.Now().Before(deadline) {
+			return nil, errRejected
+		}
+		t.changed.Wait()
+	}
+}
+
+func (t *transport) Accept() (c net.Conn, err error) {
+	select {
+	case h := <-t.accept:
+		return h, nil
+	case err := <-t.acceptErr:
+		return nil, err
+	case <-t.done:
+		return nil, io.EOF
+	}
+}
+
+func (t *transport) acceptConnections() {
+	for {
+		tconn, err := t.listener.AcceptTCP()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				t.acceptErr <- err
+			}
+			return
+		}
 
-	conn := &connection{transport: t, raddr: wrapAddr(raddr), conn: tconn, bufr: bufio.NewReader(tconn)}
-	return conn, nil
+		go t.handshake(tconn)
+	}
+}
+
+// handshake reads the hello frame of an accepted connection (when the peer
+// sent one), decides whether the connection is kept and queues it for Accept.
+func (t *transport) handshake(tconn *net.TCPConn) {
+	var (
+		c        = t.newConnection(tconn, wrapAddr(tconn.RemoteAddr().(*net.TCPAddr)), 0)
+		accepted = true
+		h        *handle
+		closing  []*connection
+	)
+
+	tconn.SetDeadline(time.Now().Add(helloTimeout))
+	id, port, hello, err := c.readHello()
+	tconn.SetReadDeadline(time.Time{})
+	if err != nil {
+		tconn.Close()
+		return
+	}
+
+	if hello {
+		// the peer can be dialed at the port it listens on
+		c.raddr = wrapAddr(&net.TCPAddr{IP: c.raddr.GetIP(), Port: int(port)})
+		c.dialerID = id
+	}
+
+	// nothing is written to the connection before the reply
+	c.mtxWrite.Lock()
+
+	key := c.raddr.String()
+	t.mtx.Lock()
+	if hello && t.reuse && id != t.id {
+		// the transport with the lower id waits for its own dial to
+		// complete; the peer rejects (or already accepted) it.
+		for !t.closed && t.dialing[key] > 0 && t.id < id {
+			t.changed.Wait()
+		}
+	}
+	switch {
+	case t.closed:
+		accepted = false
+	case !hello || !t.reuse || id == t.id:
+		// not shared
+	case t.dialing[key] > 0:
+		// the connection we are dialing wins
+		accepted = false
+	case t.peers[key] != nil:
+		// the hello can't be verified; it never replaces a pooled connection
+	default:
+		c.shared = true
+		t.peers[key] = c
+		t.changed.Broadcast()
+	}
+	if accepted {
+		h, closing = t.add(c)
+	}
+	t.mtx.Unlock()
+
+	if hello {
+		err = c.writeReply(accepted)
+	}
+	tconn.SetWriteDeadline(time.Time{})
+	c.mtxWrite.Unlock()
+
+	for _, x := range closing {
+		x.conn.Close()
+	}
+
+	if h == nil {
+		tconn.Close()
+		return
+	}
+	if err != nil {
+		h.Close()
+		return
+	}
+
+	select {
+	case t.accept <- h:
+	case <-t.done:
+		h.Close()
+	}
+}
+
+// add adds c to the pool, starts reading its frames and returns a handle for
+// it. The least recently used connections which were removed to make room for
+// c are returned too; the caller must close them. The caller must hold t.mtx.
+func (t *transport) add(c *connection) (*handle, []*connection) {
+	var closing []*connection
+
+	t.conns[c] = struct{}{}
+	for t.maxConns > 0 && len(t.conns) > t.maxConns {
+		lru := t.leastRecentlyUsed(c)
+		t.remove(lru)
+		closing = append(closing, lru)
+	}
+
+	go c.readFrames()
+	return c.newHandle(), closing
+}
+
+// remove removes c from the pool. The caller must hold t.mtx.
+func (t *transport) remove(c *connection) {
+	delete(t.conns, c)
+	if key := c.raddr.String(); t.peers[key] == c {
+		delete(t.peers, key)
+	}
+}
+
+// leastRecentlyUsed returns the least recently used connection other than
+// except. The caller must hold t.mtx.
+func (t *transport) leastRecentlyUsed(except *connection) *connection {
+	var lru *connection
+	for c := range t.conns {
+		if c != except && (lru == nil || c.getLastUsed() < lru.getLastUsed()) {
+			lru = c
+		}
+	}
+	return lru
+}
+
+// reapConnections closes the connections which were idle for longer than
+// the idle timeout.
+func (t *transport) reapConnections() {
+	ticker := time.NewTicker(t.idleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case now := <-ticker.C:
+			var (
+				idle     []*connection
+				deadline = now.Add(-t.idleTimeout).UnixNano()
+			)
+
+			t.mtx.Lock()
+			for c := range t.conns {
+				if c.getLastUsed() < deadline {
+					t.remove(c)
+					idle = append(idle, c)
+				}
+			}
+			t.mtx.Unlock()
+
+			for _, c := range idle {
+				c.conn.Close()
+			}
+		}
+	}
 }
 
 func (t *transport) Close() error {
-	return t.listener.Close()
+	t.mtx.Lock()
+	if t.closed {
+		t.mtx.Unlock()
+		return nil
+	}
+	t.closed = true
+	conns := t.conns
+	t.conns = make(map[*connection]struct{})
+	t.peers = make(map[string]*connection)
+	close(t.done)
+	t.changed.Broadcast()
+	t.mtx.Unlock()
+
+	err := t.listener.Close()
+	for c := range conns {
+		c.conn.Close()
+	}
+	return err
 }
 
-func (c *connection) Read(b []byte) (n int, err error) {
-	var hdr [2]byte
+func (t *transport) newConnection(tconn *net.TCPConn, raddr tcpAddr, dialerID uint64) *connection {
+	c := &connection{
+		transport: t,
+		raddr:     raddr,
+		conn:      tconn,
+		bufr:      bufio.NewReader(tconn),
+		dialerID:  dialerID,
+	}
+	c.cndRead = sync.NewCond(&c.mtxRead)
+	c.touch()
+	return c
+}
 
-	c.mtxRead.Lock()
-	defer c.mtxRead.Unlock()
+// newHandle returns a new reference to c. The caller must hold
+// transport.mtx.
+func (c *connection) newHandle() *handle {
+	c.refs++
+	return &handle{c: c}
+}
+
+// writeHello writes the hello frame. It must be written before the
+// connection is used.
+func (c *connection) writeHello() error {
+	var frame [4 + helloLen]byte
+	binary.BigEndian.PutUint16(frame[2:], helloLen)
+	binary.BigEndian.PutUint64(frame[4:], c.transport.id)
+	binary.BigEndian.PutUint16(frame[12:], c.transport.laddr.GetPort())
+
+	_, err := c.conn.Write(frame[:])
+	return err
+}
+
+// readHello reads the hello frame. ok is false when the peer didn't send one.
+func (c *connection) readHello() (id uint64, port uint16, ok bool, err error) {
+	var frame [2 + helloLen]byte
+
+	hdr, err := c.bufr.Peek(2)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if binary.BigEndian.Uint16(hdr) != 0 {
+		return 0, 0, false, nil
+	}
+
+	_, err = c.bufr.Discard(2)
+	if err != nil {
+		return 0, 0, false, err
+	}
 
-	_, err = io.ReadFull(c.bufr, hdr[:])
+	_, err = io.ReadFull(c.bufr, frame[:])
 	if err != nil {
-		return 0, err
+		return 0, 0, false, err
+	}
+	if binary.BigEndian.Uint16(frame[:]) != helloLen {
+		return 0, 0, false, errors.New("tcp: invalid hello frame")
 	}
 
-	msgLen := binary.BigEndian.Uint16(hdr[:])
+	id = binary.BigEndian.Uint64(frame[2:])
+	port = binary.BigEndian.Uint16(frame[10:])
+	return id, port, true, nil
+}
 
-	return io.ReadFull(c.bufr, b[:msgLen])
+// writeReply tells the dialing side whether the connection was accepted. The
+// caller must hold c.mtxWrite.
+func (c *connection) writeReply(accepted bool) error {
+	var frame [5]byte
+	binary.BigEndian.PutUint16(frame[2:], 1)
+	if accepted {
+		frame[4] = replyAccepted
+	} else {
+		frame[4] = replyRejected
+	}
+
+	_, err := c.conn.Write(frame[:])
+	return err
 }
 
-func (c *connection) Write(b []byte) (n int, err error) {
-	var lenB = len(b)
-	if lenB > 1472 {
+// readReply reads the reply to the hello frame.
+func (c *connection) readReply() (accepted bool, err error) {
+	var frame [5]byte
+
+	_, err = io.ReadFull(c.bufr, frame[:])
+	if err != nil {
+		return false, err
+	}
+	if binary.BigEndian.Uint16(frame[:]) != 0 || binary.BigEndian.Uint16(frame[2:]) != 1 {
+		return false, errors.New("tcp: invalid hello reply")
+	}
+
+	return frame[4] == replyAccepted, nil
+}
+
+// readFrames reads the frames of the connection until it fails. Every frame
+// is read by a single handle.
+func (c *connection) readFrames() {
+	var hdr [2]byte
+
+	for {
+		_, err := io.ReadFull(c.bufr, hdr[:])
+		if err != nil {
+			c.failed(err)
+			return
+		}
+
+		frame := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+		_, err = io.ReadFull(c.bufr, frame)
+		if err != nil {
+			c.failed(err)
+			return
+		}
+
+		c.touch()
+
+		c.mtxRead.Lock()
+		c.frames = append(c.frames, frame)
+		c.cndRead.Broadcast()
+		c.mtxRead.Unlock()
+	}
+}
+
+// failed removes the connection from the pool and closes it. Its handles
+// return err once they read all the pending frames.
+func (c *connection) failed(err error) {
+	c.mtxRead.Lock()
+	c.readErr = err
+	c.cndRead.Broadcast()
+	c.mtxRead.Unlock()
+
+	c.transport.mtx.Lock()
+	c.transport.remove(c)
+	c.transport.mtx.Unlock()
+
+	c.conn.Close()
+}
+
+func (c *connection) touch() {
+	atomic.StoreInt64(&c.lastUsed, time.Now().UnixNano())
+}
+
+func (c *connection) getLastUsed() int64 {
+	return atomic.LoadInt64(&c.lastUsed)
+}
+
+func (h *handle) Read(b []byte) (n int, err error) {
+	c := h.c
+
+	c.mtxRead.Lock()
+	defer c.mtxRead.Unlock()
+
+	for {
+		switch {
+		case h.closed:
+			return 0, net.ErrClosed
+		case !h.deadline.IsZero() && !time.Now().Before(h.deadline):
+			return 0, &net.OpError{Op: "read", Net: "tcp", Addr: c.raddr, Err: os.ErrDeadlineExceeded}
+		case len(c.frames) > 0:
+			frame := c.frames[0]
+			c.frames[0] = nil
+			c.frames = c.frames[1:]
+
+			// drop frames which are larger than b (like a datagram which
+			// exceeds the MTU); path MTU discovery relies on this.
+			if len(frame) <= len(b) {
+				return copy(b, frame), nil
+			}
+		case c.readErr != nil:
+			return 0, c.readErr
+		default:
+			c.cndRead.Wait()
+		}
+	}
+}
+
+func (h *handle) Write(b []byte) (n int, err error) {
+	var (
+		c    = h.c
+		lenB = len(b)
+	)
+
+	if lenB > c.transport.maxFrameSize {
 		return 0, io.ErrShortWrite
 	}
+	if h.isClosed() {
+		return 0, net.ErrClosed
+	}
 
 	var hdr [2]byte
 	var hdrP = hdr[:]
@@ -209,29 +820,90 @@ func (c *connection) Write(b []byte) (n int, err error) {
 		b = b[n:]
 	}
 
+	c.touch()
 	return lenB, nil
 }
 
-func (c *connection) SetDeadline(t time.Time) error {
-	return c.conn.SetDeadline(t)
+// MaxMessageSize returns the maximum frame size of the transport.
+func (h *handle) MaxMessageSize() int {
+	return h.c.transport.maxFrameSize
 }
 
-func (c *connection) SetReadDeadline(t time.Time) error {
-	return c.conn.SetReadDeadline(t)
+func (h *handle) SetDeadline(t time.Time) error {
+	h.SetReadDeadline(t)
+	return h.SetWriteDeadline(t)
 }
 
-func (c *connection) SetWriteDeadline(t time.Time) error {
-	return c.conn.SetWriteDeadline(t)
+// SetReadDeadline sets the read deadline of this handle only.
+func (h *handle) SetReadDeadline(t time.Time) error {
+	c := h.c
+
+	c.mtxRead.Lock()
+	defer c.mtxRead.Unlock()
+
+	h.deadline = t
+	if h.deadlineTimer != nil {
+		h.deadlineTimer.Stop()
+		h.deadlineTimer = nil
+	}
+	if !t.IsZero() {
+		h.deadlineTimer = time.AfterFunc(time.Until(t), func() {
+			c.mtxRead.Lock()
+			c.cndRead.Broadcast()
+			c.mtxRead.Unlock()
+		})
+	}
+
+	c.cndRead.Broadcast()
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of the shared connection.
+func (h *handle) SetWriteDeadline(t time.Time) error {
+	return h.c.conn.SetWriteDeadline(t)
 }
 
-func (c *connection) LocalAddr() net.Addr {
-	return c.transport.laddr
+func (h *handle) LocalAddr() net.Addr {
+	return h.c.transport.laddr
 }
 
-func (c *connection) RemoteAddr() net.Addr {
-	return c.raddr
+func (h *handle) RemoteAddr() net.Addr {
+	return h.c.raddr
+}
+
+// Close releases the handle. The connection is closed when its last handle is
+// closed.
+func (h *handle) Close() error {
+	c := h.c
+
+	c.mtxRead.Lock()
+	if h.closed {
+		c.mtxRead.Unlock()
+		return nil
+	}
+	h.closed = true
+	if h.deadlineTimer != nil {
+		h.deadlineTimer.Stop()
+	}
+	c.cndRead.Broadcast()
+	c.mtxRead.Unlock()
+
+	c.transport.mtx.Lock()
+	c.refs--
+	last := c.refs == 0
+	if last {
+		c.transport.remove(c)
+	}
+	c.transport.mtx.Unlock()
+
+	if last {
+		return c.conn.Close()
+	}
+	return nil
 }
 
-func (c *connection) Close() error {
-	return c.conn.Close()
+func (h *handle) isClosed() bool {
+	h.c.mtxRead.Lock()
+	defer h.c.mtxRead.Unlock()
+	return h.closed
 }
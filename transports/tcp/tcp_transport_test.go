@@ -2,10 +2,16 @@ package tcp
 
 import (
 	"bytes"
+	"encoding/binary"
+	"io"
 	"net"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports"
 )
 
 func TestLocalAddresses(t *testing.T) {
@@ -31,6 +37,478 @@ func TestLocalAddresses(t *testing.T) {
 	}
 }
 
+func TestLargeFrames(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Config{MaxFrameSize: 1000}.Open()
+	assert.Error(err)
+	_, err = Config{MaxFrameSize: 70000}.Open()
+	assert.Error(err)
+
+	A, err := Config{Addr: "127.0.0.1:0", MaxFrameSize: 32 * 1024}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Config{Addr: "127.0.0.1:0", MaxFrameSize: 32 * 1024}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	w, err := A.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+	defer w.Close()
+
+	assert.Equal(32*1024, transports.MaxMessageSize(w))
+
+	msg := bytes.Repeat([]byte{'x'}, 30000)
+	_, err = w.Write(msg)
+	assert.NoError(err)
+	_, err = w.Write(make([]byte, 32*1024+1))
+	assert.Equal(io.ErrShortWrite, err)
+	_, err = w.Write(msg[:1000])
+	assert.NoError(err)
+
+	r, err := B.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+	defer r.Close()
+
+	var buf [32 * 1024]byte
+	n, err := r.Read(buf[:])
+	if assert.NoError(err) {
+		assert.Equal(msg, buf[:n])
+	}
+	n, err = r.Read(buf[:])
+	if assert.NoError(err) {
+		assert.Equal(1000, n)
+	}
+
+	// frames larger than the read buffer are dropped
+	_, err = w.Write(msg)
+	assert.NoError(err)
+	_, err = w.Write(msg[:500])
+	assert.NoError(err)
+
+	n, err = r.Read(buf[:1500])
+	if assert.NoError(err) {
+		assert.Equal(500, n)
+	}
+}
+
+func TestConnectionReuse(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Addr: "127.0.0.1:0", EnableReuse: true}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Config{Addr: "127.0.0.1:0", EnableReuse: true}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	w, err := A.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+
+	w2, err := A.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+	assert.True(sameConn(w, w2), "outbound connections are reused")
+
+	r, err := B.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+	defer r.Close()
+
+	// the accepted connection is known by the address A listens on
+	assert.True(transports.EqualAddr(A.Addrs()[0], r.RemoteAddr()))
+
+	r2, err := B.Dial(A.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+	defer r2.Close()
+	assert.True(sameConn(r, r2), "inbound connections are reused")
+
+	testExchange(t, w, r)
+	testExchange(t, r2, w2)
+
+	// closing a handle leaves the connection open for the other handles
+	assert.NoError(w.Close())
+	_, err = w.Write([]byte("hello"))
+	assert.Error(err)
+	testExchange(t, w2, r)
+	testExchange(t, r, w2)
+
+	// the connection is closed with its last handle
+	assert.NoError(w2.Close())
+	r.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = r.Read(make([]byte, 1500))
+	assert.Equal(io.EOF, err)
+
+	w3, err := A.Dial(B.Addrs()[0])
+	if assert.NoError(err) {
+		assert.False(sameConn(w, w3))
+		w3.Close()
+	}
+
+	// without reuse every Dial opens a new connection
+	C, err := Config{Addr: "127.0.0.1:0"}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer C.Close()
+
+	w4, err := C.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+	defer w4.Close()
+
+	w5, err := C.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+	defer w5.Close()
+
+	assert.False(sameConn(w4, w5))
+}
+
+func TestLegacyPeers(t *testing.T) {
+	assert := assert.New(t)
+
+	// a peer which doesn't know the hello frame
+	legacy, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if !assert.NoError(err) {
+		return
+	}
+	defer legacy.Close()
+
+	A, err := Config{Addr: "127.0.0.1:0"}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Config{Addr: "127.0.0.1:0", EnableReuse: true}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	// without reuse the first frame is the first message
+	w, err := A.Dial(legacy.Addr())
+	if !assert.NoError(err) {
+		return
+	}
+	defer w.Close()
+
+	_, err = w.Write([]byte("hello"))
+	assert.NoError(err)
+
+	conn, err := legacy.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn.Close()
+
+	var frame [7]byte
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(conn, frame[:])
+	if assert.NoError(err) {
+		assert.Equal("\x00\x05hello", string(frame[:]))
+	}
+
+	// a transport without reuse replies to the hello of one with reuse
+	w, err = B.Dial(A.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+	defer w.Close()
+
+	r, err := A.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+	defer r.Close()
+
+	testExchange(t, w, r)
+	testExchange(t, r, w)
+
+	// and the other way around; the connection is accepted once the first
+	// message arrives
+	w, err = A.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+	defer w.Close()
+
+	r = accept(t, w, B)
+	defer r.Close()
+
+	testExchange(t, w, r)
+	testExchange(t, r, w)
+}
+
+func TestSimultaneousOpen(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		testSimultaneousOpen(t)
+	}
+}
+
+func testSimultaneousOpen(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Addr: "127.0.0.1:0", EnableReuse: true}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Config{Addr: "127.0.0.1:0", EnableReuse: true}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	for _, x := range []transports.Transport{A, B} {
+		go func(x transports.Transport) {
+			for {
+				if _, err := x.Accept(); err != nil {
+					return
+				}
+			}
+		}(x)
+	}
+
+	// dial both ways without looking at the pool
+	var (
+		tA, tB     = A.(*transport), B.(*transport)
+		wA, wB     *handle
+		errA, errB error
+		wg         sync.WaitGroup
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		wA, errA = tA.dial(tB.laddr)
+	}()
+	go func() {
+		defer wg.Done()
+		wB, errB = tB.dial(tA.laddr)
+	}()
+	wg.Wait()
+	if !assert.NoError(errA) || !assert.NoError(errB) {
+		return
+	}
+	defer wA.Close()
+	defer wB.Close()
+
+	// both sides were handed the same connection
+	assert.Equal(wA.c.dialerID, wB.c.dialerID)
+	testExchange(t, wA, wB)
+	testExchange(t, wB, wA)
+}
+
+func TestSpoofedHello(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Addr: "127.0.0.1:0", EnableReuse: true}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Config{Addr: "127.0.0.1:0", EnableReuse: true}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	w, err := A.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+	defer w.Close()
+
+	r, err := B.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+	defer r.Close()
+
+	// a hello claiming to be A with the highest id
+	spoofed, err := net.DialTCP("tcp", nil, B.Addrs()[0].(tcpAddr).ToTCPAddr())
+	if !assert.NoError(err) {
+		return
+	}
+	defer spoofed.Close()
+
+	var hello [4 + helloLen]byte
+	binary.BigEndian.PutUint16(hello[2:], helloLen)
+	binary.BigEndian.PutUint64(hello[4:], ^uint64(0))
+	binary.BigEndian.PutUint16(hello[12:], A.(*transport).laddr.GetPort())
+	_, err = spoofed.Write(hello[:])
+	assert.NoError(err)
+
+	s, err := B.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+	defer s.Close()
+	assert.False(sameConn(r, s))
+
+	// the pooled connection is still used
+	r2, err := B.Dial(A.Addrs()[0])
+	if assert.NoError(err) {
+		assert.True(sameConn(r, r2))
+		testExchange(t, r2, w)
+		r2.Close()
+	}
+	testExchange(t, w, r)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Addr: "127.0.0.1:0", IdleTimeout: 100 * time.Millisecond}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Config{Addr: "127.0.0.1:0", IdleTimeout: -1}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	w, err := A.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+
+	r := accept(t, w, B)
+	r.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = r.Read(make([]byte, 1500))
+	assert.Equal(io.EOF, err)
+	assert.Equal(0, numConns(A.(*transport)))
+
+	w2, err := A.Dial(B.Addrs()[0])
+	if assert.NoError(err) {
+		assert.False(sameConn(w, w2))
+		w2.Close()
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	assert := assert.New(t)
+
+	B, err := Config{Addr: "127.0.0.1:0", MaxConns: 1}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	var (
+		w [2]net.Conn
+		r [2]net.Conn
+	)
+	for i := range w {
+		A, err := Config{Addr: "127.0.0.1:0"}.Open()
+		if !assert.NoError(err) {
+			return
+		}
+		defer A.Close()
+
+		w[i], err = A.Dial(B.Addrs()[0])
+		if !assert.NoError(err) {
+			return
+		}
+
+		r[i] = accept(t, w[i], B)
+	}
+
+	// the least recently used connection was closed
+	w[0].SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = w[0].Read(make([]byte, 1500))
+	assert.Equal(io.EOF, err)
+	assert.Equal(1, numConns(B.(*transport)))
+
+	testExchange(t, w[1], r[1])
+}
+
+// accept writes a message to w and returns the connection accepted by B.
+// Connections without a hello frame are accepted once their first message
+// arrives.
+func accept(t *testing.T, w net.Conn, B transports.Transport) net.Conn {
+	_, err := w.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := B.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf [1500]byte
+	r.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := r.Read(buf[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatalf("invalid message")
+	}
+
+	return r
+}
+
+// sameConn returns true when a and b are handles of the same connection.
+func sameConn(a, b net.Conn) bool {
+	return a.(*handle).c == b.(*handle).c
+}
+
+func numConns(t *transport) int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return len(t.conns)
+}
+
+func testExchange(t *testing.T, w, r net.Conn) {
+	msg := bytes.Repeat([]byte{'x'}, 1450)
+
+	_, err := w.Write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf [1500]byte
+	r.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := r.Read(buf[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf[:n], msg) {
+		t.Fatalf("invalid message")
+	}
+}
+
 func Benchmark(b *testing.B) {
 	A, err := Config{}.Open()
 	if err != nil {
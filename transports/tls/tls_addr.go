@@ -0,0 +1,123 @@
+package tls
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/nat"
+)
+
+func init() {
+	transports.RegisterAddr(&tlsAddr{})
+
+	transports.RegisterResolver("tls", func(str string) (net.Addr, error) {
+		host, _, err := net.SplitHostPort(str)
+		if err != nil {
+			return nil, err
+		}
+
+		addr, err := net.ResolveTCPAddr("tcp", str)
+		if err != nil {
+			return nil, err
+		}
+
+		var name string
+		if net.ParseIP(host) == nil {
+			name = host
+		}
+
+		return &tlsAddr{IP: addr.IP, Port: addr.Port, ServerName: name}, nil
+	})
+}
+
+// tlsAddr is the address of a TLS transport. ServerName is sent (SNI) and
+// verified (when the dialer has RootCAs) when dialing the address.
+//
+//	{"type":"tls","ip":"192.0.2.1","port":443,"name":"example.com"}
+type tlsAddr struct {
+	IP         net.IP
+	Port       int
+	ServerName string
+}
+
+var (
+	_ transports.AddrMarshaler = (*tlsAddr)(nil)
+	_ nat.Addr                 = (*tlsAddr)(nil)
+)
+
+func (a *tlsAddr) Network() string { return "tls" }
+
+func (a *tlsAddr) String() string {
+	s := net.JoinHostPort(a.IP.String(), strconv.Itoa(a.Port))
+	if a.ServerName != "" {
+		s = a.ServerName + "@" + s
+	}
+	return s
+}
+
+func (a *tlsAddr) ToTCPAddr() *net.TCPAddr {
+	return &net.TCPAddr{IP: a.IP, Port: a.Port}
+}
+
+func (a *tlsAddr) IsIPv6() bool {
+	return a.IP.To4() == nil
+}
+
+func (a *tlsAddr) Equal(other net.Addr) bool {
+	b, ok := other.(*tlsAddr)
+	if !ok {
+		return false
+	}
+	return a.IP.Equal(b.IP) && a.Port == b.Port && a.ServerName == b.ServerName
+}
+
+func (a *tlsAddr) MarshalJSON() ([]byte, error) {
+	var desc = struct {
+		Type string `json:"type"`
+		IP   string `json:"ip"`
+		Port int    `json:"port"`
+		Name string `json:"name,omitempty"`
+	}{
+		Type: a.Network(),
+		IP:   a.IP.String(),
+		Port: a.Port,
+		Name: a.ServerName,
+	}
+
+	return json.Marshal(&desc)
+}
+
+func (a *tlsAddr) UnmarshalJSON(data []byte) error {
+	var desc struct {
+		IP   string `json:"ip"`
+		Port int    `json:"port"`
+		Name string `json:"name"`
+	}
+
+	err := json.Unmarshal(data, &desc)
+	if err != nil {
+		return transports.ErrInvalidAddr
+	}
+
+	ip := net.ParseIP(desc.IP)
+	if ip == nil || ip.IsUnspecified() {
+		return transports.ErrInvalidAddr
+	}
+
+	if desc.Port <= 0 || desc.Port > 65535 {
+		return transports.ErrInvalidAddr
+	}
+
+	*a = tlsAddr{IP: ip, Port: desc.Port, ServerName: desc.Name}
+	return nil
+}
+
+func (a *tlsAddr) InternalAddr() (proto string, ip net.IP, port int) {
+	return "tcp", a.IP, a.Port
+}
+
+func (a *tlsAddr) MakeGlobal(ip net.IP, port int) net.Addr {
+	return &tlsAddr{IP: ip, Port: port, ServerName: a.ServerName}
+}
@@ -0,0 +1,358 @@
+// Package tls implements a TCP transport wrapped in TLS.
+//
+// Messages are framed like in the tcp transport (a 2 byte length prefix). The
+// transport is meant for networks which only let TLS (on port 443) through;
+// peers are authenticated by the telehash handshake, so certificates are not
+// verified unless RootCAs is set.
+//
+//	e3x.New(keys, mux.Config{
+//	  udp.Config{},
+//	  tls.Config{Addr: ":443"},
+//	})
+package tls
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	cryptotls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/transportsutil"
+)
+
+// DefaultProto is the ALPN protocol negotiated when Config.NextProtos is empty.
+const DefaultProto = "telehash"
+
+// Config for the TLS transport. Typically the zero value is sufficient to get
+// started (a self-signed certificate is generated).
+type Config struct {
+	// Can be set to TCPv4, TCPv6 or can be left blank.
+	// Defaults to TCPv4
+	Network string
+
+	// Can be set to an address and/or port.
+	// The zero value will bind it to a random port while listening on all interfaces.
+	Addr string
+
+	// Certificates presented to dialing peers. A self-signed certificate is
+	// generated when empty.
+	Certificates []cryptotls.Certificate
+
+	// When set the certificates of dialed peers are verified against RootCAs
+	// (using the server name of the address).
+	RootCAs *x509.CertPool
+
+	// The server name included in the addresses of the transport. Dialing
+	// peers send it (SNI) and verify the certificate against it.
+	ServerName string
+
+	// The ALPN protocols to negotiate. Defaults to DefaultProto.
+	NextProtos []string
+}
+
+const (
+	// TCPv4 is used for IPv4 TCP networks
+	TCPv4 = "tcp4"
+	// TCPv6 is used for IPv6 TCP networks
+	TCPv6 = "tcp6"
+)
+
+const (
+	dialTimeout      = 10 * time.Second
+	handshakeTimeout = 10 * time.Second
+)
+
+type transport struct {
+	net        string
+	laddr      *tlsAddr
+	listener   net.Listener
+	serverName string
+	config     *cryptotls.Config
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+type connection struct {
+	transport *transport
+	raddr     *tlsAddr
+	conn      *cryptotls.Conn
+	bufr      *bufio.Reader
+	mtxWrite  sync.Mutex
+	mtxRead   sync.Mutex
+}
+
+var (
+	_ transports.Transport = (*transport)(nil)
+	_ transports.Config    = Config{}
+)
+
+// Open opens the transport.
+func (c Config) Open() (transports.Transport, error) {
+	if c.Network == "" {
+		c.Network = TCPv4
+	}
+	if c.Addr == "" {
+		c.Addr = ":0"
+	}
+	if len(c.NextProtos) == 0 {
+		c.NextProtos = []string{DefaultProto}
+	}
+
+	if c.Network != TCPv4 && c.Network != TCPv6 {
+		return nil, errors.New("tls: Network must be either `tcp4` or `tcp6`")
+	}
+
+	addr, err := net.ResolveTCPAddr(c.Network, c.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.Certificates) == 0 {
+		cert, err := selfSignedCertificate(c.ServerName)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []cryptotls.Certificate{cert}
+	}
+
+	config := &cryptotls.Config{
+		Certificates: c.Certificates,
+		RootCAs:      c.RootCAs,
+		NextProtos:   c.NextProtos,
+		MinVersion:   cryptotls.VersionTLS12,
+	}
+
+	listener, err := net.ListenTCP(c.Network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	addr = listener.Addr().(*net.TCPAddr)
+
+	return &transport{
+		net:        c.Network,
+		laddr:      &tlsAddr{IP: addr.IP, Port: addr.Port, ServerName: c.ServerName},
+		listener:   cryptotls.NewListener(listener, config),
+		serverName: c.ServerName,
+		config:     config,
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// selfSignedCertificate generates a certificate for name (which may be empty).
+func selfSignedCertificate(name string) (cryptotls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return cryptotls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return cryptotls.Certificate{}, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"telehash"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if name != "" {
+		tmpl.DNSNames = []string{name}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return cryptotls.Certificate{}, err
+	}
+
+	return cryptotls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+func (t *transport) Addrs() []net.Addr {
+	var addrs []net.Addr
+
+	if !t.laddr.IP.IsUnspecified() {
+		addrs = append(addrs, t.laddr)
+		return addrs
+	}
+
+	ips, err := transportsutil.InterfaceIPs()
+	if err != nil {
+		return addrs
+	}
+
+	for _, ip := range ips {
+		addr := &tlsAddr{IP: ip.IP, Port: t.laddr.Port, ServerName: t.serverName}
+		if addr.IsIPv6() && t.net == TCPv6 || !addr.IsIPv6() && t.net == TCPv4 {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
+	x, ok := addr.(*tlsAddr)
+	if !ok {
+		return nil, transports.ErrInvalidAddr
+	}
+
+	config := t.config.Clone()
+	config.Certificates = nil
+	config.ServerName = x.ServerName
+	if config.RootCAs == nil {
+		// the peer is authenticated by the telehash handshake
+		config.InsecureSkipVerify = true
+	}
+
+	tconn, err := net.DialTimeout("tcp", x.ToTCPAddr().String(), dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// the handshake is performed by the first Write (or Read)
+	conn := cryptotls.Client(tconn, config)
+
+	return &connection{transport: t, raddr: x, conn: conn, bufr: bufio.NewReader(conn)}, nil
+}
+
+func (t *transport) Accept() (c net.Conn, err error) {
+	conn, err := t.listener.Accept()
+	if err != nil {
+		select {
+		case <-t.done:
+			return nil, io.EOF
+		default:
+			return nil, err
+		}
+	}
+
+	tconn := conn.(*cryptotls.Conn)
+	raddr := tconn.RemoteAddr().(*net.TCPAddr)
+
+	return &connection{
+		transport: t,
+		raddr:     &tlsAddr{IP: raddr.IP, Port: raddr.Port},
+		conn:      tconn,
+		bufr:      bufio.NewReader(tconn),
+	}, nil
+}
+
+func (t *transport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.done)
+		err = t.listener.Close()
+	})
+	return err
+}
+
+func (c *connection) Read(b []byte) (n int, err error) {
+	var hdr [2]byte
+
+	c.mtxRead.Lock()
+	defer c.mtxRead.Unlock()
+
+	err = c.handshake()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		_, err = io.ReadFull(c.bufr, hdr[:])
+		if err != nil {
+			return 0, err
+		}
+
+		msgLen := int(binary.BigEndian.Uint16(hdr[:]))
+		if msgLen <= len(b) {
+			return io.ReadFull(c.bufr, b[:msgLen])
+		}
+
+		// drop frames which are larger than b (like a datagram which
+		// exceeds the MTU); path MTU discovery relies on this.
+		_, err = c.bufr.Discard(msgLen)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (c *connection) Write(b []byte) (n int, err error) {
+	var lenB = len(b)
+	if lenB > transports.DefaultMaxMessageSize {
+		return 0, io.ErrShortWrite
+	}
+
+	// write the header and the message in a single TLS record
+	var buf = make([]byte, 2+lenB)
+	binary.BigEndian.PutUint16(buf, uint16(lenB))
+	copy(buf[2:], b)
+
+	c.mtxWrite.Lock()
+	defer c.mtxWrite.Unlock()
+
+	err = c.handshake()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = c.conn.Write(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	return lenB, nil
+}
+
+// handshake runs the TLS handshake (unless it completed already) and fails
+// when it doesn't complete within handshakeTimeout.
+func (c *connection) handshake() error {
+	if c.conn.ConnectionState().HandshakeComplete {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+
+	return c.conn.HandshakeContext(ctx)
+}
+
+func (c *connection) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *connection) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *connection) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *connection) LocalAddr() net.Addr {
+	return c.transport.laddr
+}
+
+func (c *connection) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *connection) Close() error {
+	return c.conn.Close()
+}
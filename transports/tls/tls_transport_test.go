@@ -0,0 +1,127 @@
+package tls
+
+import (
+	"net"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/mux"
+	"github.com/telehash/gogotelehash/transports/transportstest"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestLocalAddresses(t *testing.T) {
+	assert := assert.New(t)
+	var tab = []Config{
+		{},
+		{Network: "tcp4", Addr: "127.0.0.1:0"},
+		{Network: "tcp4", Addr: ":0", ServerName: "example.com"},
+		{Network: "tcp6", Addr: ":0"},
+	}
+
+	for _, factory := range tab {
+		trans, err := factory.Open()
+		if assert.NoError(err) && assert.NotNil(trans) {
+			addrs := trans.Addrs()
+			assert.NotEmpty(addrs)
+
+			t.Logf("factory=%v addrs=%v", factory, addrs)
+			err = trans.Close()
+			assert.NoError(err)
+		}
+	}
+}
+
+func TestAddr(t *testing.T) {
+	assert := assert.New(t)
+
+	transportstest.Addr(t, "tls", "127.0.0.1:443", "",
+		`{"type":"tls","ip":"127.0.0.1","port":443}`)
+
+	named := &tlsAddr{IP: net.ParseIP("192.0.2.1"), Port: 443, ServerName: "example.com"}
+	data, err := transports.EncodeAddr(named)
+	if assert.NoError(err) {
+		assert.Equal(`{"type":"tls","ip":"192.0.2.1","port":443,"name":"example.com"}`, string(data))
+	}
+
+	decoded, err := transports.DecodeAddr(data)
+	if assert.NoError(err) {
+		assert.True(transports.EqualAddr(named, decoded))
+	}
+}
+
+func TestTransport(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Addr: "127.0.0.1:0"}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Config{Addr: "127.0.0.1:0"}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	w, r := transportstest.Transport(t, A, B)
+	defer w.Close()
+	defer r.Close()
+
+	state := w.(*connection).conn.ConnectionState()
+	assert.Equal(DefaultProto, state.NegotiatedProtocol)
+
+	// frames larger than the read buffer are dropped
+	_, err = w.Write(make([]byte, 1000))
+	assert.NoError(err)
+	_, err = w.Write([]byte("small"))
+	assert.NoError(err)
+
+	var buf [100]byte
+	n, err := r.Read(buf[:])
+	if assert.NoError(err) {
+		assert.Equal("small", string(buf[:n]))
+	}
+}
+
+func TestMismatchedProtos(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Addr: "127.0.0.1:0", NextProtos: []string{"other"}}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Config{Addr: "127.0.0.1:0"}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	go func() {
+		if c, err := B.Accept(); err == nil {
+			c.Read(make([]byte, 1500))
+			c.Close()
+		}
+	}()
+
+	w, err := A.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+	defer w.Close()
+
+	_, err = w.Write([]byte("hello"))
+	assert.Error(err)
+}
+
+func TestEndpoints(t *testing.T) {
+	// B can only reach A over TLS
+	transportstest.Endpoints(t,
+		mux.Config{udp.Config{Addr: "127.0.0.1:0"}, Config{Addr: "127.0.0.1:0"}},
+		Config{Addr: "127.0.0.1:0"})
+}
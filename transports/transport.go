@@ -36,6 +36,28 @@ type Transport interface {
 	Close() error
 }
 
+// DefaultMaxMessageSize is the largest message accepted by a connection
+// unless it implements MessageSizer.
+const DefaultMaxMessageSize = 1472
+
+// MessageSizer may be implemented by connections which accept messages larger
+// (or smaller) than DefaultMaxMessageSize, like the stream transports.
+type MessageSizer interface {
+	// MaxMessageSize returns the largest message that can be written to
+	// (and read from) the connection.
+	MaxMessageSize() int
+}
+
+// MaxMessageSize returns the largest message that can be written to conn.
+func MaxMessageSize(conn net.Conn) int {
+	if x, ok := conn.(MessageSizer); ok {
+		if n := x.MaxMessageSize(); n > 0 {
+			return n
+		}
+	}
+	return DefaultMaxMessageSize
+}
+
 func EqualAddr(a, b net.Addr) bool {
 	if a == nil && b == nil {
 		return true
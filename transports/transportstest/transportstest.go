@@ -0,0 +1,168 @@
+// Package transportstest provides the tests shared by the transports.
+package transportstest
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports"
+)
+
+// Addr resolves addr on network and checks that it round-trips through its
+// JSON encoding. The string form is only checked when str is not empty.
+func Addr(t *testing.T, network, addr, str, json string) net.Addr {
+	assert := assert.New(t)
+
+	resolved, err := transports.ResolveAddr(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(network, resolved.Network())
+	if str != "" {
+		assert.Equal(str, resolved.String())
+	}
+
+	data, err := transports.EncodeAddr(resolved)
+	if assert.NoError(err) {
+		assert.Equal(json, string(data))
+	}
+
+	decoded, err := transports.DecodeAddr(data)
+	if assert.NoError(err) {
+		assert.True(transports.EqualAddr(resolved, decoded))
+	}
+
+	return resolved
+}
+
+// Connect dials the first address of B from A and returns both ends of the
+// connection. B accepts while A dials.
+func Connect(t *testing.T, A, B transports.Transport) (w, r net.Conn) {
+	if len(B.Addrs()) == 0 {
+		t.Fatal("transport has no addresses")
+	}
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		r, _ := B.Accept()
+		accepted <- r
+	}()
+
+	w, err := A.Dial(B.Addrs()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r = <-accepted:
+	case <-time.After(10 * time.Second):
+		w.Close()
+		t.Fatal("timeout while accepting")
+	}
+	if r == nil {
+		w.Close()
+		t.Fatal("failed to accept")
+	}
+
+	return w, r
+}
+
+// Transport connects A to B, exchanges a message in both directions and
+// checks that oversized messages are refused. The connections are returned
+// for further checks; the caller must close them.
+func Transport(t *testing.T, A, B transports.Transport) (w, r net.Conn) {
+	w, r = Connect(t, A, B)
+
+	Exchange(t, w, r)
+	Exchange(t, r, w)
+
+	_, err := w.Write(make([]byte, transports.MaxMessageSize(w)+1))
+	assert.Error(t, err)
+
+	return w, r
+}
+
+// Exchange writes a message to w and expects to read it from r. The message
+// is written concurrently as some connections (like TLS) only complete their
+// handshake once the other side reads.
+func Exchange(t *testing.T, w, r net.Conn) {
+	msg := bytes.Repeat([]byte{'x'}, 1450)
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := w.Write(msg)
+		written <- err
+	}()
+
+	var buf [1500]byte
+	n, err := r.Read(buf[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf[:n], msg) {
+		t.Fatalf("invalid message")
+	}
+}
+
+// Endpoints opens an endpoint with each config and echoes a packet over a
+// reliable channel opened by the b endpoint. b must be able to reach a.
+func Endpoints(t *testing.T, a, b transports.Config) {
+	assert := assert.New(t)
+
+	A, err := e3x.Open(e3x.Transport(a), e3x.DisableLog())
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := e3x.Open(e3x.Transport(b), e3x.DisableLog())
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	go func() {
+		c, err := A.Listen("echo", true).AcceptChannel()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return
+		}
+		c.WritePacket(lob.New(pkt.Body(nil)))
+	}()
+
+	identA, err := A.LocalIdentity()
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := B.Open(identA, "echo", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Close()
+
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+
+	c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	pkt, err := c.ReadPacket()
+	if assert.NoError(err) {
+		assert.Equal("hello", string(pkt.Body(nil)))
+	}
+}
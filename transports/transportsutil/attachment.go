@@ -0,0 +1,46 @@
+package transportsutil
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrAttached is returned when a handler is passed to a second transport.
+var ErrAttached = errors.New("the Handler is already used by another transport")
+
+// Attachment binds a handler (like the http and ws Handlers) to the single
+// transport it is used by.
+type Attachment struct {
+	mtx   sync.RWMutex
+	owner interface{}
+}
+
+// Attach binds owner. ErrAttached is returned when another owner is bound.
+func (a *Attachment) Attach(owner interface{}) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.owner != nil && a.owner != owner {
+		return ErrAttached
+	}
+
+	a.owner = owner
+	return nil
+}
+
+// Detach unbinds owner. It does nothing when owner is not bound.
+func (a *Attachment) Detach(owner interface{}) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.owner == owner {
+		a.owner = nil
+	}
+}
+
+// Owner returns the bound owner or nil.
+func (a *Attachment) Owner() interface{} {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	return a.owner
+}
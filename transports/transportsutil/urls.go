@@ -0,0 +1,35 @@
+package transportsutil
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// ListenerURLs returns the URLs (with scheme and path) at which a listener on
+// laddr can be reached. An unspecified IP expands to the IPs of the local
+// interfaces.
+func ListenerURLs(laddr *net.TCPAddr, scheme, path string) []string {
+	var (
+		urls []string
+		ips  []net.IP
+	)
+
+	if !laddr.IP.IsUnspecified() {
+		ips = append(ips, laddr.IP)
+	} else if ifaces, err := InterfaceIPs(); err == nil {
+		for _, addr := range ifaces {
+			if addr.IP.To4() == nil && addr.IP.IsLinkLocalUnicast() {
+				continue // zones can't be expressed in a URL
+			}
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		u := url.URL{Scheme: scheme, Host: net.JoinHostPort(ip.String(), strconv.Itoa(laddr.Port)), Path: path}
+		urls = append(urls, u.String())
+	}
+
+	return urls
+}
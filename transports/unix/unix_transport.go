@@ -7,6 +7,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"net"
 	"os"
@@ -40,13 +41,28 @@ type Config struct {
 	// Mode is the mode for the socket.
 	// Deault to srwx------ (user only)
 	Mode os.FileMode
+
+	// The largest message (in bytes) that can be sent over a connection.
+	// Defaults to DefaultMaxFrameSize and can't exceed MaxFrameSize. Larger
+	// messages sent by the peer are dropped.
+	MaxFrameSize int
 }
 
+const (
+	// DefaultMaxFrameSize is the default maximum message size of a connection.
+	DefaultMaxFrameSize = 16 * 1024
+
+	// MaxFrameSize is the largest message size supported by the 2 byte
+	// length prefix.
+	MaxFrameSize = 0xffff
+)
+
 type unixAddr net.UnixAddr
 
 type transport struct {
-	laddr    *unixAddr
-	listener *net.UnixListener
+	laddr        *unixAddr
+	listener     *net.UnixListener
+	maxFrameSize int
 }
 
 type connection struct {
@@ -59,9 +75,10 @@ type connection struct {
 }
 
 var (
-	_ net.Addr             = (*unixAddr)(nil)
-	_ transports.Transport = (*transport)(nil)
-	_ transports.Config    = Config{}
+	_ net.Addr                = (*unixAddr)(nil)
+	_ transports.Transport    = (*transport)(nil)
+	_ transports.Config       = Config{}
+	_ transports.MessageSizer = (*connection)(nil)
 )
 
 // Open opens the transport.
@@ -76,6 +93,13 @@ func (c Config) Open() (transports.Transport, error) {
 	c.Mode &= os.ModePerm
 	c.Mode |= os.ModeSocket
 
+	if c.MaxFrameSize == 0 {
+		c.MaxFrameSize = DefaultMaxFrameSize
+	}
+	if c.MaxFrameSize < transports.DefaultMaxMessageSize || c.MaxFrameSize > MaxFrameSize {
+		return nil, errors.New("unix: MaxFrameSize must be between 1472 and 65535")
+	}
+
 	laddr, err := net.ResolveUnixAddr("unix", c.Name)
 	if err != nil {
 		return nil, err
@@ -93,7 +117,7 @@ func (c Config) Open() (transports.Transport, error) {
 		return nil, err
 	}
 
-	return &transport{(*unixAddr)(laddr), listener}, nil
+	return &transport{(*unixAddr)(laddr), listener, c.MaxFrameSize}, nil
 }
 
 // func (t *transport) ReadMessage(p []byte) (int, net.Addr, error) {
@@ -148,6 +172,9 @@ func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
 
 func (t *transport) Accept() (c net.Conn, err error) {
 	uconn, err := t.listener.AcceptUnix()
+	if errors.Is(err, net.ErrClosed) {
+		return nil, io.EOF
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -174,19 +201,29 @@ func (c *connection) Read(b []byte) (n int, err error) {
 	c.mtxRead.Lock()
 	defer c.mtxRead.Unlock()
 
-	_, err = io.ReadFull(c.bufr, hdr[:])
-	if err != nil {
-		return 0, err
-	}
+	for {
+		_, err = io.ReadFull(c.bufr, hdr[:])
+		if err != nil {
+			return 0, err
+		}
 
-	msgLen := binary.BigEndian.Uint16(hdr[:])
+		msgLen := int(binary.BigEndian.Uint16(hdr[:]))
+		if msgLen <= len(b) {
+			return io.ReadFull(c.bufr, b[:msgLen])
+		}
 
-	return io.ReadFull(c.bufr, b[:msgLen])
+		// drop frames which are larger than b (like a datagram which
+		// exceeds the MTU); path MTU discovery relies on this.
+		_, err = c.bufr.Discard(msgLen)
+		if err != nil {
+			return 0, err
+		}
+	}
 }
 
 func (c *connection) Write(b []byte) (n int, err error) {
 	var lenB = len(b)
-	if lenB > 1472 {
+	if lenB > c.transport.maxFrameSize {
 		return 0, io.ErrShortWrite
 	}
 
@@ -216,6 +253,11 @@ func (c *connection) Write(b []byte) (n int, err error) {
 	return lenB, nil
 }
 
+// MaxMessageSize returns the maximum frame size of the transport.
+func (c *connection) MaxMessageSize() int {
+	return c.transport.maxFrameSize
+}
+
 func (c *connection) SetDeadline(t time.Time) error {
 	return c.conn.SetDeadline(t)
 }
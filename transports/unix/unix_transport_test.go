@@ -2,10 +2,13 @@ package unix
 
 import (
 	"bytes"
+	"io"
 	"net"
 	"testing"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports"
 )
 
 func TestLocalAddresses(t *testing.T) {
@@ -30,6 +33,70 @@ func TestLocalAddresses(t *testing.T) {
 	}
 }
 
+func TestLargeFrames(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Config{MaxFrameSize: 1000}.Open()
+	assert.Error(err)
+	_, err = Config{MaxFrameSize: 70000}.Open()
+	assert.Error(err)
+
+	A, err := Config{MaxFrameSize: 32 * 1024}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Config{MaxFrameSize: 32 * 1024}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	w, err := A.Dial(B.Addrs()[0])
+	if !assert.NoError(err) {
+		return
+	}
+	defer w.Close()
+
+	assert.Equal(32*1024, transports.MaxMessageSize(w))
+
+	msg := bytes.Repeat([]byte{'x'}, 30000)
+	_, err = w.Write(msg)
+	assert.NoError(err)
+	_, err = w.Write(make([]byte, 32*1024+1))
+	assert.Equal(io.ErrShortWrite, err)
+	_, err = w.Write(msg[:1000])
+	assert.NoError(err)
+
+	r, err := B.Accept()
+	if !assert.NoError(err) {
+		return
+	}
+	defer r.Close()
+
+	var buf [32 * 1024]byte
+	n, err := r.Read(buf[:])
+	if assert.NoError(err) {
+		assert.Equal(msg, buf[:n])
+	}
+	n, err = r.Read(buf[:])
+	if assert.NoError(err) {
+		assert.Equal(1000, n)
+	}
+
+	// frames larger than the read buffer are dropped
+	_, err = w.Write(msg)
+	assert.NoError(err)
+	_, err = w.Write(msg[:500])
+	assert.NoError(err)
+
+	n, err = r.Read(buf[:1500])
+	if assert.NoError(err) {
+		assert.Equal(500, n)
+	}
+}
+
 func Benchmark(b *testing.B) {
 	A, err := Config{}.Open()
 	if err != nil {
@@ -0,0 +1,97 @@
+package ws
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/telehash/gogotelehash/transports"
+)
+
+func init() {
+	transports.RegisterAddr(&wsAddr{})
+
+	transports.RegisterResolver("ws", func(str string) (net.Addr, error) {
+		if !strings.Contains(str, "://") {
+			str = "ws://" + str
+		}
+		return parseAddr(str)
+	})
+}
+
+// wsAddr is the URL of a WebSocket endpoint:
+//
+//	{"type":"ws","url":"ws://example.com:8080/telehash"}
+type wsAddr struct {
+	url string
+}
+
+var _ transports.AddrMarshaler = (*wsAddr)(nil)
+
+func parseAddr(s string) (*wsAddr, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, transports.ErrInvalidAddr
+	}
+
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, transports.ErrInvalidAddr
+	}
+
+	if u.Host == "" || u.User != nil || u.Fragment != "" {
+		return nil, transports.ErrInvalidAddr
+	}
+
+	if u.Path == "" {
+		u.Path = DefaultPath
+	}
+
+	return &wsAddr{url: u.String()}, nil
+}
+
+func (a *wsAddr) Network() string { return "ws" }
+func (a *wsAddr) String() string  { return a.url }
+
+// secure returns true for wss:// addresses.
+func (a *wsAddr) secure() bool {
+	return strings.HasPrefix(a.url, "wss://")
+}
+
+func (a *wsAddr) Equal(other net.Addr) bool {
+	if b, ok := other.(*wsAddr); ok {
+		return a.url == b.url
+	}
+	return false
+}
+
+func (a *wsAddr) MarshalJSON() ([]byte, error) {
+	var desc = struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}{
+		Type: a.Network(),
+		URL:  a.url,
+	}
+
+	return json.Marshal(&desc)
+}
+
+func (a *wsAddr) UnmarshalJSON(data []byte) error {
+	var desc struct {
+		URL string `json:"url"`
+	}
+
+	err := json.Unmarshal(data, &desc)
+	if err != nil {
+		return transports.ErrInvalidAddr
+	}
+
+	addr, err := parseAddr(desc.URL)
+	if err != nil {
+		return err
+	}
+
+	*a = *addr
+	return nil
+}
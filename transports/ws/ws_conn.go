@@ -0,0 +1,327 @@
+package ws
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/transports"
+)
+
+// The subset of RFC 6455 needed to exchange telehash messages. Every message
+// is sent as a single binary frame; fragmented messages, pings and close
+// frames sent by other implementations are handled when reading.
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+
+	closeNormal        = 1000
+	closeProtocolError = 1002
+	closeUnsupported   = 1003
+	closeTooBig        = 1009
+
+	maxControlSize = 125
+
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var (
+	errProtocol   = errors.New("ws: protocol error")
+	errTextFrame  = errors.New("ws: unexpected text message")
+	errMessageBig = errors.New("ws: message too big")
+)
+
+type connection struct {
+	transport *transport
+	laddr     net.Addr
+	raddr     net.Addr
+	conn      net.Conn
+	bufr      *bufio.Reader
+	client    bool // client frames are masked
+	mtxWrite  sync.Mutex
+	mtxRead   sync.Mutex
+	closeOnce sync.Once
+	closeSent bool // protected by mtxWrite
+}
+
+func newConnection(t *transport, conn net.Conn, bufr *bufio.Reader, laddr, raddr net.Addr, client bool) *connection {
+	return &connection{
+		transport: t,
+		laddr:     laddr,
+		raddr:     raddr,
+		conn:      conn,
+		bufr:      bufr,
+		client:    client,
+	}
+}
+
+// acceptKey computes the Sec-WebSocket-Accept value for key.
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Read reads the next message into b.
+func (c *connection) Read(b []byte) (n int, err error) {
+	c.mtxRead.Lock()
+	defer c.mtxRead.Unlock()
+
+	var inMessage bool
+
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, err
+			}
+			continue
+
+		case opPong:
+			continue
+
+		case opClose:
+			c.writeClose(closeNormal)
+			return 0, io.EOF
+
+		case opText:
+			c.fail(closeUnsupported)
+			return 0, errTextFrame
+
+		case opBinary:
+			if inMessage {
+				c.fail(closeProtocolError)
+				return 0, errProtocol
+			}
+			inMessage, n = true, 0
+
+		case opContinuation:
+			if !inMessage {
+				c.fail(closeProtocolError)
+				return 0, errProtocol
+			}
+
+		default:
+			c.fail(closeProtocolError)
+			return 0, errProtocol
+		}
+
+		if n+len(payload) > len(b) || n+len(payload) > transports.DefaultMaxMessageSize {
+			c.fail(closeTooBig)
+			return 0, errMessageBig
+		}
+		n += copy(b[n:], payload)
+
+		if fin {
+			return n, nil
+		}
+	}
+}
+
+// readFrame reads a single frame and returns its unmasked payload.
+func (c *connection) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+
+	_, err = io.ReadFull(c.bufr, hdr[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = hdr[0]&0x80 != 0
+	op = hdr[0] & 0x0f
+	masked := hdr[1]&0x80 != 0
+	length := uint64(hdr[1] & 0x7f)
+
+	if hdr[0]&0x70 != 0 || masked == c.client {
+		// reserved bits are set or the frame is (not) masked (client to
+		// server frames must be masked, server to client frames must not be)
+		c.fail(closeProtocolError)
+		return false, 0, nil, errProtocol
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.bufr, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.bufr, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if op >= opClose && (!fin || length > maxControlSize) {
+		c.fail(closeProtocolError)
+		return false, 0, nil, errProtocol
+	}
+
+	if length > transports.DefaultMaxMessageSize {
+		c.fail(closeTooBig)
+		return false, 0, nil, errMessageBig
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.bufr, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.bufr, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return fin, op, payload, nil
+}
+
+// Write sends b as a single binary message.
+func (c *connection) Write(b []byte) (n int, err error) {
+	if len(b) > transports.DefaultMaxMessageSize {
+		return 0, io.ErrShortWrite
+	}
+
+	err = c.writeFrame(opBinary, b)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (c *connection) writeFrame(op byte, payload []byte) error {
+	var (
+		frame = make([]byte, 0, 14+len(payload))
+		lenP  = len(payload)
+	)
+
+	frame = append(frame, 0x80|op)
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+
+	switch {
+	case lenP < 126:
+		frame = append(frame, maskBit|byte(lenP))
+	case lenP <= 0xffff:
+		frame = append(frame, maskBit|126, byte(lenP>>8), byte(lenP))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(lenP))
+		frame = append(frame, maskBit|127)
+		frame = append(frame, ext[:]...)
+	}
+
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		for i, x := range payload {
+			frame = append(frame, x^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	c.mtxWrite.Lock()
+	defer c.mtxWrite.Unlock()
+
+	if c.closeSent {
+		return io.EOF
+	}
+	if op == opClose {
+		c.closeSent = true
+	}
+
+	for len(frame) > 0 {
+		n, err := c.conn.Write(frame)
+		if err != nil {
+			return err
+		}
+		frame = frame[n:]
+	}
+
+	return nil
+}
+
+// writeClose sends a close frame with code (unless one was sent already).
+func (c *connection) writeClose(code uint16) {
+	var payload [2]byte
+	binary.BigEndian.PutUint16(payload[:], code)
+
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrame(opClose, payload[:])
+}
+
+// fail sends a close frame with code and closes the connection.
+func (c *connection) fail(code uint16) {
+	c.writeClose(code)
+	c.closeConn()
+}
+
+func (c *connection) closeConn() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.conn.Close()
+	})
+	return err
+}
+
+func (c *connection) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *connection) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *connection) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *connection) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+func (c *connection) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+// Close sends a close frame and closes the underlying connection.
+func (c *connection) Close() error {
+	c.writeClose(closeNormal)
+	return c.closeConn()
+}
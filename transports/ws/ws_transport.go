@@ -0,0 +1,378 @@
+// Package ws implements the WebSocket transport.
+//
+// Each telehash message is sent as a single binary WebSocket message. The
+// transport can listen on its own:
+//
+//	e3x.New(keys, ws.Config{Addr: ":8080"})
+//
+// or accept connections from a Handler mounted on an existing http.Server:
+//
+//	h := ws.NewHandler()
+//	http.Handle("/telehash", h)
+//	e3x.New(keys, ws.Config{Handler: h, URLs: []string{"wss://example.com/telehash"}})
+package ws
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/transportsutil"
+)
+
+// DefaultPath is the path at which the transport serves WebSocket connections
+// when it listens on its own.
+const DefaultPath = "/telehash"
+
+const handshakeTimeout = 10 * time.Second
+
+// Config for the WebSocket transport. The zero value listens on a random port
+// on all interfaces.
+type Config struct {
+	// Can be set to an address and/or port (like the tcp transport).
+	// Ignored when Handler is set.
+	Addr string
+
+	// The path at which connections are accepted. Defaults to DefaultPath.
+	// Ignored when Handler is set.
+	Path string
+
+	// When set the transport does not listen on its own. Instead it accepts
+	// the connections upgraded by Handler (which must be mounted on an
+	// http.Server by the caller).
+	Handler *Handler
+
+	// The ws:// or wss:// URLs at which the transport is reachable. Required
+	// when Handler is set; otherwise the URLs are derived from the listener.
+	URLs []string
+
+	// Used to dial wss:// URLs. When it holds certificates the transport
+	// serves wss:// connections on its own listener.
+	TLSConfig *tls.Config
+}
+
+type transport struct {
+	addrs     []net.Addr
+	listener  net.Listener
+	server    *http.Server
+	handler   *Handler
+	tlsConfig *tls.Config
+
+	accept    chan *connection
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Handler is an http.Handler which upgrades requests to WebSocket connections
+// and passes them to the transport it is used by.
+type Handler struct {
+	attachment transportsutil.Attachment
+}
+
+var (
+	_ transports.Transport = (*transport)(nil)
+	_ transports.Config    = Config{}
+	_ http.Handler         = (*Handler)(nil)
+)
+
+// NewHandler returns a handler which can be passed to a single transport (in
+// Config.Handler).
+func NewHandler() *Handler {
+	return &Handler{}
+}
+
+// Open opens the transport.
+func (c Config) Open() (transports.Transport, error) {
+	t := &transport{
+		tlsConfig: c.TLSConfig,
+		accept:    make(chan *connection),
+		done:      make(chan struct{}),
+	}
+
+	for _, s := range c.URLs {
+		addr, err := parseAddr(s)
+		if err != nil {
+			return nil, errors.New("ws: invalid URL " + s)
+		}
+		t.addrs = append(t.addrs, addr)
+	}
+
+	if c.Handler != nil {
+		if len(t.addrs) == 0 {
+			return nil, errors.New("ws: URLs are required when using a Handler")
+		}
+
+		err := c.Handler.attachment.Attach(t)
+		if err != nil {
+			return nil, err
+		}
+
+		t.handler = c.Handler
+		return t, nil
+	}
+
+	if c.Addr == "" {
+		c.Addr = ":0"
+	}
+	if c.Path == "" {
+		c.Path = DefaultPath
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		return nil, errors.New("ws: Path must start with a `/`")
+	}
+
+	listener, err := net.Listen("tcp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "ws"
+	if c.TLSConfig != nil && (len(c.TLSConfig.Certificates) > 0 || c.TLSConfig.GetCertificate != nil) {
+		scheme = "wss"
+		listener = tls.NewListener(listener, c.TLSConfig)
+	}
+
+	if len(t.addrs) == 0 {
+		for _, u := range transportsutil.ListenerURLs(listener.Addr().(*net.TCPAddr), scheme, c.Path) {
+			t.addrs = append(t.addrs, &wsAddr{url: u})
+		}
+	}
+
+	h := NewHandler()
+	h.attachment.Attach(t)
+	t.handler = h
+
+	mux := http.NewServeMux()
+	mux.Handle(c.Path, h)
+
+	t.listener = listener
+	t.server = &http.Server{Handler: mux}
+	go t.server.Serve(listener)
+
+	return t, nil
+}
+
+func (t *transport) Addrs() []net.Addr {
+	return append([]net.Addr(nil), t.addrs...)
+}
+
+func (t *transport) Dial(addr net.Addr) (net.Conn, error) {
+	x, ok := addr.(*wsAddr)
+	if !ok {
+		return nil, transports.ErrInvalidAddr
+	}
+
+	select {
+	case <-t.done:
+		return nil, io.EOF
+	default:
+	}
+
+	u, err := url.Parse(x.url)
+	if err != nil {
+		return nil, transports.ErrInvalidAddr
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		if x.secure() {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: handshakeTimeout}
+
+	var conn net.Conn
+	if x.secure() {
+		cfg := &tls.Config{}
+		if t.tlsConfig != nil {
+			cfg = t.tlsConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, cfg)
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bufr, err := clientHandshake(conn, u)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return newConnection(t, conn, bufr, t.localAddr(), x, true), nil
+}
+
+// clientHandshake sends the upgrade request for u over conn and verifies the
+// response.
+func clientHandshake(conn net.Conn, u *url.URL) (*bufio.Reader, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	req := &http.Request{
+		Method:     "GET",
+		URL:        &url.URL{Path: u.Path, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+		Host: u.Host,
+	}
+
+	err := req.Write(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	bufr := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(bufr, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, errors.New("ws: unexpected handshake response: " + resp.Status)
+	}
+
+	if !headerHasToken(resp.Header, "Upgrade", "websocket") ||
+		!headerHasToken(resp.Header, "Connection", "upgrade") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, errors.New("ws: invalid handshake response")
+	}
+
+	return bufr, nil
+}
+
+func (t *transport) Accept() (c net.Conn, err error) {
+	select {
+	case conn := <-t.accept:
+		return conn, nil
+	case <-t.done:
+		return nil, io.EOF
+	}
+}
+
+func (t *transport) Close() error {
+	var err error
+
+	t.closeOnce.Do(func() {
+		close(t.done)
+		t.handler.attachment.Detach(t)
+
+		if t.server != nil {
+			err = t.server.Close()
+		}
+	})
+
+	return err
+}
+
+func (t *transport) localAddr() net.Addr {
+	if len(t.addrs) > 0 {
+		return t.addrs[0]
+	}
+	return nil
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection. Service
+// Unavailable is returned when the handler is not used by an open transport.
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	t, _ := h.attachment.Owner().(*transport)
+	if t == nil {
+		http.Error(rw, "telehash transport is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	if req.Method != "GET" {
+		rw.Header().Set("Allow", "GET")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if !headerHasToken(req.Header, "Upgrade", "websocket") ||
+		!headerHasToken(req.Header, "Connection", "upgrade") ||
+		key == "" {
+		http.Error(rw, "expected a WebSocket handshake", http.StatusBadRequest)
+		return
+	}
+
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		rw.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(rw, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		http.Error(rw, "connection can't be upgraded", http.StatusInternalServerError)
+		return
+	}
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+
+	// the server may have set deadlines on the hijacked connection
+	conn.SetDeadline(time.Time{})
+
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	err = brw.Flush()
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	raddr := &wsAddr{url: (&url.URL{Scheme: "ws", Host: conn.RemoteAddr().String(), Path: "/"}).String()}
+	c := newConnection(t, conn, brw.Reader, t.localAddr(), raddr, false)
+
+	select {
+	case t.accept <- c:
+	case <-t.done:
+		c.Close()
+	}
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
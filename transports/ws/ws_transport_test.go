@@ -0,0 +1,87 @@
+package ws
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/transportstest"
+)
+
+func TestAddr(t *testing.T) {
+	assert := assert.New(t)
+
+	transportstest.Addr(t, "ws", "127.0.0.1:8080",
+		"ws://127.0.0.1:8080/telehash",
+		`{"type":"ws","url":"ws://127.0.0.1:8080/telehash"}`)
+
+	_, err := transports.DecodeAddr([]byte(`{"type":"ws","url":"http://127.0.0.1/"}`))
+	assert.Equal(transports.ErrInvalidAddr, err)
+}
+
+func TestTransport(t *testing.T) {
+	assert := assert.New(t)
+
+	A, err := Config{Addr: "127.0.0.1:0"}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	B, err := Config{Addr: "127.0.0.1:0"}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	if !assert.Equal(1, len(B.Addrs())) {
+		return
+	}
+	assert.True(strings.HasPrefix(B.Addrs()[0].String(), "ws://127.0.0.1:"))
+
+	w, r := transportstest.Transport(t, A, B)
+	w.Close()
+	r.Close()
+}
+
+func TestHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	h := NewHandler()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/telehash"
+
+	B, err := Config{Handler: h, URLs: []string{url}}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer B.Close()
+
+	_, err = Config{Handler: h, URLs: []string{url}}.Open()
+	assert.Error(err, "a handler can only be used by one transport")
+
+	A, err := Config{Addr: "127.0.0.1:0"}.Open()
+	if !assert.NoError(err) {
+		return
+	}
+	defer A.Close()
+
+	w, r := transportstest.Connect(t, A, B)
+	defer w.Close()
+
+	transportstest.Exchange(t, w, r)
+
+	// closing the accepted side ends the dialed side
+	r.Close()
+	_, err = w.Read(make([]byte, 1500))
+	assert.Error(err)
+}
+
+func TestEndpoints(t *testing.T) {
+	transportstest.Endpoints(t, Config{Addr: "127.0.0.1:0"}, Config{Addr: "127.0.0.1:0"})
+}